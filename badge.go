@@ -0,0 +1,88 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// Badge colours, matching shields.io.
+const badgeGreen = "#4c1"
+const badgeYellow = "#dfb317"
+const badgeRed = "#e05d44"
+
+// badgeColor returns the colour of the badge for coverage.
+func badgeColor(config coveragecheck.Config, coverage float64) string {
+	green, yellow := config.BadgeThresholds()
+	if coverage >= green {
+		return badgeGreen
+	}
+	if coverage >= yellow {
+		return badgeYellow
+	}
+	return badgeRed
+}
+
+// badgeTextWidth approximates the width in pixels of text in the badge's
+// 11px font, including padding.
+func badgeTextWidth(text string) int {
+	return 7*len(text) + 10
+}
+
+// makeBadge creates a self-contained SVG badge in the style of shields.io
+// showing coverage.
+func makeBadge(config coveragecheck.Config, coverage float64) string {
+	label := "coverage"
+	value := fmt.Sprintf("%.1f%%", coverage)
+	labelWidth := badgeTextWidth(label)
+	valueWidth := badgeTextWidth(value)
+	width := labelWidth + valueWidth
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+  <title>%[4]s: %[5]s</title>
+  <linearGradient id="s" x2="0" y2="100%%">
+    <stop offset="0" stop-color="#bbb" stop-opacity=".1"/>
+    <stop offset="1" stop-opacity=".1"/>
+  </linearGradient>
+  <clipPath id="r">
+    <rect width="%[1]d" height="20" rx="3" fill="#fff"/>
+  </clipPath>
+  <g clip-path="url(#r)">
+    <rect width="%[2]d" height="20" fill="#555"/>
+    <rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+    <rect width="%[1]d" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="%[7]g" y="14">%[4]s</text>
+    <text x="%[8]g" y="14">%[5]s</text>
+  </g>
+</svg>
+`, width, labelWidth, valueWidth, label, value, badgeColor(config, coverage),
+		float64(labelWidth)/2, float64(labelWidth)+float64(valueWidth)/2)
+}
+
+// writeBadge writes the badge requested by --badge, showing the total
+// statement coverage of blocks.
+func writeBadge(options Options, config coveragecheck.Config, blocks []coveragecheck.ProfileBlock) error {
+	if options.badgePath == "" {
+		return nil
+	}
+	_, _, coverage := coveragecheck.StatementCoverage(blocks)
+	if err := options.writeFile(options.badgePath, []byte(makeBadge(config, coverage)), 0644); err != nil {
+		return fmt.Errorf("failed writing badge to %v: %w", options.badgePath, err)
+	}
+	return nil
+}
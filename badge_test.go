@@ -0,0 +1,80 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestBadgeColor(t *testing.T) {
+	config := coveragecheck.Config{DefaultCoverage: 80}
+	assert.Equal(t, badgeGreen, badgeColor(config, 80))
+	assert.Equal(t, badgeYellow, badgeColor(config, 79.9))
+	assert.Equal(t, badgeYellow, badgeColor(config, 70))
+	assert.Equal(t, badgeRed, badgeColor(config, 69.9))
+}
+
+func TestMakeBadge(t *testing.T) {
+	expected := `<svg xmlns="http://www.w3.org/2000/svg" width="111" height="20" role="img" aria-label="coverage: 83.4%">
+  <title>coverage: 83.4%</title>
+  <linearGradient id="s" x2="0" y2="100%">
+    <stop offset="0" stop-color="#bbb" stop-opacity=".1"/>
+    <stop offset="1" stop-opacity=".1"/>
+  </linearGradient>
+  <clipPath id="r">
+    <rect width="111" height="20" rx="3" fill="#fff"/>
+  </clipPath>
+  <g clip-path="url(#r)">
+    <rect width="66" height="20" fill="#555"/>
+    <rect x="66" width="45" height="20" fill="#4c1"/>
+    <rect width="111" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="33" y="14">coverage</text>
+    <text x="88.5" y="14">83.4%</text>
+  </g>
+</svg>
+`
+	assert.Equal(t, expected, makeBadge(coveragecheck.Config{DefaultCoverage: 80}, 83.4))
+}
+
+func TestWriteBadge(t *testing.T) {
+	config := coveragecheck.Config{DefaultCoverage: 80}
+	blocks := []coveragecheck.ProfileBlock{{NumStatements: 3, Count: 1}, {NumStatements: 1, Count: 0}}
+	written := map[string]string{}
+	options := newTestOptions()
+	options.writeFile = func(path string, data []byte, perm os.FileMode) error {
+		written[path] = string(data)
+		return nil
+	}
+
+	// Nothing is written unless requested.
+	assert.Nil(t, writeBadge(options, config, blocks))
+	assert.Empty(t, written)
+
+	options.badgePath = "coverage.svg"
+	assert.Nil(t, writeBadge(options, config, blocks))
+	assert.Equal(t, map[string]string{"coverage.svg": makeBadge(config, 75)}, written)
+
+	options.writeFile = func(string, []byte, os.FileMode) error {
+		return errors.New("writeFile failed")
+	}
+	assert.ErrorContains(t, writeBadge(options, config, blocks), "failed writing badge to coverage.svg: writeFile failed")
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ViolationCoveringTests is used for Violation.Kind when a function is
+// executed by fewer tests than Rule.MinCoveringTests requires.
+const ViolationCoveringTests = "covering_tests"
+
+// Attribution maps from FunctionInfo.ID to the sorted names of the tests that
+// execute the function.  Functions that no test executes aren't included.
+type Attribution map[string][]string
+
+// ListTests returns the names of the tests, examples, and fuzz tests in the
+// package, using `go test --list`.  Benchmarks aren't included because
+// `go test` doesn't run them by default.
+func ListTests(options Options) ([]string, error) {
+	lines, err := options.CaptureOutput("go", "test", "--list", ".")
+	if err != nil {
+		return nil, err
+	}
+	// `go test --list` also outputs a summary line, e.g. "ok  example.com/mod".
+	testName := regexp.MustCompile(`^(Test|Example|Fuzz)\w*$`)
+	tests := []string{}
+	for _, line := range lines {
+		if testName.MatchString(line) {
+			tests = append(tests, line)
+		}
+	}
+	return tests, nil
+}
+
+// AttributeTests runs each test in the package separately, with its own
+// coverage profile, to find which tests execute each function in fInfoMap.
+// This runs `go test` once per test, so it's much slower than collecting
+// coverage for the whole package.
+func AttributeTests(options Options, fInfoMap FunctionInfoMap) (Attribution, error) {
+	tests, err := ListTests(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing tests: %w", err)
+	}
+	attribution := Attribution{}
+	for _, test := range tests {
+		blocks, err := testProfile(options, test)
+		if err != nil {
+			return nil, fmt.Errorf("failed running %v: %w", test, err)
+		}
+		for id, fi := range fInfoMap {
+			for _, block := range blocks {
+				if block.Count > 0 && block.Contains(fi) {
+					attribution[id] = append(attribution[id], test)
+					break
+				}
+			}
+		}
+	}
+	for id := range attribution {
+		sort.Strings(attribution[id])
+	}
+	return attribution, nil
+}
+
+// testProfile runs a single test and returns the blocks in its coverage
+// profile.
+func testProfile(options Options, test string) ([]ProfileBlock, error) {
+	file, err := options.CreateTemp("", "golang-coverage-check")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = options.CaptureOutput("go", "test", "--covermode", "set", "--run", "^"+test+"$", "--coverprofile", file.Name())
+	if err != nil {
+		return nil, err
+	}
+	profile, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	return ParseProfile(options.ModulePath, strings.Split(string(profile), "\n"))
+}
+
+// CheckCoveringTests sets CheckResult.CoveringTests from attribution, and
+// fails functions executed by fewer tests than the Rule.MinCoveringTests of
+// their matching rule, using the severity of that rule.  Returns a copy of
+// results; results isn't modified.
+func CheckCoveringTests(results []CheckResult, attribution Attribution) []CheckResult {
+	checked := []CheckResult{}
+	for _, result := range results {
+		result.CoveringTests = append([]string{}, attribution[result.Function.ID]...)
+		if result.Rule != nil && len(result.CoveringTests) < result.Rule.MinCoveringTests {
+			covering := ""
+			if len(result.CoveringTests) > 0 {
+				covering = ": covering tests: " + strings.Join(result.CoveringTests, ", ")
+			}
+			// Copy Violations so the caller's results aren't modified.
+			result.Violations = append(append([]Violation{}, result.Violations...), Violation{
+				Kind:   ViolationCoveringTests,
+				Actual: float64(len(result.CoveringTests)),
+				Limit:  float64(result.Rule.MinCoveringTests),
+				Message: fmt.Sprintf("%v: %d covering tests < minimum covering tests %d: matching rule is `%v`%s",
+					result.Coverage, len(result.CoveringTests), result.Rule.MinCoveringTests, *result.Rule, covering),
+			})
+			result.Passed = false
+		}
+		checked = append(checked, result)
+	}
+	return checked
+}
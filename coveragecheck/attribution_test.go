@@ -0,0 +1,208 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// attributionInputs returns a FunctionInfoMap and a CaptureOutput that lists
+// tests and writes a coverage profile for each test: TestGet executes Get,
+// ExampleAPI executes Get and Put, and TestNothing executes neither.
+func attributionInputs() (FunctionInfoMap, func(string, ...string) ([]string, error)) {
+	fInfoMap := FunctionInfoMap{}
+	for _, fi := range []FunctionInfo{
+		{ID: "example.com/mod.Get", Filename: "api.go", LineNumber: "10", Function: "Get", StartColumn: 1, EndLine: 14, EndColumn: 2},
+		{ID: "example.com/mod.Put", Filename: "api.go", LineNumber: "20", Function: "Put", StartColumn: 1, EndLine: 22, EndColumn: 2},
+		{ID: "example.com/mod.unused", Filename: "api.go", LineNumber: "30", Function: "unused", StartColumn: 1, EndLine: 32, EndColumn: 2},
+	} {
+		fInfoMap[fi.ID] = fi
+	}
+	profiles := map[string]string{
+		"^TestGet$":     "mode: set\nexample.com/mod/api.go:10.20,14.2 2 1\nexample.com/mod/api.go:20.20,22.2 1 0\n",
+		"^ExampleAPI$":  "mode: set\nexample.com/mod/api.go:10.20,14.2 2 1\nexample.com/mod/api.go:20.20,22.2 1 1\n",
+		"^TestNothing$": "mode: set\nexample.com/mod/api.go:10.20,14.2 2 0\n",
+	}
+	captureOutput := func(command string, args ...string) ([]string, error) {
+		if args[1] == "--list" {
+			return []string{"TestGet", "BenchmarkGet", "ExampleAPI", "TestNothing", "ok  \texample.com/mod\t0.01s", ""}, nil
+		}
+		// The test is after --run and the profile is the last arg.
+		return nil, os.WriteFile(args[len(args)-1], []byte(profiles[args[4]]), 0644)
+	}
+	return fInfoMap, captureOutput
+}
+
+func TestListTests(t *testing.T) {
+	_, captureOutput := attributionInputs()
+	options := newTestOptions()
+	options.CaptureOutput = captureOutput
+	tests, err := ListTests(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"TestGet", "ExampleAPI", "TestNothing"}, tests)
+
+	options.CaptureOutput = func(string, ...string) ([]string, error) {
+		return nil, errors.New("error for testing")
+	}
+	_, err = ListTests(options)
+	assert.ErrorContains(t, err, "error for testing")
+}
+
+func TestAttributeTests(t *testing.T) {
+	fInfoMap, captureOutput := attributionInputs()
+	options := newTestOptions()
+	options.ModulePath = "example.com/mod/"
+	options.CaptureOutput = captureOutput
+	attribution, err := AttributeTests(options, fInfoMap)
+	assert.Nil(t, err)
+	assert.Equal(t, Attribution{
+		"example.com/mod.Get": {"ExampleAPI", "TestGet"},
+		"example.com/mod.Put": {"ExampleAPI"},
+	}, attribution)
+}
+
+func TestAttributeTestsErrors(t *testing.T) {
+	fInfoMap, captureOutput := attributionInputs()
+	table := []struct {
+		desc string
+		err  string
+		mod  func(Options) Options
+	}{
+		{
+			desc: "listing tests fails",
+			err:  "failed listing tests: error for testing",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(string, ...string) ([]string, error) {
+					return nil, errors.New("error for testing")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "creating the profile fails",
+			err:  "failed running TestGet: error for testing",
+			mod: func(opts Options) Options {
+				opts.CreateTemp = func(string, string) (*os.File, error) {
+					return nil, errors.New("error for testing")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "running the test fails",
+			err:  "failed running TestGet: test failed",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(command string, args ...string) ([]string, error) {
+					if args[1] == "--list" {
+						return captureOutput(command, args...)
+					}
+					return nil, errors.New("test failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "reading the profile fails",
+			err:  "failed running TestGet: ",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(command string, args ...string) ([]string, error) {
+					if args[1] == "--list" {
+						return captureOutput(command, args...)
+					}
+					return nil, os.Remove(args[len(args)-1])
+				}
+				return opts
+			},
+		},
+		{
+			desc: "parsing the profile fails",
+			err:  "failed running TestGet: could not parse coverage profile line \"asdf\"",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(command string, args ...string) ([]string, error) {
+					if args[1] == "--list" {
+						return captureOutput(command, args...)
+					}
+					return nil, os.WriteFile(args[len(args)-1], []byte("asdf\n"), 0644)
+				}
+				return opts
+			},
+		},
+	}
+	for _, test := range table {
+		options := newTestOptions()
+		options.CaptureOutput = captureOutput
+		_, err := AttributeTests(test.mod(options), fInfoMap)
+		assert.ErrorContains(t, err, test.err, test.desc)
+	}
+}
+
+func TestCheckCoveringTests(t *testing.T) {
+	rule := Rule{FunctionRegex: "^(Get|Put)$", MinCoveringTests: 2, Severity: SeverityWarning}
+	results := []CheckResult{
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "10", Function: "Get", Coverage: 100},
+			Function: FunctionInfo{ID: "example.com/mod.Get"},
+			Rule:     &rule,
+			Passed:   true,
+		},
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "20", Function: "Put", Coverage: 100},
+			Function: FunctionInfo{ID: "example.com/mod.Put"},
+			Rule:     &rule,
+			Passed:   true,
+			Severity: SeverityWarning,
+		},
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "30", Function: "unused", Coverage: 0},
+			Function: FunctionInfo{ID: "example.com/mod.unused"},
+			Passed:   false,
+		},
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "40", Function: "Delete", Coverage: 0},
+			Function: FunctionInfo{ID: "example.com/mod.Delete"},
+			Rule:     &rule,
+			Passed:   false,
+			Severity: SeverityWarning,
+		},
+	}
+	attribution := Attribution{
+		"example.com/mod.Get": {"ExampleAPI", "TestGet"},
+		"example.com/mod.Put": {"ExampleAPI"},
+	}
+	checked := CheckCoveringTests(results, attribution)
+	assert.Equal(t, [][]string{{"ExampleAPI", "TestGet"}, {"ExampleAPI"}, {}, {}},
+		[][]string{checked[0].CoveringTests, checked[1].CoveringTests, checked[2].CoveringTests, checked[3].CoveringTests})
+	assert.True(t, checked[0].Passed)
+	assert.False(t, checked[1].Passed)
+	assert.Equal(t, StatusWarning, checked[1].Status())
+	assert.Equal(t, []Violation{{
+		Kind:    ViolationCoveringTests,
+		Actual:  1,
+		Limit:   2,
+		Message: "api.go:20:\tPut\t100.0%: 1 covering tests < minimum covering tests 2: matching rule is `FilenameRegex:  FunctionRegex: ^(Get|Put)$ ReceiverRegex:  MinCoveringTests: 2 Severity: warning Coverage: 0 Comment: `: covering tests: ExampleAPI",
+	}}, checked[1].Violations)
+	// Functions without a matching rule have no minimum.
+	assert.Empty(t, checked[2].Violations)
+	assert.Equal(t, "api.go:40:\tDelete\t0.0%: 0 covering tests < minimum covering tests 2: matching rule is `FilenameRegex:  FunctionRegex: ^(Get|Put)$ ReceiverRegex:  MinCoveringTests: 2 Severity: warning Coverage: 0 Comment: `",
+		checked[3].Violations[0].Message)
+	// results isn't modified.
+	assert.Nil(t, results[1].CoveringTests)
+	assert.Empty(t, results[1].Violations)
+	assert.True(t, results[1].Passed)
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ViolationRegression is used for Violation.Kind when a function's coverage is
+// lower than in the baseline.
+const ViolationRegression = "regression"
+
+// BaselineSchemaVersion is the version of the baseline format; it will be
+// incremented when incompatible changes are made.  Version 2 changed
+// FunctionInfo.ID to number functions like init within their file rather than
+// across the package.
+const BaselineSchemaVersion = 2
+
+// Baseline is a snapshot of the coverage of every function, used to check
+// that coverage doesn't decrease.
+type Baseline struct {
+	SchemaVersion int `json:"schema_version"`
+	// Functions maps from the keys returned by FunctionKeys to coverage.
+	Functions map[string]float64 `json:"functions"`
+}
+
+// String returns the baseline as JSON, in the format read by ParseBaseline.
+func (baseline Baseline) String() string {
+	// Baseline only contains types that can be marshalled.
+	bytes, _ := json.MarshalIndent(baseline, "", "  ")
+	return string(bytes) + "\n"
+}
+
+// FunctionKeys identifies each function in results so that it can be found in
+// a different run, using FunctionInfo.ID.  Functions that weren't found by
+// ParseFunctions don't have an ID, so they are identified by filename and
+// name instead, and functions with the same name in the same file are
+// distinguished by their order in the file, e.g. api.go:init and api.go:init#2.
+func FunctionKeys(results []CheckResult) []string {
+	seen := map[string]int{}
+	keys := []string{}
+	for _, result := range results {
+		if result.Function.ID != "" {
+			keys = append(keys, result.Function.ID)
+			continue
+		}
+		name := result.Coverage.Function
+		if result.Function.Receiver != "" {
+			name = result.Function.Receiver + "." + name
+		}
+		key := result.Coverage.Filename + ":" + name
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, seen[key])
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// MakeBaseline records the coverage of every function in results.
+func MakeBaseline(results []CheckResult) Baseline {
+	baseline := Baseline{
+		SchemaVersion: BaselineSchemaVersion,
+		Functions:     map[string]float64{},
+	}
+	for i, key := range FunctionKeys(results) {
+		baseline.Functions[key] = results[i].Coverage.Coverage
+	}
+	return baseline
+}
+
+// ParseBaseline parses a baseline written by Baseline.String.
+func ParseBaseline(data []byte) (Baseline, error) {
+	baseline := Baseline{}
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return baseline, err
+	}
+	if baseline.SchemaVersion == 1 {
+		return baseline, fmt.Errorf("schema_version 1 identified functions with the same name, like init, by their order in the package, so they can't be found reliably; rewrite the baseline")
+	}
+	if baseline.SchemaVersion != BaselineSchemaVersion {
+		return baseline, fmt.Errorf("unsupported schema_version %d", baseline.SchemaVersion)
+	}
+	return baseline, nil
+}
+
+// CheckBaseline adds a violation to every function in results whose coverage
+// is lower than in baseline, returning the updated results.  Functions that
+// aren't in baseline are new, so they aren't checked.  Like other violations,
+// the severity is from the matching rule.
+func CheckBaseline(results []CheckResult, baseline Baseline) []CheckResult {
+	checked := []CheckResult{}
+	for i, key := range FunctionKeys(results) {
+		result := results[i]
+		previous, ok := baseline.Functions[key]
+		if ok && result.Coverage.Coverage < previous {
+			// Copy Violations so the caller's results aren't modified.
+			result.Violations = append(append([]Violation{}, result.Violations...), Violation{
+				Kind:   ViolationRegression,
+				Actual: result.Coverage.Coverage,
+				Limit:  previous,
+				Message: fmt.Sprintf("%v: actual coverage %.1f%% < baseline coverage %.1f%%",
+					result.Coverage, result.Coverage.Coverage, previous),
+			})
+			result.Passed = false
+		}
+		checked = append(checked, result)
+	}
+	return checked
+}
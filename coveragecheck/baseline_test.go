@@ -0,0 +1,109 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func baselineTestResults() []CheckResult {
+	return []CheckResult{
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			Function: FunctionInfo{Receiver: "Client"},
+			Passed:   true,
+			Severity: SeverityError,
+		},
+		{
+			Coverage: CoverageLine{Filename: "init.go", LineNumber: "3", Function: "init", Coverage: 100},
+			Passed:   true,
+			Severity: SeverityError,
+		},
+		{
+			Coverage:   CoverageLine{Filename: "init.go", LineNumber: "9", Function: "init", Coverage: 20},
+			Passed:     false,
+			Severity:   SeverityWarning,
+			Violations: []Violation{{Kind: ViolationCoverage, Actual: 20, Limit: 50, Message: "init < 50%"}},
+		},
+		{
+			Coverage: CoverageLine{Filename: "new.go", LineNumber: "1", Function: "New", Coverage: 0},
+			Passed:   true,
+			Severity: SeverityError,
+		},
+	}
+}
+
+func TestFunctionKeys(t *testing.T) {
+	assert.Equal(t, []string{"api.go:Client.Get", "init.go:init", "init.go:init#2", "new.go:New"},
+		FunctionKeys(baselineTestResults()))
+}
+
+func TestBaselineRoundTrip(t *testing.T) {
+	baseline := MakeBaseline(baselineTestResults())
+	assert.Equal(t, Baseline{
+		SchemaVersion: BaselineSchemaVersion,
+		Functions:     map[string]float64{"api.go:Client.Get": 50, "init.go:init": 100, "init.go:init#2": 20, "new.go:New": 0},
+	}, baseline)
+	parsed, err := ParseBaseline([]byte(baseline.String()))
+	assert.Nil(t, err)
+	assert.Equal(t, baseline, parsed)
+}
+
+func TestParseBaselineErrors(t *testing.T) {
+	_, err := ParseBaseline([]byte("asdf"))
+	assert.ErrorContains(t, err, "invalid character")
+	_, err = ParseBaseline([]byte(`{"schema_version": 1}`))
+	assert.EqualError(t, err, "schema_version 1 identified functions with the same name, like init, by their order in the package, so they can't be found reliably; rewrite the baseline")
+	_, err = ParseBaseline([]byte(`{"schema_version": 3}`))
+	assert.EqualError(t, err, "unsupported schema_version 3")
+}
+
+func TestCheckBaseline(t *testing.T) {
+	results := baselineTestResults()
+	baseline := Baseline{
+		SchemaVersion: BaselineSchemaVersion,
+		Functions: map[string]float64{
+			"api.go:Client.Get": 60,
+			// Increased coverage is fine.
+			"init.go:init":   90,
+			"init.go:init#2": 25,
+			"removed.go:Old": 100,
+		},
+	}
+	checked := CheckBaseline(results, baseline)
+	assert.Equal(t, 4, len(checked))
+
+	assert.Equal(t, StatusFailed, checked[0].Status())
+	assert.Equal(t, []Violation{
+		{
+			Kind:    ViolationRegression,
+			Actual:  50,
+			Limit:   60,
+			Message: "api.go:12:\tGet\t50.0%: actual coverage 50.0% < baseline coverage 60.0%",
+		},
+	}, checked[0].Violations)
+	assert.Equal(t, StatusPassed, checked[1].Status())
+	// The severity comes from the matching rule.
+	assert.Equal(t, StatusWarning, checked[2].Status())
+	assert.Equal(t, []string{"init < 50%", "init.go:9:\tinit\t20.0%: actual coverage 20.0% < baseline coverage 25.0%"},
+		checked[2].Messages())
+	// New functions aren't checked.
+	assert.Equal(t, StatusPassed, checked[3].Status())
+
+	// The results passed in aren't modified.
+	assert.Equal(t, baselineTestResults(), results)
+}
@@ -0,0 +1,358 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants used for Violation.Kind.
+const ViolationCoverage = "coverage"
+const ViolationCrap = "crap"
+
+// Constants returned by CheckResult.Status.
+const StatusPassed = "passed"
+const StatusFailed = "failed"
+const StatusWarning = "warning"
+
+// Violation describes a requirement that a function doesn't meet.
+type Violation struct {
+	// Kind is ViolationCoverage when the function's coverage is too low,
+	// ViolationCrap when its CRAP score is too high, ViolationRegression when
+	// its coverage is lower than in the baseline, or ViolationCoveringTests when
+	// too few tests execute it.
+	Kind string
+	// Actual is the function's coverage, CRAP score, or number of covering
+	// tests.
+	Actual float64
+	// Limit is the required coverage, the maximum CRAP score, the coverage in
+	// the baseline, or the minimum number of covering tests.
+	Limit float64
+	// Message describes the violation for people, including the matching rule
+	// and the lines that were not executed.
+	Message string
+}
+
+// CheckResult is the result of checking a single function against the config.
+type CheckResult struct {
+	// Coverage is the coverage of the function.
+	Coverage CoverageLine
+	// Function is the information about the function from parsing the code.
+	Function FunctionInfo
+	// RuleIndex is the index of the matching rule in Config.Rules, or -1 if no
+	// rule matched and Config.DefaultCoverage was used.
+	RuleIndex int
+	// Rule is the matching rule, or nil if no rule matched.
+	Rule *Rule
+	// InheritedFrom is the name of the enclosing function when the function is
+	// a closure that no rule matched, so it was checked against the rule
+	// matching its enclosing function; empty otherwise.
+	InheritedFrom string
+	// RequiredCoverage is the coverage required by the matching rule or
+	// Config.DefaultCoverage.
+	RequiredCoverage float64
+	// CrapScore is the CRAP score of the function.
+	CrapScore float64
+	// MaxCrap is the maximum CRAP score allowed; zero means there is no maximum.
+	MaxCrap float64
+	// UncoveredLines contains the lines in the function that were not executed,
+	// e.g. "foo.go:120-128, 140".
+	UncoveredLines string
+	// Passed is true if the function meets every requirement.
+	Passed bool
+	// Severity of Violations, either SeverityError or SeverityWarning.
+	Severity string
+	// Violations contains each requirement the function doesn't meet.
+	Violations []Violation
+	// CoveringTests contains the names of the tests that execute the function;
+	// nil unless AttributeTests was run.  See CheckCoveringTests.
+	CoveringTests []string
+	// Warnings describe problems that don't affect Passed, e.g. a rule
+	// generated by GenerateConfig that matches several functions with
+	// different coverage.
+	Warnings []string
+}
+
+// Status summarises the result: StatusPassed if the function meets every
+// requirement, otherwise StatusFailed or StatusWarning depending on Severity.
+func (result CheckResult) Status() string {
+	if result.Passed {
+		return StatusPassed
+	}
+	if result.Severity == SeverityWarning {
+		return StatusWarning
+	}
+	return StatusFailed
+}
+
+// Messages returns the message of each violation.
+func (result CheckResult) Messages() []string {
+	messages := []string{}
+	for _, violation := range result.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return messages
+}
+
+// CheckCoverage checks that each function meets the required level of coverage
+// and the maximum CRAP score, returning a CheckResult for each function.
+// Violations include the lines in the function that were not executed, found
+// using blocks.
+func CheckCoverage(config Config, coverage []CoverageLine, fInfoMap FunctionInfoMap, blocks []ProfileBlock) []CheckResult {
+	results := []CheckResult{}
+	locations := fInfoMap.ByLocation()
+	for _, cov := range coverage {
+		fi, found := locations[FunctionLocationKey(cov.Filename, cov.LineNumber, cov.Function)]
+		result := CheckResult{
+			Coverage:         cov,
+			Function:         fi,
+			RuleIndex:        -1,
+			RequiredCoverage: config.DefaultCoverage,
+			CrapScore:        crapScore(fi.Complexity, cov.Coverage),
+			MaxCrap:          config.DefaultMaxCrap,
+			UncoveredLines:   uncoveredLines(blocks, fi),
+			Severity:         SeverityError,
+		}
+		if !found {
+			result.Warnings = append(result.Warnings,
+				fmt.Sprintf("%v: function wasn't found when parsing the code, so it has no CRAP score or uncovered lines, and rules using receiver_regex, exported, returns_error, param_count, or ordinal treat it as having no receiver, parameters, or results", cov))
+		}
+		uncovered := ""
+		if result.UncoveredLines != "" {
+			uncovered = ": uncovered lines: " + result.UncoveredLines
+		}
+		result.RuleIndex = matchingRule(config, cov, fi)
+		if result.RuleIndex < 0 && fi.Closure {
+			// Rules exempting a function also exempt its closures unless a rule
+			// matches the closure itself.
+			if parent, ok := enclosingFunction(fInfoMap, fi); ok {
+				parentCov := CoverageLine{Filename: parent.Filename, LineNumber: parent.LineNumber, Function: parent.Function}
+				result.RuleIndex = matchingRule(config, parentCov, parent)
+				if result.RuleIndex >= 0 {
+					result.InheritedFrom = parent.Function
+				}
+			}
+		}
+		result.Warnings = append(result.Warnings, oldReceiverRegexWarnings(config, cov, fi, result.RuleIndex)...)
+		if result.RuleIndex >= 0 {
+			rule := config.Rules[result.RuleIndex]
+			result.Rule = &config.Rules[result.RuleIndex]
+			result.RequiredCoverage = rule.Coverage
+			if rule.MaxCrap > 0 {
+				result.MaxCrap = rule.MaxCrap
+			}
+			if rule.Severity != "" {
+				result.Severity = rule.Severity
+			}
+		}
+
+		if cov.Coverage < result.RequiredCoverage {
+			message := fmt.Sprintf("%v: actual coverage %.1f%% < default coverage %.1f%%%s",
+				cov, cov.Coverage, result.RequiredCoverage, uncovered)
+			if result.Rule != nil {
+				message = fmt.Sprintf("%v: actual coverage %.1f%% < required coverage %.1f%%: matching rule is `%v`%s",
+					cov, cov.Coverage, result.RequiredCoverage, *result.Rule, uncovered)
+			}
+			result.Violations = append(result.Violations, Violation{
+				Kind:    ViolationCoverage,
+				Actual:  cov.Coverage,
+				Limit:   result.RequiredCoverage,
+				Message: message,
+			})
+		}
+		if result.MaxCrap > 0 && result.CrapScore > result.MaxCrap {
+			result.Violations = append(result.Violations, Violation{
+				Kind:   ViolationCrap,
+				Actual: result.CrapScore,
+				Limit:  result.MaxCrap,
+				Message: fmt.Sprintf("%v: CRAP score %.1f > maximum CRAP score %.1f: cyclomatic complexity is %d%s",
+					cov, result.CrapScore, result.MaxCrap, fi.Complexity, uncovered),
+			})
+		}
+		result.Passed = len(result.Violations) == 0
+		results = append(results, result)
+	}
+	return warnAboutGeneratedRules(results)
+}
+
+// matchingRule returns the index of the first rule in config that matches the
+// function, or -1 if no rule matches.
+func matchingRule(config Config, cov CoverageLine, fi FunctionInfo) int {
+	for i := range config.Rules {
+		if config.Rules[i].matches(cov, fi) {
+			return i
+		}
+	}
+	return -1
+}
+
+// oldReceiverRegexWarnings returns a warning for each rule before ruleIndex,
+// or every rule if ruleIndex is -1, that would match the method except that
+// its receiver_regex looks like it was written for the old format of
+// receivers.  Pointer and generic receivers used to be formatted like
+// &{123 T}, including the position in the file, so those rules stopped
+// matching when receivers became type names.
+func oldReceiverRegexWarnings(config Config, cov CoverageLine, fi FunctionInfo, ruleIndex int) []string {
+	warnings := []string{}
+	if fi.Receiver == "" {
+		return warnings
+	}
+	for i, rule := range config.Rules {
+		if ruleIndex >= 0 && i >= ruleIndex {
+			break
+		}
+		if !strings.Contains(rule.ReceiverRegex, "&") {
+			continue
+		}
+		rule.ReceiverRegex = ""
+		if rule.matches(cov, fi) {
+			warnings = append(warnings,
+				fmt.Sprintf("%v: rule `%v` doesn't match because receiver_regex looks like it was written for receivers formatted like `&{123 %s}`; receivers are now type names without pointers or type parameters, e.g. `%s`",
+					cov, config.Rules[i], fi.Receiver, fi.Receiver))
+		}
+	}
+	return warnings
+}
+
+// enclosingFunction finds the top-level function or method in fInfoMap that
+// contains closure, returning false if it isn't found.
+func enclosingFunction(fInfoMap FunctionInfoMap, closure FunctionInfo) (FunctionInfo, bool) {
+	name := strings.SplitN(closure.Function, ".", 2)[0]
+	for _, fi := range fInfoMap {
+		if !fi.Closure && fi.Filename == closure.Filename && fi.Receiver == closure.Receiver &&
+			fi.Function == name && fi.Line() <= closure.Line() && closure.Line() <= fi.EndLine {
+			return fi, true
+		}
+	}
+	return FunctionInfo{}, false
+}
+
+// warnAboutGeneratedRules adds a warning to each function matched by a rule
+// generated by GenerateConfig when the rule matches several functions with
+// different coverage, because the rule can only record one function's
+// coverage.  This happens when functions are added to a package that has
+// functions with the same name, like init, after generating the config.
+func warnAboutGeneratedRules(results []CheckResult) []CheckResult {
+	matched := map[int][]int{}
+	for i, result := range results {
+		if result.Rule != nil && result.Rule.isGenerated() {
+			matched[result.RuleIndex] = append(matched[result.RuleIndex], i)
+		}
+	}
+	for _, indices := range matched {
+		rule := results[indices[0]].Rule
+		different := false
+		for _, i := range indices {
+			different = different || results[i].Coverage.Coverage != results[indices[0]].Coverage.Coverage
+		}
+		if !different {
+			continue
+		}
+		for _, i := range indices {
+			if results[i].Coverage.Coverage != rule.Coverage {
+				results[i].Warnings = append(results[i].Warnings,
+					fmt.Sprintf("%v: generated rule `%v` matches %d functions with different coverage; regenerate the config to check them separately",
+						results[i].Coverage, *rule, len(indices)))
+			}
+		}
+	}
+	return results
+}
+
+// CheckOwnPackageCoverage replaces the result of each function whose matching
+// rule sets RequireOwnPackageTests with the function's result in own, which
+// must be the result of CheckCoverage using coverage from only the package's
+// own tests.  Functions that aren't in own are unchanged.
+func CheckOwnPackageCoverage(results, own []CheckResult) []CheckResult {
+	ownResults := map[string]CheckResult{}
+	for _, result := range own {
+		cov := result.Coverage
+		ownResults[FunctionLocationKey(cov.Filename, cov.LineNumber, cov.Function)] = result
+	}
+	checked := []CheckResult{}
+	for _, result := range results {
+		cov := result.Coverage
+		ownResult, ok := ownResults[FunctionLocationKey(cov.Filename, cov.LineNumber, cov.Function)]
+		if ok && result.Rule != nil && result.Rule.RequireOwnPackageTests {
+			result = ownResult
+		}
+		checked = append(checked, result)
+	}
+	return checked
+}
+
+// ViolationsError returns an error listing the violations of every function
+// that failed with SeverityError, or nil if there aren't any.  Violations with
+// SeverityWarning don't cause failure, so they aren't included.
+func ViolationsError(results []CheckResult) error {
+	messages := []string{}
+	for _, result := range results {
+		if result.Status() == StatusFailed {
+			messages = append(messages, result.Messages()...)
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+// DebugInfo describes how each function in results was matched to a rule and
+// whether it met each requirement; used by --debug_matching.
+func DebugInfo(results []CheckResult) []string {
+	debugInfo := []string{"Debug info for coverage matching"}
+	for _, result := range results {
+		cov := result.Coverage
+		debugInfo = append(debugInfo, fmt.Sprintf("- Line %v", cov))
+		if result.Rule != nil {
+			inherited := ""
+			if result.InheritedFrom != "" {
+				inherited = fmt.Sprintf(" (inherited from enclosing function %v)", result.InheritedFrom)
+			}
+			debugInfo = append(debugInfo, fmt.Sprintf("  - Matching rule%s: %v", inherited, *result.Rule))
+			comparison := ">="
+			if cov.Coverage < result.RequiredCoverage {
+				comparison = "<"
+			}
+			debugInfo = append(debugInfo,
+				fmt.Sprintf("  - actual coverage %.1f%% %s required coverage %.1f%%",
+					cov.Coverage, comparison, result.RequiredCoverage))
+		} else {
+			satisfied := "satisfied"
+			if cov.Coverage < result.RequiredCoverage {
+				satisfied = "not satisfied"
+			}
+			debugInfo = append(debugInfo,
+				fmt.Sprintf("  - Default coverage %.1f%% %s", result.RequiredCoverage, satisfied))
+		}
+		if result.MaxCrap > 0 {
+			comparison := "<="
+			if result.CrapScore > result.MaxCrap {
+				comparison = ">"
+			}
+			debugInfo = append(debugInfo,
+				fmt.Sprintf("  - CRAP score %.1f %s maximum CRAP score %.1f", result.CrapScore, comparison, result.MaxCrap))
+		}
+		if result.CoveringTests != nil {
+			tests := strings.Join(result.CoveringTests, ", ")
+			if tests == "" {
+				tests = "none"
+			}
+			debugInfo = append(debugInfo, fmt.Sprintf("  - Covering tests: %s", tests))
+		}
+	}
+	return debugInfo
+}
@@ -0,0 +1,810 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stripComments(input []string) []string {
+	output := []string{}
+	for _, line := range input {
+		if !strings.HasPrefix(line, "//") {
+			output = append(output, line)
+		}
+	}
+	return output
+}
+
+func TestCheckCoverage(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   Config
+		fInfoMap FunctionInfoMap
+		blocks   []ProfileBlock
+		input    []string
+		errors   []string
+		debug    []string
+	}{
+
+		{
+			desc: "Filename matching",
+			config: Config{
+				Rules: []Rule{
+					{
+						FilenameRegex: "^utils.go$",
+						Coverage:      100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"utils.go:1:	ReadFileOrDie	57.0%",
+				"// Matches, sufficient coverage.",
+				"utils.go:2:	ParseIntOrDie	100.0%",
+				"// Doesn't match, falls through to default.",
+				"main.go:1:	main	22.0%",
+			},
+			errors: []string{
+				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
+				"matching rule is `FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100 Comment: `",
+			},
+			debug: []string{
+				// First coverage line.
+				"Debug info for coverage matching",
+				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
+				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100",
+				"actual coverage 57.0% < required coverage 100.0%",
+				// Second coverage line.
+				"Line utils.go:2:\tParseIntOrDie\t100.0%",
+				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100 Comment:",
+				"actual coverage 100.0% >= required coverage 100.0%",
+				// Third coverage line.
+				"Line main.go:1:\tmain\t22.0%",
+				"Default coverage 0.0% satisfied",
+			},
+		},
+
+		{
+			desc: "Function matching",
+			config: Config{
+				Rules: []Rule{
+					{
+						FunctionRegex: "OrDie$",
+						Coverage:      100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"utils.go:1:	ReadFileOrDie	57.0%",
+				"// Matches, sufficient coverage.",
+				"utils.go:2:	ParseIntOrDie	100.0%",
+				"// Doesn't match, falls through to default.",
+				"main.go:1:	main	100.0%",
+			},
+			errors: []string{
+				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
+				"matching rule is `FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100 Comment: `",
+			},
+			debug: []string{
+				// First coverage line.
+				"Debug info for coverage matching",
+				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
+				"Matching rule: FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100",
+				"actual coverage 57.0% < required coverage 100.0%",
+				// Second coverage line.
+				"Line utils.go:2:\tParseIntOrDie\t100.0%",
+				"Matching rule: FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100 Comment:",
+				"actual coverage 100.0% >= required coverage 100.0%",
+				// Third coverage line.
+				"Line main.go:1:\tmain\t100.0%",
+				"Default coverage 0.0% satisfied",
+			},
+		},
+
+		{
+			desc: "Receiver matching",
+			config: Config{
+				Rules: []Rule{
+					{
+						ReceiverRegex: "^testReceiver$",
+						Coverage:      100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"utils.go:1:	Commit	57.0%",
+				"// Matches, sufficient coverage.",
+				"utils.go:2:	String	100.0%",
+				"// Doesn't match, falls through to default.",
+				"main.go:1:	main	100.0%",
+			},
+			fInfoMap: FunctionInfoMap{
+				"example.com/mod.testReceiver.Commit": {
+					ID:         "example.com/mod.testReceiver.Commit",
+					Filename:   "utils.go",
+					LineNumber: "1",
+					Function:   "Commit",
+					Receiver:   "testReceiver",
+				},
+				"example.com/mod.testReceiver.String": {
+					ID:         "example.com/mod.testReceiver.String",
+					Filename:   "utils.go",
+					LineNumber: "2",
+					Function:   "String",
+					Receiver:   "testReceiver",
+				},
+			},
+			errors: []string{
+				"utils.go:1:\tCommit\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
+				"matching rule is `FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100 Comment: `",
+			},
+			debug: []string{
+				// First coverage line.
+				"Debug info for coverage matching",
+				"Line utils.go:1:\tCommit\t57.0%\n",
+				"Matching rule: FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100",
+				"actual coverage 57.0% < required coverage 100.0%",
+				// Second coverage line.
+				"Line utils.go:2:\tString\t100.0%",
+				"Matching rule: FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100",
+				"actual coverage 100.0% >= required coverage 100.0%",
+				// Third coverage line.
+				"Line main.go:1:\tmain\t100.0%",
+				"Default coverage 0.0% satisfied",
+			},
+		},
+
+		{
+			desc: "Filename, Function, and Receiver matching",
+			config: Config{
+				Rules: []Rule{
+					{
+						FilenameRegex: "^utils.go$",
+						FunctionRegex: "^Commit$",
+						ReceiverRegex: "^testReceiver$",
+						Coverage:      100,
+					},
+					{
+						FilenameRegex: "^utils.go$",
+						FunctionRegex: "^String$",
+						ReceiverRegex: "^testReceiver$",
+						Coverage:      100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"utils.go:1:	Commit	57.0%",
+				"// Matches, sufficient coverage.",
+				"utils.go:2:	String	100.0%",
+				"// Doesn't match, falls through to default.",
+				"main.go:1:	main	100.0%",
+			},
+			fInfoMap: FunctionInfoMap{
+				"example.com/mod.testReceiver.Commit": {
+					ID:         "example.com/mod.testReceiver.Commit",
+					Filename:   "utils.go",
+					LineNumber: "1",
+					Function:   "Commit",
+					Receiver:   "testReceiver",
+				},
+				"example.com/mod.testReceiver.String": {
+					ID:         "example.com/mod.testReceiver.String",
+					Filename:   "utils.go",
+					LineNumber: "2",
+					Function:   "String",
+					Receiver:   "testReceiver",
+				},
+			},
+			errors: []string{
+				"utils.go:1:\tCommit\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
+				"matching rule is `FilenameRegex: ^utils.go$ FunctionRegex: ^Commit$ ReceiverRegex: ^testReceiver$ Coverage: 100",
+			},
+			debug: []string{
+				// First coverage line.
+				"Debug info for coverage matching",
+				"Line utils.go:1:\tCommit\t57.0%\n",
+				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex: ^Commit$ ReceiverRegex: ^testReceiver$ Coverage: 100",
+				"actual coverage 57.0% < required coverage 100.0%",
+				// Second coverage line.
+				"Line utils.go:2:\tString\t100.0%",
+				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex: ^String$ ReceiverRegex: ^testReceiver$ Coverage: 100",
+				"actual coverage 100.0% >= required coverage 100.0%",
+				// Third coverage line.
+				"Line main.go:1:\tmain\t100.0%",
+				"Default coverage 0.0% satisfied",
+			},
+		},
+
+		{
+			desc: "Exported, ReturnsError, and ParamCount matching",
+			config: Config{
+				DefaultCoverage: 50,
+				Rules: []Rule{
+					{
+						Exported:     boolPointer(true),
+						ReturnsError: boolPointer(true),
+						ParamCount:   intPointer(1),
+						Coverage:     100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"api.go:1:	Get	57.0%",
+				"// Unexported, falls through to default.",
+				"api.go:2:	get	57.0%",
+			},
+			fInfoMap: FunctionInfoMap{
+				"example.com/mod.Get": {
+					ID:           "example.com/mod.Get",
+					Filename:     "api.go",
+					LineNumber:   "1",
+					Function:     "Get",
+					Exported:     true,
+					ReturnsError: true,
+					ParamCount:   1,
+				},
+				"example.com/mod.get": {
+					ID:           "example.com/mod.get",
+					Filename:     "api.go",
+					LineNumber:   "2",
+					Function:     "get",
+					ReturnsError: true,
+					ParamCount:   1,
+				},
+			},
+			errors: []string{
+				"api.go:1:\tGet\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
+				"matching rule is `FilenameRegex:  FunctionRegex:  ReceiverRegex:  Exported: true ReturnsError: true ParamCount: 1 Coverage: 100",
+			},
+			debug: []string{
+				"Line api.go:1:\tGet\t57.0%\n",
+				"Matching rule: FilenameRegex:  FunctionRegex:  ReceiverRegex:  Exported: true ReturnsError: true ParamCount: 1 Coverage: 100",
+				"Line api.go:2:\tget\t57.0%",
+				"Default coverage 50.0% satisfied",
+			},
+		},
+
+		{
+			desc: "CRAP score",
+			config: Config{
+				DefaultMaxCrap: 10,
+				Rules: []Rule{
+					{
+						FunctionRegex: "^complex$",
+						MaxCrap:       30,
+					},
+					{
+						FunctionRegex: "^simple$",
+					},
+				},
+			},
+			input: []string{
+				"// Matches, CRAP score is 20 * 20 * 0.125 + 20 = 70.",
+				"api.go:1:	complex	50.0%",
+				"// Matches, uses default maximum, CRAP score is 2 * 2 * 1 + 2 = 6.",
+				"api.go:2:	simple	0.0%",
+				"// Doesn't match, uses default maximum, CRAP score is 4 * 4 * 1 + 4 = 20.",
+				"api.go:3:	other	0.0%",
+			},
+			fInfoMap: FunctionInfoMap{
+				"example.com/mod.complex": {ID: "example.com/mod.complex", Filename: "api.go", LineNumber: "1", Function: "complex", Complexity: 20},
+				"example.com/mod.simple":  {ID: "example.com/mod.simple", Filename: "api.go", LineNumber: "2", Function: "simple", Complexity: 2},
+				"example.com/mod.other":   {ID: "example.com/mod.other", Filename: "api.go", LineNumber: "3", Function: "other", Complexity: 4},
+			},
+			errors: []string{
+				"api.go:1:\tcomplex\t50.0%: CRAP score 70.0 > maximum CRAP score 30.0: cyclomatic complexity is 20",
+				"api.go:3:\tother\t0.0%: CRAP score 20.0 > maximum CRAP score 10.0: cyclomatic complexity is 4",
+			},
+			debug: []string{
+				"Line api.go:1:\tcomplex\t50.0%\n",
+				"CRAP score 70.0 > maximum CRAP score 30.0",
+				"Line api.go:2:\tsimple\t0.0%\n",
+				"CRAP score 6.0 <= maximum CRAP score 10.0",
+				"Line api.go:3:\tother\t0.0%\n",
+				"CRAP score 20.0 > maximum CRAP score 10.0",
+			},
+		},
+
+		{
+			desc: "Uncovered lines",
+			config: Config{
+				DefaultCoverage: 90,
+				DefaultMaxCrap:  1,
+				Rules: []Rule{
+					{
+						FunctionRegex: "^Get$",
+						Coverage:      100,
+					},
+				},
+			},
+			input: []string{
+				"// Matches, insufficient coverage.",
+				"api.go:1:	Get	50.0%",
+				"// Doesn't match, insufficient coverage.",
+				"api.go:10:	Put	50.0%",
+			},
+			fInfoMap: FunctionInfoMap{
+				"example.com/mod.Get": {ID: "example.com/mod.Get", Function: "Get", Filename: "api.go", LineNumber: "1", StartColumn: 1, EndLine: 8, EndColumn: 2, Complexity: 2},
+				"example.com/mod.Put": {ID: "example.com/mod.Put", Function: "Put", Filename: "api.go", LineNumber: "10", StartColumn: 1, EndLine: 18, EndColumn: 2, Complexity: 2},
+			},
+			blocks: []ProfileBlock{
+				{Filename: "api.go", StartLine: 1, StartColumn: 10, EndLine: 3, EndColumn: 2, NumStatements: 1, Count: 1},
+				{Filename: "api.go", StartLine: 4, StartColumn: 2, EndLine: 7, EndColumn: 3, NumStatements: 1, Count: 0},
+				{Filename: "api.go", StartLine: 10, StartColumn: 10, EndLine: 12, EndColumn: 2, NumStatements: 1, Count: 0},
+				{Filename: "api.go", StartLine: 16, StartColumn: 2, EndLine: 17, EndColumn: 3, NumStatements: 1, Count: 1},
+			},
+			errors: []string{
+				"api.go:1:\tGet\t50.0%: actual coverage 50.0% < required coverage 100.0%: matching rule is `FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 100 Comment: `: uncovered lines: api.go:4-7\n",
+				"api.go:1:\tGet\t50.0%: CRAP score 2.5 > maximum CRAP score 1.0: cyclomatic complexity is 2: uncovered lines: api.go:4-7\n",
+				"api.go:10:\tPut\t50.0%: actual coverage 50.0% < default coverage 90.0%: uncovered lines: api.go:10-12\n",
+			},
+			debug: []string{
+				"Line api.go:1:\tGet\t50.0%\n",
+			},
+		},
+
+		{
+			desc: "Default coverage",
+			config: Config{
+				DefaultCoverage: 90,
+			},
+			input: []string{
+				"// Insufficient coverage.",
+				"utils.go:1:	ReadFileOrDie	57.0%",
+				"// Sufficient coverage.",
+				"utils.go:2:	ParseIntOrDie	100.0%",
+			},
+			errors: []string{
+				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < default coverage 90.0%",
+			},
+			debug: []string{
+				// First coverage line.
+				"Debug info for coverage matching",
+				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
+				"Default coverage 90.0% not satisfied",
+				// Second coverage line.
+				"Line utils.go:2:\tParseIntOrDie\t100.0%",
+				"Default coverage 90.0% satisfied",
+			},
+		},
+
+		{
+			desc: "No errors",
+			config: Config{
+				DefaultCoverage: 90,
+			},
+			input: []string{
+				"// Sufficient coverage.",
+				"utils.go:2:	ParseIntOrDie	100.0%",
+			},
+			errors: []string{},
+			debug: []string{
+				// First coverage line.
+				"Line utils.go:2:\tParseIntOrDie\t100.0%",
+				"Default coverage 90.0% satisfied",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		coverage, err := ParseCoverageOutput("", stripComments(test.input))
+		assert.Nil(t, err)
+		config, err := validateConfig(test.config)
+		assert.Nil(t, err)
+
+		results := CheckCoverage(config, coverage, test.fInfoMap, test.blocks)
+		err = ViolationsError(results)
+		debug := DebugInfo(results)
+		if len(test.errors) == 0 {
+			assert.Nil(t, err)
+		} else {
+			for i := range test.errors {
+				assert.ErrorContains(t, err, test.errors[i], "err: "+test.desc)
+			}
+		}
+		debugStr := strings.Join(debug, "\n")
+		for i := range test.debug {
+			assert.Contains(t, debugStr, test.debug[i], "debug: "+test.desc)
+		}
+	}
+}
+
+func TestCheckOwnPackageCoverage(t *testing.T) {
+	own := Rule{FunctionRegex: "^Get$", RequireOwnPackageTests: true}
+	other := Rule{FunctionRegex: "^Put$"}
+	results := []CheckResult{
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "10", Function: "Get", Coverage: 100}, Rule: &own, Passed: true},
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "20", Function: "Put", Coverage: 100}, Rule: &other, Passed: true},
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "30", Function: "Delete", Coverage: 100}, Passed: true},
+		// Not in the results from the package's own tests.
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "40", Function: "Get", Coverage: 100}, Rule: &own, Passed: true},
+	}
+	ownResults := []CheckResult{
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "10", Function: "Get", Coverage: 20}, Rule: &own, Passed: false},
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "20", Function: "Put", Coverage: 30}, Rule: &other, Passed: false},
+		{Coverage: CoverageLine{Filename: "api.go", LineNumber: "30", Function: "Delete", Coverage: 40}, Passed: false},
+	}
+	assert.Equal(t, []CheckResult{ownResults[0], results[1], results[2], results[3]},
+		CheckOwnPackageCoverage(results, ownResults))
+}
+
+func TestDebugInfoCoveringTests(t *testing.T) {
+	results := []CheckResult{
+		{
+			Coverage:      CoverageLine{Filename: "api.go", LineNumber: "10", Function: "Get", Coverage: 100},
+			CoveringTests: []string{"ExampleAPI", "TestGet"},
+		},
+		{
+			Coverage:      CoverageLine{Filename: "api.go", LineNumber: "20", Function: "Put", Coverage: 0},
+			CoveringTests: []string{},
+		},
+		{
+			Coverage: CoverageLine{Filename: "api.go", LineNumber: "30", Function: "Delete", Coverage: 0},
+		},
+	}
+	assert.Equal(t, []string{
+		"Debug info for coverage matching",
+		"- Line api.go:10:\tGet\t100.0%",
+		"  - Default coverage 0.0% satisfied",
+		"  - Covering tests: ExampleAPI, TestGet",
+		"- Line api.go:20:\tPut\t0.0%",
+		"  - Default coverage 0.0% satisfied",
+		"  - Covering tests: none",
+		"- Line api.go:30:\tDelete\t0.0%",
+		"  - Default coverage 0.0% satisfied",
+	}, DebugInfo(results))
+}
+
+func TestCheckCoverageResults(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 80,
+		DefaultMaxCrap:  5,
+		Rules: []Rule{
+			{
+				FunctionRegex: "^ignored$",
+				Coverage:      0,
+			},
+			{
+				FunctionRegex: "^Get$",
+				Coverage:      100,
+				MaxCrap:       10,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	coverage := []CoverageLine{
+		{Filename: "api.go", LineNumber: "1", Function: "Get", Coverage: 50},
+		{Filename: "api.go", LineNumber: "10", Function: "Put", Coverage: 90},
+		{Filename: "api.go", LineNumber: "20", Function: "Delete", Coverage: 90},
+	}
+	fInfoMap := FunctionInfoMap{
+		"example.com/mod.Client.Get": {ID: "example.com/mod.Client.Get", Filename: "api.go", LineNumber: "1", Function: "Get", Receiver: "Client", StartColumn: 1, EndLine: 8, EndColumn: 2, Complexity: 2},
+		"example.com/mod.Put":        {ID: "example.com/mod.Put", Filename: "api.go", LineNumber: "10", Function: "Put", Complexity: 1},
+		"example.com/mod.Delete":     {ID: "example.com/mod.Delete", Filename: "api.go", LineNumber: "20", Function: "Delete", Complexity: 10},
+	}
+	blocks := []ProfileBlock{
+		{Filename: "api.go", StartLine: 4, StartColumn: 2, EndLine: 7, EndColumn: 3, NumStatements: 1, Count: 0},
+	}
+	results := CheckCoverage(config, coverage, fInfoMap, blocks)
+	assert.Error(t, ViolationsError(results))
+	expected := []CheckResult{
+		{
+			Coverage:         coverage[0],
+			Function:         fInfoMap["example.com/mod.Client.Get"],
+			RuleIndex:        1,
+			Rule:             &config.Rules[1],
+			RequiredCoverage: 100,
+			CrapScore:        2.5,
+			MaxCrap:          10,
+			UncoveredLines:   "api.go:4-7",
+			Passed:           false,
+			Severity:         SeverityError,
+			Violations: []Violation{
+				{
+					Kind:    ViolationCoverage,
+					Actual:  50,
+					Limit:   100,
+					Message: "api.go:1:\tGet\t50.0%: actual coverage 50.0% < required coverage 100.0%: matching rule is `FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  MaxCrap: 10 Coverage: 100 Comment: `: uncovered lines: api.go:4-7",
+				},
+			},
+		},
+		{
+			Coverage:         coverage[1],
+			Function:         fInfoMap["example.com/mod.Put"],
+			RuleIndex:        -1,
+			Rule:             nil,
+			RequiredCoverage: 80,
+			CrapScore:        crapScore(1, 90),
+			MaxCrap:          5,
+			UncoveredLines:   "",
+			Passed:           true,
+			Severity:         SeverityError,
+		},
+		{
+			Coverage:         coverage[2],
+			Function:         fInfoMap["example.com/mod.Delete"],
+			RuleIndex:        -1,
+			Rule:             nil,
+			RequiredCoverage: 80,
+			CrapScore:        crapScore(10, 90),
+			MaxCrap:          5,
+			UncoveredLines:   "",
+			Passed:           false,
+			Severity:         SeverityError,
+			Violations: []Violation{
+				{
+					Kind:    ViolationCrap,
+					Actual:  crapScore(10, 90),
+					Limit:   5,
+					Message: "api.go:20:\tDelete\t90.0%: CRAP score 10.1 > maximum CRAP score 5.0: cyclomatic complexity is 10",
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, results)
+}
+
+func TestCheckCoverageWarnings(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 80,
+		Rules: []Rule{
+			{
+				FunctionRegex: "^Get$",
+				Coverage:      100,
+				Severity:      SeverityWarning,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	coverage := []CoverageLine{
+		{Filename: "api.go", LineNumber: "1", Function: "Get", Coverage: 50},
+	}
+	results := CheckCoverage(config, coverage, FunctionInfoMap{}, nil)
+	// Warnings don't cause an error.
+	assert.Nil(t, ViolationsError(results))
+	assert.Equal(t, 1, len(results))
+	assert.False(t, results[0].Passed)
+	assert.Equal(t, SeverityWarning, results[0].Severity)
+	assert.Equal(t, StatusWarning, results[0].Status())
+	assert.Equal(t, []string{
+		"api.go:1:\tGet\t50.0%: actual coverage 50.0% < required coverage 100.0%: matching rule is `FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Severity: warning Coverage: 100 Comment: `",
+	}, results[0].Messages())
+}
+
+func TestCheckCoverageClosuresInheritRules(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 80,
+		Rules: []Rule{
+			{
+				Comment:       "main is exempt",
+				FunctionRegex: "^main$",
+				Coverage:      0,
+			},
+			{
+				Comment:       "The second init is exempt",
+				FunctionRegex: "^init$",
+				Ordinal:       2,
+				Coverage:      0,
+			},
+			{
+				Comment:       "Closures in main that are checked separately",
+				FunctionRegex: "^main\\.func2$",
+				Coverage:      100,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	fInfoMap := FunctionInfoMap{}
+	for _, fi := range []FunctionInfo{
+		{ID: "example.com/mod.main", Filename: "main.go", LineNumber: "3", Function: "main", EndLine: 20},
+		{ID: "example.com/mod.main.func1", Filename: "main.go", LineNumber: "5", Function: "main.func1", EndLine: 7, Closure: true},
+		{ID: "example.com/mod.main.func2", Filename: "main.go", LineNumber: "8", Function: "main.func2", EndLine: 10, Closure: true},
+		{ID: "example.com/mod.init@init.go", Filename: "init.go", LineNumber: "3", Function: "init", EndLine: 8, Ordinal: 1},
+		{ID: "example.com/mod.init.func1@init.go", Filename: "init.go", LineNumber: "5", Function: "init.func1", EndLine: 7, Closure: true, Ordinal: 1},
+		{ID: "example.com/mod.init@init.go#2", Filename: "init.go", LineNumber: "10", Function: "init", EndLine: 15, Ordinal: 2},
+		{ID: "example.com/mod.init.func1@init.go#2", Filename: "init.go", LineNumber: "12", Function: "init.func1", EndLine: 14, Closure: true, Ordinal: 2},
+		// The enclosing function is missing.
+		{ID: "example.com/mod.gone.func1", Filename: "gone.go", LineNumber: "5", Function: "gone.func1", EndLine: 7, Closure: true},
+	} {
+		fInfoMap[fi.ID] = fi
+	}
+	coverage := []CoverageLine{}
+	for _, fi := range fInfoMap {
+		coverage = append(coverage, CoverageLine{Filename: fi.Filename, LineNumber: fi.LineNumber, Function: fi.Function, Coverage: 0})
+	}
+	results := CheckCoverage(config, coverage, fInfoMap, nil)
+	inherited := map[string]string{}
+	ruleIndexes := map[string]int{}
+	for _, result := range results {
+		key := result.Function.ID
+		inherited[key] = result.InheritedFrom
+		ruleIndexes[key] = result.RuleIndex
+	}
+	assert.Equal(t, map[string]int{
+		"example.com/mod.main":       0,
+		"example.com/mod.main.func1": 0,
+		// A rule matching the closure itself takes precedence.
+		"example.com/mod.main.func2":   2,
+		"example.com/mod.init@init.go": -1,
+		// Closures inherit the rule of the function containing them.
+		"example.com/mod.init.func1@init.go":   -1,
+		"example.com/mod.init@init.go#2":       1,
+		"example.com/mod.init.func1@init.go#2": 1,
+		"example.com/mod.gone.func1":           -1,
+	}, ruleIndexes)
+	assert.Equal(t, map[string]string{
+		"example.com/mod.main":                 "",
+		"example.com/mod.main.func1":           "main",
+		"example.com/mod.main.func2":           "",
+		"example.com/mod.init@init.go":         "",
+		"example.com/mod.init.func1@init.go":   "",
+		"example.com/mod.init@init.go#2":       "",
+		"example.com/mod.init.func1@init.go#2": "init",
+		"example.com/mod.gone.func1":           "",
+	}, inherited)
+	assert.Contains(t, strings.Join(DebugInfo(results), "\n"),
+		"  - Matching rule (inherited from enclosing function main): FilenameRegex:  FunctionRegex: ^main$")
+}
+
+func TestCheckCoverageFunctionNotFound(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 80,
+		Rules: []Rule{
+			{
+				FunctionRegex: "^Get$",
+				ParamCount:    intPointer(0),
+				Coverage:      100,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	coverage := []CoverageLine{
+		{Filename: "api.go", LineNumber: "3", Function: "Get", Coverage: 90},
+		{Filename: "api.go", LineNumber: "9", Function: "Get", Coverage: 50},
+	}
+	fInfoMap := FunctionInfoMap{
+		"example.com/mod.Get": {
+			ID:         "example.com/mod.Get",
+			Filename:   "api.go",
+			LineNumber: "3",
+			Function:   "Get",
+			ParamCount: 1,
+		},
+	}
+	results := CheckCoverage(config, coverage, fInfoMap, nil)
+	assert.Equal(t, 2, len(results))
+	// The function that was found doesn't match the rule because of its
+	// parameter.
+	assert.Nil(t, results[0].Warnings)
+	assert.True(t, results[0].Passed)
+	// The function that wasn't found matches the rule as if it had no
+	// parameters, so there's a warning explaining why.
+	assert.Equal(t, []string{
+		"api.go:9:\tGet\t50.0%: function wasn't found when parsing the code, so it has no CRAP score or uncovered lines, and rules using receiver_regex, exported, returns_error, param_count, or ordinal treat it as having no receiver, parameters, or results",
+	}, results[1].Warnings)
+	assert.False(t, results[1].Passed)
+}
+
+func TestCheckCoverageOldReceiverRegexWarnings(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 80,
+		Rules: []Rule{
+			{
+				// Receivers used to be formatted like this for pointer receivers.
+				FunctionRegex: "^Get$",
+				ReceiverRegex: `^&\{\d+ Client\}$`,
+				Coverage:      50,
+			},
+			{
+				FunctionRegex: "^Put$",
+				ReceiverRegex: "^Client$",
+				Coverage:      50,
+			},
+			{
+				FunctionRegex: "^Put$",
+				ReceiverRegex: `^&\{\d+ Client\}$`,
+				Coverage:      50,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	coverage := []CoverageLine{
+		{Filename: "api.go", LineNumber: "3", Function: "Get", Coverage: 60},
+		// A rule before the old-style rule matches, so the old-style rule
+		// wouldn't have been used.
+		{Filename: "api.go", LineNumber: "9", Function: "Put", Coverage: 60},
+		// Functions without receivers can't be affected.
+		{Filename: "main.go", LineNumber: "3", Function: "Get", Coverage: 60},
+	}
+	fInfoMap := FunctionInfoMap{
+		"example.com/mod.Client.Get": {ID: "example.com/mod.Client.Get", Filename: "api.go", LineNumber: "3", Function: "Get", Receiver: "Client"},
+		"example.com/mod.Client.Put": {ID: "example.com/mod.Client.Put", Filename: "api.go", LineNumber: "9", Function: "Put", Receiver: "Client"},
+		"example.com/mod.Get":        {ID: "example.com/mod.Get", Filename: "main.go", LineNumber: "3", Function: "Get"},
+	}
+	results := CheckCoverage(config, coverage, fInfoMap, nil)
+	assert.Equal(t, []string{
+		"api.go:3:\tGet\t60.0%: rule `FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex: ^&\\{\\d+ Client\\}$ Coverage: 50 Comment: ` doesn't match because receiver_regex looks like it was written for receivers formatted like `&{123 Client}`; receivers are now type names without pointers or type parameters, e.g. `Client`",
+	}, results[0].Warnings)
+	assert.False(t, results[0].Passed)
+	assert.Nil(t, results[1].Warnings)
+	assert.True(t, results[1].Passed)
+	assert.Nil(t, results[2].Warnings)
+}
+
+func TestCheckCoverageGeneratedRuleWarnings(t *testing.T) {
+	config, err := validateConfig(Config{
+		DefaultCoverage: 100,
+		Rules: []Rule{
+			{
+				Comment:       generatedRuleComment + "example.com/mod.init@init.go",
+				FilenameRegex: "^init.go$",
+				FunctionRegex: "^init$",
+				ReceiverRegex: "^$",
+				Coverage:      50,
+			},
+			{
+				Comment:       generatedRuleComment + "example.com/mod.New",
+				FilenameRegex: "^new.go$",
+				FunctionRegex: "^New$",
+				ReceiverRegex: "^$",
+				Coverage:      75,
+			},
+			{
+				Comment:       "Not generated",
+				FunctionRegex: "^Get$",
+				Coverage:      20,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	coverage := []CoverageLine{
+		// A second init was added after generating the config.
+		{Filename: "init.go", LineNumber: "3", Function: "init", Coverage: 50},
+		{Filename: "init.go", LineNumber: "9", Function: "init", Coverage: 100},
+		// Several functions with the same coverage don't need warnings.
+		{Filename: "new.go", LineNumber: "3", Function: "New", Coverage: 80},
+		{Filename: "new.go", LineNumber: "9", Function: "New", Coverage: 80},
+		// Only generated rules are checked.
+		{Filename: "api.go", LineNumber: "3", Function: "Get", Coverage: 30},
+		{Filename: "api.go", LineNumber: "9", Function: "Get", Coverage: 40},
+	}
+	fInfoMap := FunctionInfoMap{}
+	for _, cov := range coverage {
+		id := "example.com/mod." + cov.Function + "@" + cov.Filename + ":" + cov.LineNumber
+		fInfoMap[id] = FunctionInfo{ID: id, Filename: cov.Filename, LineNumber: cov.LineNumber, Function: cov.Function}
+	}
+	results := CheckCoverage(config, coverage, fInfoMap, nil)
+	warnings := [][]string{}
+	for _, result := range results {
+		warnings = append(warnings, result.Warnings)
+		assert.True(t, result.Passed, result.Coverage)
+	}
+	assert.Equal(t, [][]string{
+		nil,
+		{"init.go:9:\tinit\t100.0%: generated rule `FilenameRegex: ^init.go$ FunctionRegex: ^init$ ReceiverRegex: ^$ Coverage: 50 Comment: Generated rule for example.com/mod.init@init.go` matches 2 functions with different coverage; regenerate the config to check them separately"},
+		nil,
+		nil,
+		nil,
+		nil,
+	}, warnings)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"go/ast"
+	"go/token"
+	"math"
+)
+
+// cyclomaticComplexity calculates the cyclomatic complexity of node: one plus
+// the number of decision points (if, for, range, case, select case, && and
+// ||).  Closures inside node are included, the same way that closures are
+// included in the coverage of their enclosing function.
+func cyclomaticComplexity(node ast.Node) int {
+	complexity := 1
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			// A nil List is the default case, which isn't a decision point.
+			if n.List != nil {
+				complexity++
+			}
+		case *ast.CommClause:
+			// A nil Comm is the default case, which isn't a decision point.
+			if n.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
+
+// crapScore calculates the Change Risk Anti-Patterns score for a function
+// with the given cyclomatic complexity and coverage percentage:
+//
+//	complexity^2 * (1 - coverage/100)^3 + complexity
+//
+// A fully covered function scores its complexity, and the score grows
+// quickly for complex functions with low coverage.
+func crapScore(complexity int, coverage float64) float64 {
+	comp := float64(complexity)
+	return comp*comp*math.Pow(1-coverage/100, 3) + comp
+}
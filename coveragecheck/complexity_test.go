@@ -0,0 +1,101 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCyclomaticComplexity(t *testing.T) {
+	table := []struct {
+		desc       string
+		code       string
+		complexity int
+	}{
+		{
+			desc:       "no decision points",
+			code:       `func f() { println("hello") }`,
+			complexity: 1,
+		},
+		{
+			desc: "if, for, and range",
+			code: `func f(x []int) {
+				if len(x) > 0 {
+				}
+				for i := 0; i < 10; i++ {
+				}
+				for range x {
+				}
+			}`,
+			complexity: 4,
+		},
+		{
+			desc: "switch cases but not the default case",
+			code: `func f(x int) {
+				switch x {
+				case 1:
+				case 2, 3:
+				default:
+				}
+			}`,
+			complexity: 3,
+		},
+		{
+			desc: "select cases but not the default case",
+			code: `func f(c chan int) {
+				select {
+				case <-c:
+				case c <- 1:
+				default:
+				}
+			}`,
+			complexity: 3,
+		},
+		{
+			desc:       "boolean operators but not other operators",
+			code:       `func f(a, b, c bool, x int) bool { return a && b || c && x+1 > 2 }`,
+			complexity: 4,
+		},
+		{
+			desc: "closures are included",
+			code: `func f(a bool) func() {
+				return func() {
+					if a {
+					}
+				}
+			}`,
+			complexity: 2,
+		},
+	}
+	for _, test := range table {
+		file, err := parser.ParseFile(token.NewFileSet(), "test.go", "package main\n"+test.code, 0)
+		if assert.Nil(t, err, test.desc) {
+			assert.Equal(t, test.complexity, cyclomaticComplexity(file.Decls[0].(*ast.FuncDecl)), test.desc)
+		}
+	}
+}
+
+func TestCrapScore(t *testing.T) {
+	assert.Equal(t, 1.0, crapScore(1, 100))
+	assert.Equal(t, 2.0, crapScore(1, 0))
+	assert.Equal(t, 10.0, crapScore(10, 100))
+	assert.Equal(t, 110.0, crapScore(10, 0))
+	assert.Equal(t, 22.5, crapScore(10, 50))
+}
@@ -0,0 +1,383 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Constants used for Rule.Severity.
+const SeverityError = "error"
+const SeverityWarning = "warning"
+
+// generatedRuleComment starts the comment of every rule created by
+// GenerateConfig, so that CheckCoverage can recognise them.
+const generatedRuleComment = "Generated rule for "
+
+// Rule represents a coverage rule.
+type Rule struct {
+	// Comment is not interpreted or used; it is provided as a structured way of
+	// adding comments to an entry, so that automated editing is easier.
+	Comment string `json:"comment"`
+	// Regex used when matching against a filename.
+	FilenameRegex string `yaml:"filename_regex" json:"filename_regex"`
+	// Regex used when matching against a function.
+	FunctionRegex string `yaml:"function_regex" json:"function_regex"`
+	// Regex used when matching against a method receiver.
+	ReceiverRegex string `yaml:"receiver_regex" json:"receiver_regex"`
+	// If set, whether the function must be part of the exported API.
+	Exported *bool `yaml:"exported,omitempty" json:"exported,omitempty"`
+	// If set, whether the function must return an error.
+	ReturnsError *bool `yaml:"returns_error,omitempty" json:"returns_error,omitempty"`
+	// If set, the number of parameters the function must have.
+	ParamCount *int `yaml:"param_count,omitempty" json:"param_count,omitempty"`
+	// MinCoveringTests is the minimum number of tests that must execute the
+	// function; zero means there is no minimum.  Checking it runs every test
+	// separately; see AttributeTests.
+	MinCoveringTests int `yaml:"min_covering_tests,omitempty" json:"min_covering_tests,omitempty"`
+	// RequireOwnPackageTests means that only the tests in the function's own
+	// package count towards its coverage, when Options.CrossPackageTests
+	// includes the tests in other packages.
+	RequireOwnPackageTests bool `yaml:"require_own_package_tests,omitempty" json:"require_own_package_tests,omitempty"`
+	// If non-zero, FunctionInfo.Ordinal must be equal, e.g. 2 to match only
+	// the second init function in a file.
+	Ordinal int `yaml:"ordinal,omitempty" json:"ordinal,omitempty"`
+	// MaxCrap is the maximum CRAP score allowed for this function; zero means
+	// Config.DefaultMaxCrap is used instead.
+	MaxCrap float64 `yaml:"max_crap,omitempty" json:"max_crap,omitempty"`
+	// Severity of violations of this rule, either SeverityError or
+	// SeverityWarning; empty means SeverityError.
+	Severity string `yaml:"severity,omitempty" json:"severity,omitempty"`
+	// Coverage level required for this function or filename; this is a floating
+	// point percentage, so it should be >= 0 and <= 100.
+	Coverage float64 `json:"coverage"`
+	// compiledFilenameRegex is the result of regexp.MustCompile(FilenameRegex).
+	compiledFilenameRegex *regexp.Regexp
+	// compiledFunctionRegex is the result of regexp.MustCompile(FunctionRegex).
+	compiledFunctionRegex *regexp.Regexp
+	// compiledFunctionRegex is the result of regexp.MustCompile(ReceiverRegex).
+	compiledReceiverRegex *regexp.Regexp
+}
+
+func (rule Rule) String() string {
+	// Optional fields are only included when they are set.
+	optional := ""
+	if rule.Exported != nil {
+		optional += fmt.Sprintf(" Exported: %v", *rule.Exported)
+	}
+	if rule.ReturnsError != nil {
+		optional += fmt.Sprintf(" ReturnsError: %v", *rule.ReturnsError)
+	}
+	if rule.ParamCount != nil {
+		optional += fmt.Sprintf(" ParamCount: %v", *rule.ParamCount)
+	}
+	if rule.Ordinal != 0 {
+		optional += fmt.Sprintf(" Ordinal: %v", rule.Ordinal)
+	}
+	if rule.MaxCrap != 0 {
+		optional += fmt.Sprintf(" MaxCrap: %v", rule.MaxCrap)
+	}
+	if rule.MinCoveringTests != 0 {
+		optional += fmt.Sprintf(" MinCoveringTests: %v", rule.MinCoveringTests)
+	}
+	if rule.RequireOwnPackageTests {
+		optional += " RequireOwnPackageTests: true"
+	}
+	if rule.Severity != "" {
+		optional += fmt.Sprintf(" Severity: %v", rule.Severity)
+	}
+	return fmt.Sprintf("FilenameRegex: %v FunctionRegex: %v ReceiverRegex: %v%s Coverage: %v Comment: %v",
+		rule.FilenameRegex, rule.FunctionRegex, rule.ReceiverRegex, optional, rule.Coverage, rule.Comment)
+}
+
+// matches reports whether every non-empty regex and every set field in the
+// rule matches the function.
+func (rule Rule) matches(cov CoverageLine, fi FunctionInfo) bool {
+	if rule.FilenameRegex != "" && !rule.compiledFilenameRegex.MatchString(cov.Filename) {
+		return false
+	}
+	if rule.FunctionRegex != "" && !rule.compiledFunctionRegex.MatchString(cov.Function) {
+		return false
+	}
+	if rule.ReceiverRegex != "" && !rule.compiledReceiverRegex.MatchString(fi.Receiver) {
+		return false
+	}
+	if rule.Exported != nil && *rule.Exported != fi.Exported {
+		return false
+	}
+	if rule.ReturnsError != nil && *rule.ReturnsError != fi.ReturnsError {
+		return false
+	}
+	if rule.ParamCount != nil && *rule.ParamCount != fi.ParamCount {
+		return false
+	}
+	if rule.Ordinal != 0 && rule.Ordinal != fi.Ordinal {
+		return false
+	}
+	return true
+}
+
+// Config represents an entire user config loaded from .golang-coverage-check.yaml.
+type Config struct {
+	// Comment is not interpreted or used; it is provided as a structured way of
+	// adding comments to a config, so that automated editing is easier.
+	Comment string
+	// DefaultCoverage is the default coverage required if none of the function or
+	// filename rules match; this is a floating point percentage, so it should be
+	// >= 0 and <= 100.
+	DefaultCoverage float64 `yaml:"default_coverage"`
+	// DefaultMaxCrap is the maximum CRAP score allowed if the matching rule
+	// doesn't set MaxCrap; zero means there is no maximum.
+	DefaultMaxCrap float64 `yaml:"default_max_crap,omitempty"`
+	// Badge configures the colour of the badge written by --badge.
+	Badge *BadgeConfig `yaml:"badge,omitempty"`
+	// Rules is a list of rules that will be checked in-order, and the first match wins.
+	Rules []Rule
+}
+
+func (config Config) String() string {
+	bytes, _ := yaml.Marshal(&config)
+	return string(bytes)
+}
+
+// needsAttribution reports whether any rule sets MinCoveringTests, so that
+// AttributeTests must be run.
+func (config Config) needsAttribution() bool {
+	for _, rule := range config.Rules {
+		if rule.MinCoveringTests > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// needsOwnPackageCoverage reports whether any rule sets
+// RequireOwnPackageTests, so that coverage from the package's own tests must be
+// collected separately.
+func (config Config) needsOwnPackageCoverage() bool {
+	for _, rule := range config.Rules {
+		if rule.RequireOwnPackageTests {
+			return true
+		}
+	}
+	return false
+}
+
+// ExampleConfig returns an example Config showing most of the ways that rules
+// can match functions.
+func ExampleConfig() Config {
+	exported := true
+	config := Config{
+		Comment: "Comment is not interpreted or used; it is provided as a " +
+			"structured way of adding comments to a config, so that automated " +
+			"editing is easier.",
+		DefaultCoverage: 80.0,
+		DefaultMaxCrap:  30,
+		Rules: []Rule{
+			{
+				Comment:       "Low coverage is acceptable for main()",
+				FunctionRegex: "^main$",
+				Coverage:      50,
+			},
+			{
+				Comment: "All the fooOrDie() functions should be fully tested because" +
+					" they panic() on failure",
+				FunctionRegex: "OrDie$",
+				Coverage:      100,
+			},
+			{
+				Comment:       "Improve test coverage for parse_json.go?",
+				FilenameRegex: "^parse_json.go$",
+				Coverage:      73,
+			},
+			{
+				Comment:       "Full coverage for other parsers",
+				FilenameRegex: "^parse.*.go$",
+				Coverage:      100,
+			},
+			{
+				Comment:       "Url.String() has low coverage",
+				FilenameRegex: "^urls.go$",
+				FunctionRegex: "^String$",
+				ReceiverRegex: "^Url$",
+				Coverage:      56,
+			},
+			{
+				Comment:       "String() everywhere else should have high coverage",
+				FunctionRegex: "^String$",
+				Coverage:      100,
+			},
+			{
+				Comment:  "The exported API should be fully tested",
+				Exported: &exported,
+				Coverage: 100,
+			},
+		},
+	}
+	return config
+}
+
+// GenerateConfig generates a Config that exactly matches coverage, with a
+// rule for every function; used by --generate_config.  Functions that the
+// regexes can't tell apart, like multiple init functions in a file, are
+// distinguished by Rule.Ordinal.
+func GenerateConfig(coverage []CoverageLine, fInfoMap FunctionInfoMap) Config {
+	config := Config{
+		DefaultCoverage: 100,
+	}
+	locations := fInfoMap.ByLocation()
+	for _, cov := range coverage {
+		fi := locations[FunctionLocationKey(cov.Filename, cov.LineNumber, cov.Function)]
+		id := fi.ID
+		if id == "" {
+			id = cov.Function + " in " + cov.Filename
+		}
+		// Closure names contain dots, so names are quoted.
+		rule := Rule{
+			Comment:       generatedRuleComment + id,
+			Coverage:      cov.Coverage,
+			FunctionRegex: "^" + regexp.QuoteMeta(cov.Function) + "$",
+			FilenameRegex: "^" + cov.Filename + "$",
+			ReceiverRegex: "^" + regexp.QuoteMeta(fi.Receiver) + "$",
+			// Zero unless the regexes match several functions in the file.
+			Ordinal: fi.Ordinal,
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+	return config
+}
+
+// isGenerated reports whether GenerateConfig created the rule.
+func (rule Rule) isGenerated() bool {
+	return strings.HasPrefix(rule.Comment, generatedRuleComment)
+}
+
+// validateConfig checks a config for correctness, including compiling every
+// regex and caching the result.  Returns an updated config and an error.
+func validateConfig(config Config) (Config, error) {
+	if config.DefaultCoverage < 0 || config.DefaultCoverage > 100 {
+		return config, fmt.Errorf("default coverage (%.1f) is outside the range 0-100", config.DefaultCoverage)
+	}
+	if err := validateMaxCrap(config.DefaultMaxCrap); err != nil {
+		return config, fmt.Errorf("default_max_crap %w", err)
+	}
+	if err := validateBadgeConfig(config); err != nil {
+		return config, err
+	}
+	for i := range config.Rules {
+		if config.Rules[i].FilenameRegex == "" && config.Rules[i].FunctionRegex == "" && config.Rules[i].ReceiverRegex == "" &&
+			config.Rules[i].Exported == nil && config.Rules[i].ReturnsError == nil && config.Rules[i].ParamCount == nil {
+			// The other fields only refine or add requirements; on their own
+			// they would apply to almost every function by accident.
+			return config, fmt.Errorf("every regex is an empty string in rule %v, and none of exported, returns_error, or param_count are set; ordinal, min_covering_tests, and require_own_package_tests can't be used alone", config.Rules[i])
+		}
+		if config.Rules[i].ParamCount != nil && *config.Rules[i].ParamCount < 0 {
+			return config, fmt.Errorf("param_count (%d) is negative in %v", *config.Rules[i].ParamCount, config.Rules[i])
+		}
+		if config.Rules[i].MinCoveringTests < 0 {
+			return config, fmt.Errorf("min_covering_tests (%d) is negative in %v", config.Rules[i].MinCoveringTests, config.Rules[i])
+		}
+		if config.Rules[i].Ordinal < 0 {
+			return config, fmt.Errorf("ordinal (%d) is negative in %v", config.Rules[i].Ordinal, config.Rules[i])
+		}
+		if err := validateMaxCrap(config.Rules[i].MaxCrap); err != nil {
+			return config, fmt.Errorf("max_crap %w in %v", err, config.Rules[i])
+		}
+		if severity := config.Rules[i].Severity; severity != "" && severity != SeverityError && severity != SeverityWarning {
+			return config, fmt.Errorf("severity (%q) must be %q or %q in %v", severity, SeverityError, SeverityWarning, config.Rules[i])
+		}
+		config.Rules[i].compiledFilenameRegex = regexp.MustCompile(config.Rules[i].FilenameRegex)
+		config.Rules[i].compiledFunctionRegex = regexp.MustCompile(config.Rules[i].FunctionRegex)
+		config.Rules[i].compiledReceiverRegex = regexp.MustCompile(config.Rules[i].ReceiverRegex)
+		if config.Rules[i].Coverage < 0 || config.Rules[i].Coverage > 100 {
+			return config, fmt.Errorf("coverage (%.1f) is outside the range 0-100 in %v", config.Rules[i].Coverage, config.Rules[i])
+		}
+	}
+	return config, nil
+}
+
+// validateMaxCrap checks that a maximum CRAP score is either zero (no maximum)
+// or at least one, because the lowest possible CRAP score is one.
+func validateMaxCrap(maxCrap float64) error {
+	if maxCrap != 0 && maxCrap < 1 {
+		return fmt.Errorf("(%.1f) must be 0 or at least 1", maxCrap)
+	}
+	return nil
+}
+
+// ParseConfig parses raw YAML into a Config, checks it for correctness, and
+// compiles every regex for speed.  Returns a config and an error.
+func ParseConfig(yamlConf []byte) (Config, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(yamlConf, &config); err != nil {
+		return config, fmt.Errorf("failed parsing YAML: %w", err)
+	}
+	return validateConfig(config)
+}
+
+// badgeYellowMargin is how far below the green threshold the yellow threshold
+// is when BadgeConfig.Yellow isn't set.
+const badgeYellowMargin = 10.0
+
+// BadgeConfig configures the colour of the badge written by --badge.
+type BadgeConfig struct {
+	// Green is the minimum total coverage for a green badge; defaults to
+	// Config.DefaultCoverage.
+	Green *float64 `yaml:"green,omitempty"`
+	// Yellow is the minimum total coverage for a yellow badge; defaults to 10
+	// less than Green, or 0 if that would be negative.  Lower coverage gives a
+	// red badge.
+	Yellow *float64 `yaml:"yellow,omitempty"`
+}
+
+// BadgeThresholds returns the minimum total coverage for green and yellow
+// badges, applying defaults for fields that aren't set.
+func (config Config) BadgeThresholds() (float64, float64) {
+	green := config.DefaultCoverage
+	var yellow *float64
+	if config.Badge != nil {
+		if config.Badge.Green != nil {
+			green = *config.Badge.Green
+		}
+		yellow = config.Badge.Yellow
+	}
+	if yellow != nil {
+		return green, *yellow
+	}
+	if green < badgeYellowMargin {
+		return green, 0
+	}
+	return green, green - badgeYellowMargin
+}
+
+// validateBadgeConfig checks that the badge thresholds are percentages and
+// that yellow isn't above green.
+func validateBadgeConfig(config Config) error {
+	green, yellow := config.BadgeThresholds()
+	if green < 0 || green > 100 {
+		return fmt.Errorf("badge green (%.1f) is outside the range 0-100", green)
+	}
+	if yellow < 0 || yellow > 100 {
+		return fmt.Errorf("badge yellow (%.1f) is outside the range 0-100", yellow)
+	}
+	if yellow > green {
+		return fmt.Errorf("badge yellow (%.1f) must not be more than badge green (%.1f)", yellow, green)
+	}
+	return nil
+}
@@ -0,0 +1,639 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExampleConfig(t *testing.T) {
+	expected := strings.Split(strings.TrimLeft(strings.ReplaceAll(`
+comment: Comment is not interpreted or used; it is provided as a structured way of
+	adding comments to a config, so that automated editing is easier.
+default_coverage: 80
+default_max_crap: 30
+rules:
+- comment: Low coverage is acceptable for main()
+	filename_regex: ""
+	function_regex: ^main$
+	receiver_regex: ""
+	coverage: 50
+- comment: All the fooOrDie() functions should be fully tested because they panic()
+		on failure
+	filename_regex: ""
+	function_regex: OrDie$
+	receiver_regex: ""
+	coverage: 100
+- comment: Improve test coverage for parse_json.go?
+	filename_regex: ^parse_json.go$
+	function_regex: ""
+	receiver_regex: ""
+	coverage: 73
+- comment: Full coverage for other parsers
+	filename_regex: ^parse.*.go$
+	function_regex: ""
+	receiver_regex: ""
+	coverage: 100
+- comment: Url.String() has low coverage
+	filename_regex: ^urls.go$
+	function_regex: ^String$
+	receiver_regex: ^Url$
+	coverage: 56
+- comment: String() everywhere else should have high coverage
+	filename_regex: ""
+	function_regex: ^String$
+	receiver_regex: ""
+	coverage: 100
+- comment: The exported API should be fully tested
+	filename_regex: ""
+	function_regex: ""
+	receiver_regex: ""
+	exported: true
+	coverage: 100
+`, "\t", "  "), "\n"), "\n")
+	actual := strings.Split(ExampleConfig().String(), "\n")
+	assert.Equal(t, expected, actual)
+}
+
+func TestGenerateConfig(t *testing.T) {
+	coverage := []CoverageLine{
+		{
+			Filename:   "test.go",
+			LineNumber: "1",
+			Function:   "func1",
+			Coverage:   20.0,
+		},
+		{
+			Filename:   "test.go",
+			LineNumber: "2",
+			Function:   "func5",
+			Coverage:   34.0,
+		},
+		{
+			Filename:   "test.go",
+			LineNumber: "9",
+			Function:   "func17",
+			Coverage:   12.3,
+		},
+		{
+			Filename:   "test.go",
+			LineNumber: "10",
+			Function:   "func17.func1",
+			Coverage:   50.0,
+		},
+	}
+
+	fim := FunctionInfoMap{
+		"example.com/mod.receiver-receiver-receiver.func17": {
+			ID:         "example.com/mod.receiver-receiver-receiver.func17",
+			Filename:   "test.go",
+			LineNumber: "9",
+			Function:   "func17",
+			Receiver:   "receiver-receiver-receiver",
+		},
+		"example.com/mod.receiver-receiver-receiver.func17.func1": {
+			ID:         "example.com/mod.receiver-receiver-receiver.func17.func1",
+			Filename:   "test.go",
+			LineNumber: "10",
+			Function:   "func17.func1",
+			Receiver:   "receiver-receiver-receiver",
+			Closure:    true,
+		},
+	}
+
+	expected := Config{
+		DefaultCoverage: 100.0,
+		Rules: []Rule{
+			{
+				FilenameRegex: "^test.go$",
+				FunctionRegex: "^func1$",
+				ReceiverRegex: "^$",
+				Comment:       "Generated rule for func1 in test.go",
+				Coverage:      20.0,
+			},
+			{
+				FilenameRegex: "^test.go$",
+				FunctionRegex: "^func5$",
+				ReceiverRegex: "^$",
+				Comment:       "Generated rule for func5 in test.go",
+				Coverage:      34.0,
+			},
+			{
+				FilenameRegex: "^test.go$",
+				FunctionRegex: "^func17$",
+				ReceiverRegex: "^receiver-receiver-receiver$",
+				Comment:       "Generated rule for example.com/mod.receiver-receiver-receiver.func17",
+				Coverage:      12.3,
+			},
+			{
+				// The dot in the closure name is quoted.
+				FilenameRegex: "^test.go$",
+				FunctionRegex: "^func17\\.func1$",
+				ReceiverRegex: "^receiver-receiver-receiver$",
+				Comment:       "Generated rule for example.com/mod.receiver-receiver-receiver.func17.func1",
+				Coverage:      50.0,
+			},
+		},
+	}
+
+	generated := GenerateConfig(coverage, fim)
+	assert.Equal(t, expected, generated)
+}
+
+func TestGenerateConfigDuplicates(t *testing.T) {
+	coverage := []CoverageLine{
+		{Filename: "init.go", LineNumber: "3", Function: "init", Coverage: 100},
+		{Filename: "init.go", LineNumber: "7", Function: "init", Coverage: 50},
+		{Filename: "types.go", LineNumber: "5", Function: "String", Coverage: 80},
+		{Filename: "types.go", LineNumber: "9", Function: "String", Coverage: 60},
+	}
+	fim := FunctionInfoMap{}
+	for _, fi := range []FunctionInfo{
+		{ID: "example.com/mod.init@init.go", Filename: "init.go", LineNumber: "3", Function: "init", Ordinal: 1},
+		{ID: "example.com/mod.init@init.go#2", Filename: "init.go", LineNumber: "7", Function: "init", Ordinal: 2},
+		{ID: "example.com/mod.A.String", Filename: "types.go", LineNumber: "5", Function: "String", Receiver: "A"},
+		{ID: "example.com/mod.B.String", Filename: "types.go", LineNumber: "9", Function: "String", Receiver: "B"},
+	} {
+		fim[fi.ID] = fi
+	}
+
+	generated := GenerateConfig(coverage, fim)
+	ordinals := []int{}
+	for _, rule := range generated.Rules {
+		ordinals = append(ordinals, rule.Ordinal)
+	}
+	// Receivers already distinguish the String methods.
+	assert.Equal(t, []int{1, 2, 0, 0}, ordinals)
+
+	// Every function matches its own rule.
+	config, err := validateConfig(generated)
+	assert.Nil(t, err)
+	results := CheckCoverage(config, coverage, fim, nil)
+	for i, result := range results {
+		assert.Equal(t, i, result.RuleIndex, result.Coverage)
+		assert.True(t, result.Passed, result.Coverage)
+		assert.Empty(t, result.Warnings, result.Coverage)
+	}
+}
+
+func TestGenerateConfigAfterAddingFunctions(t *testing.T) {
+	dir := t.TempDir()
+	bGo := "package mod\n\nfunc init() {}\n\nfunc init() {}\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte(bGo), 0644))
+	// coverageFor returns coverage for every function, with the line number as
+	// the coverage so that functions can be told apart.
+	coverageFor := func(fInfoMap FunctionInfoMap) []CoverageLine {
+		coverage := []CoverageLine{}
+		for _, fi := range fInfoMap.ByLocation() {
+			line, _ := strconv.Atoi(fi.LineNumber)
+			coverage = append(coverage, CoverageLine{Filename: fi.Filename, LineNumber: fi.LineNumber, Function: fi.Function, Coverage: float64(line)})
+		}
+		return coverage
+	}
+	fInfoMap, err := ParseFunctions(dir, "example.com/mod")
+	assert.Nil(t, err)
+	config, err := validateConfig(GenerateConfig(coverageFor(fInfoMap), fInfoMap))
+	assert.Nil(t, err)
+
+	// Adding an init function to an earlier file doesn't change which
+	// functions the generated rules match.
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package mod\n\nfunc init() {}\n"), 0644))
+	fInfoMap, err = ParseFunctions(dir, "example.com/mod")
+	assert.Nil(t, err)
+	matched := 0
+	for _, result := range CheckCoverage(config, coverageFor(fInfoMap), fInfoMap, nil) {
+		if result.Coverage.Filename == "a.go" {
+			assert.Equal(t, -1, result.RuleIndex, result.Coverage)
+			continue
+		}
+		matched++
+		assert.NotNil(t, result.Rule, result.Coverage)
+		assert.Equal(t, result.Coverage.Coverage, result.Rule.Coverage, result.Coverage)
+		assert.True(t, result.Passed, result.Coverage)
+	}
+	assert.Equal(t, 2, matched)
+}
+
+func TestValidateConfigErrors(t *testing.T) {
+	table := []struct {
+		config Config
+		err    string
+	}{
+		{
+			err: "default coverage (101.0) is outside the range 0-100",
+			config: Config{
+				DefaultCoverage: 101,
+			},
+		},
+		{
+			err: "default coverage (-1.0) is outside the range 0-100",
+			config: Config{
+				DefaultCoverage: -1,
+			},
+		},
+		{
+			err: "min_covering_tests (-1) is negative in",
+			config: Config{
+				Rules: []Rule{
+					{
+						FunctionRegex:    "^Get$",
+						MinCoveringTests: -1,
+					},
+				},
+			},
+		},
+		{
+			err: "ordinal (-1) is negative in",
+			config: Config{
+				Rules: []Rule{
+					{
+						FunctionRegex: "^init$",
+						Ordinal:       -1,
+					},
+				},
+			},
+		},
+		{
+			err: "coverage (1234.0) is outside the range 0-100 in",
+			config: Config{
+				DefaultCoverage: 99,
+				Rules: []Rule{
+					{
+						FilenameRegex: "asdf",
+						Coverage:      1234,
+					},
+				},
+			},
+		},
+		{
+			err: "coverage (-1.0) is outside the range 0-100 in",
+			config: Config{
+				DefaultCoverage: 99,
+				Rules: []Rule{
+					{
+						FilenameRegex: "asdf",
+						Coverage:      -1,
+					},
+				},
+			},
+		},
+		{
+			err: "every regex is an empty string in rule",
+			config: Config{
+				DefaultCoverage: 99,
+				Rules: []Rule{
+					{
+						Coverage: 1,
+					},
+				},
+			},
+		},
+		{
+			err: "ordinal, min_covering_tests, and require_own_package_tests can't be used alone",
+			config: Config{
+				Rules: []Rule{
+					{
+						Ordinal:                2,
+						MinCoveringTests:       1,
+						RequireOwnPackageTests: true,
+					},
+				},
+			},
+		},
+		{
+			err: "default_max_crap (0.5) must be 0 or at least 1",
+			config: Config{
+				DefaultMaxCrap: 0.5,
+			},
+		},
+		{
+			err: "max_crap (-1.0) must be 0 or at least 1 in",
+			config: Config{
+				Rules: []Rule{
+					{
+						FilenameRegex: "asdf",
+						MaxCrap:       -1,
+					},
+				},
+			},
+		},
+		{
+			err: "severity (\"fatal\") must be \"error\" or \"warning\" in",
+			config: Config{
+				Rules: []Rule{
+					{
+						FilenameRegex: "asdf",
+						Severity:      "fatal",
+					},
+				},
+			},
+		},
+		{
+			err: "param_count (-1) is negative in",
+			config: Config{
+				DefaultCoverage: 99,
+				Rules: []Rule{
+					{
+						ParamCount: intPointer(-1),
+						Coverage:   1,
+					},
+				},
+			},
+		},
+		{
+			err: "badge green (101.0) is outside the range 0-100",
+			config: Config{
+				Badge: &BadgeConfig{Green: floatPointer(101)},
+			},
+		},
+	}
+	for _, test := range table {
+		_, err := validateConfig(test.config)
+		// Note: the error message seems mangled when it's printed here, but it's
+		// fine when printed for real.  I don't understand why and an hour of
+		// debugging has gotten me nowhere :(
+		assert.ErrorContains(t, err, test.err, test.config)
+	}
+}
+
+func boolPointer(b bool) *bool {
+	return &b
+}
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func floatPointer(f float64) *float64 {
+	return &f
+}
+
+func TestValidateConfigSuccess(t *testing.T) {
+	config := Config{
+		Comment:         "successful test",
+		DefaultCoverage: 75.0,
+		Rules: []Rule{
+			{
+				Comment:       "successful rule",
+				FilenameRegex: "foo",
+				FunctionRegex: "bar",
+				ReceiverRegex: "baz",
+				Coverage:      7.0,
+			},
+		},
+	}
+	config, err := validateConfig(config)
+	assert.Nil(t, err)
+	assert.Equal(t, 75.0, config.DefaultCoverage)
+	assert.Equal(t, 1, len(config.Rules))
+	rule := config.Rules[0]
+	assert.Equal(t, "foo", rule.FilenameRegex)
+	assert.Equal(t, "bar", rule.FunctionRegex)
+	assert.Equal(t, "baz", rule.ReceiverRegex)
+	assert.NotNil(t, rule.compiledFilenameRegex)
+	assert.NotNil(t, rule.compiledFunctionRegex)
+	assert.NotNil(t, rule.compiledReceiverRegex)
+
+	// Regexes are optional when other fields are set.
+	for _, rule := range []Rule{
+		{Exported: boolPointer(false)},
+		{ReturnsError: boolPointer(true)},
+		{ParamCount: intPointer(0)},
+	} {
+		_, err := validateConfig(Config{Rules: []Rule{rule}})
+		assert.Nil(t, err, rule)
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	rule := Rule{
+		Comment:       "comment",
+		FunctionRegex: "^foo$",
+		Coverage:      75,
+	}
+	assert.Equal(t, "FilenameRegex:  FunctionRegex: ^foo$ ReceiverRegex:  Coverage: 75 Comment: comment", rule.String())
+	rule.Exported = boolPointer(true)
+	rule.ReturnsError = boolPointer(false)
+	rule.ParamCount = intPointer(2)
+	rule.MaxCrap = 30
+	rule.Severity = SeverityWarning
+	rule.Ordinal = 3
+	rule.MinCoveringTests = 2
+	rule.RequireOwnPackageTests = true
+	assert.Equal(t, "FilenameRegex:  FunctionRegex: ^foo$ ReceiverRegex:  Exported: true ReturnsError: false ParamCount: 2 Ordinal: 3 MaxCrap: 30 MinCoveringTests: 2 RequireOwnPackageTests: true Severity: warning Coverage: 75 Comment: comment", rule.String())
+}
+
+func TestNeedsAttribution(t *testing.T) {
+	config := Config{Rules: []Rule{{FunctionRegex: "^Get$"}}}
+	assert.False(t, config.needsAttribution())
+	config.Rules = append(config.Rules, Rule{FunctionRegex: "^Put$", MinCoveringTests: 2})
+	assert.True(t, config.needsAttribution())
+}
+
+func TestNeedsOwnPackageCoverage(t *testing.T) {
+	config := Config{Rules: []Rule{{FunctionRegex: "^Get$"}}}
+	assert.False(t, config.needsOwnPackageCoverage())
+	config.Rules = append(config.Rules, Rule{FunctionRegex: "^Put$", RequireOwnPackageTests: true})
+	assert.True(t, config.needsOwnPackageCoverage())
+}
+
+func TestRuleMatches(t *testing.T) {
+	cov := CoverageLine{
+		Filename:   "api.go",
+		LineNumber: "10",
+		Function:   "Get",
+		Coverage:   50,
+	}
+	fi := FunctionInfo{
+		Filename:     "api.go",
+		LineNumber:   "10",
+		Function:     "Get",
+		Receiver:     "Client",
+		Exported:     true,
+		ReturnsError: true,
+		ParamCount:   2,
+		Ordinal:      2,
+	}
+	table := []struct {
+		desc    string
+		rule    Rule
+		matches bool
+	}{
+		{desc: "filename matches", rule: Rule{FilenameRegex: "^api"}, matches: true},
+		{desc: "filename does not match", rule: Rule{FilenameRegex: "^main"}, matches: false},
+		{desc: "function matches", rule: Rule{FunctionRegex: "^Get$"}, matches: true},
+		{desc: "function does not match", rule: Rule{FunctionRegex: "^Put$"}, matches: false},
+		{desc: "receiver matches", rule: Rule{ReceiverRegex: "^Client$"}, matches: true},
+		{desc: "receiver does not match", rule: Rule{ReceiverRegex: "^Server$"}, matches: false},
+		{desc: "exported matches", rule: Rule{Exported: boolPointer(true)}, matches: true},
+		{desc: "exported does not match", rule: Rule{Exported: boolPointer(false)}, matches: false},
+		{desc: "returns_error matches", rule: Rule{ReturnsError: boolPointer(true)}, matches: true},
+		{desc: "returns_error does not match", rule: Rule{ReturnsError: boolPointer(false)}, matches: false},
+		{desc: "param_count matches", rule: Rule{ParamCount: intPointer(2)}, matches: true},
+		{desc: "param_count does not match", rule: Rule{ParamCount: intPointer(0)}, matches: false},
+		{desc: "ordinal matches", rule: Rule{FunctionRegex: "^Get$", Ordinal: 2}, matches: true},
+		{desc: "ordinal does not match", rule: Rule{FunctionRegex: "^Get$", Ordinal: 1}, matches: false},
+		{
+			desc: "everything matches",
+			rule: Rule{
+				FilenameRegex: "^api.go$",
+				FunctionRegex: "^Get$",
+				ReceiverRegex: "^Client$",
+				Exported:      boolPointer(true),
+				ReturnsError:  boolPointer(true),
+				ParamCount:    intPointer(2),
+			},
+			matches: true,
+		},
+		{
+			desc: "one field does not match",
+			rule: Rule{
+				FilenameRegex: "^api.go$",
+				FunctionRegex: "^Get$",
+				ReceiverRegex: "^Client$",
+				Exported:      boolPointer(true),
+				ReturnsError:  boolPointer(true),
+				ParamCount:    intPointer(3),
+			},
+			matches: false,
+		},
+	}
+	for _, test := range table {
+		config, err := validateConfig(Config{Rules: []Rule{test.rule}})
+		assert.Nil(t, err, test.desc)
+		assert.Equal(t, test.matches, config.Rules[0].matches(cov, fi), test.desc)
+	}
+}
+
+func TestParseConfig_UnmarshalError(t *testing.T) {
+	_, err := ParseConfig([]byte("asdf"))
+	assert.ErrorContains(t, err, "failed parsing YAML: yaml: unmarshal errors")
+}
+
+func TestParseConfigSuccess(t *testing.T) {
+	config, err := ParseConfig([]byte(""))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, config.DefaultCoverage)
+
+	yml := `
+default_coverage: 75
+rules:
+	- function_regex: pinky
+		coverage: 20
+		comment: nobody understands pinky
+	- function_regex: the brain
+		coverage: 90
+		comment: the brain thinks he's understood
+	- filename_regex: main.go
+		coverage: 50
+	- filename_regex: utils.go
+		coverage: 95
+	- exported: true
+		returns_error: true
+		param_count: 1
+		coverage: 100
+`
+	yml = strings.ReplaceAll(yml, "\t", "  ")
+	config, err = ParseConfig([]byte(yml))
+	assert.Nil(t, err)
+	assert.Equal(t, 75.0, config.DefaultCoverage)
+	assert.Equal(t, 5, len(config.Rules))
+	assert.Equal(t, "pinky", config.Rules[0].FunctionRegex)
+	assert.Equal(t, boolPointer(true), config.Rules[4].Exported)
+	assert.Equal(t, boolPointer(true), config.Rules[4].ReturnsError)
+	assert.Equal(t, intPointer(1), config.Rules[4].ParamCount)
+}
+
+func TestBadgeThresholds(t *testing.T) {
+	table := []struct {
+		desc   string
+		config Config
+		green  float64
+		yellow float64
+	}{
+		{
+			desc:   "defaults",
+			config: Config{DefaultCoverage: 80},
+			green:  80,
+			yellow: 70,
+		},
+		{
+			desc:   "yellow defaults to zero rather than being negative",
+			config: Config{DefaultCoverage: 5, Badge: &BadgeConfig{}},
+			green:  5,
+			yellow: 0,
+		},
+		{
+			desc:   "green is set",
+			config: Config{DefaultCoverage: 80, Badge: &BadgeConfig{Green: floatPointer(95)}},
+			green:  95,
+			yellow: 85,
+		},
+		{
+			desc:   "both are set",
+			config: Config{DefaultCoverage: 80, Badge: &BadgeConfig{Green: floatPointer(95), Yellow: floatPointer(0)}},
+			green:  95,
+			yellow: 0,
+		},
+	}
+	for _, test := range table {
+		green, yellow := test.config.BadgeThresholds()
+		assert.Equal(t, test.green, green, test.desc)
+		assert.Equal(t, test.yellow, yellow, test.desc)
+	}
+}
+
+func TestValidateBadgeConfig(t *testing.T) {
+	table := []struct {
+		badge BadgeConfig
+		err   string
+	}{
+		{
+			badge: BadgeConfig{Green: floatPointer(90), Yellow: floatPointer(50)},
+			err:   "",
+		},
+		{
+			badge: BadgeConfig{Green: floatPointer(-1)},
+			err:   "badge green (-1.0) is outside the range 0-100",
+		},
+		{
+			badge: BadgeConfig{Yellow: floatPointer(-1)},
+			err:   "badge yellow (-1.0) is outside the range 0-100",
+		},
+		{
+			badge: BadgeConfig{Green: floatPointer(100), Yellow: floatPointer(101)},
+			err:   "badge yellow (101.0) is outside the range 0-100",
+		},
+		{
+			badge: BadgeConfig{Green: floatPointer(50), Yellow: floatPointer(60)},
+			err:   "badge yellow (60.0) must not be more than badge green (50.0)",
+		},
+	}
+	for _, test := range table {
+		badge := test.badge
+		err := validateBadgeConfig(Config{Badge: &badge})
+		if test.err == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.ErrorContains(t, err, test.err)
+		}
+	}
+}
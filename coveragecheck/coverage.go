@@ -0,0 +1,173 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CoverageLine represents a single line of coverage output.
+type CoverageLine struct {
+	// Filename is the name of the source file, with the module path removed.
+	Filename string
+	// LineNumber is the line number the function can be found at.
+	LineNumber string
+	// Function is the name of the function.
+	Function string
+	// Coverage is the coverage percentage.
+	Coverage float64
+}
+
+// Line returns LineNumber as an int.  `go tool cover` always outputs a line
+// number, so there's no error to handle.
+func (coverage CoverageLine) Line() int {
+	line, _ := strconv.Atoi(coverage.LineNumber)
+	return line
+}
+
+func (coverage CoverageLine) String() string {
+	return fmt.Sprintf("%s:%s:\t%s\t%.1f%%",
+		coverage.Filename, coverage.LineNumber, coverage.Function, coverage.Coverage)
+}
+
+// CaptureOutput runs a command and returns the output on success (a slice of
+// strings) and an error on failure.
+func CaptureOutput(command string, args ...string) ([]string, error) {
+	return CaptureOutputInDir("")(command, args...)
+}
+
+// CaptureOutputContext is like CaptureOutput, but the command is killed if ctx
+// is done before the command finishes.
+func CaptureOutputContext(ctx context.Context, command string, args ...string) ([]string, error) {
+	return captureOutputInDir(ctx, "", command, args...)
+}
+
+// CaptureOutputInDir returns a function like CaptureOutput that runs commands
+// in dir, for use as Options.CaptureOutput when Options.Dir isn't the current
+// directory.
+func CaptureOutputInDir(dir string) func(string, ...string) ([]string, error) {
+	return func(command string, args ...string) ([]string, error) {
+		return captureOutputInDir(context.Background(), dir, command, args...)
+	}
+}
+
+// captureOutputInDir runs a command in dir, killing it if ctx is done, and
+// returns the output on success and an error on failure.
+func captureOutputInDir(ctx context.Context, dir, command string, args ...string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed running `%s`: %w\n%s", cmd, err, output)
+	}
+	return strings.Split(string(output), "\n"), nil
+}
+
+// goCover runs the commands to generate coverage.  It returns
+//   - a slice of strings containing the command's output
+//   - a slice of strings containing the coverage profile
+//   - an error if running any command failed.
+func goCover(options Options) ([]string, []string, error) {
+	file, err := options.CreateTemp("", "golang-coverage-check")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(file.Name())
+
+	args := []string{"test", "--covermode", options.CoverMode}
+	if options.CrossPackageTests {
+		// Measure coverage of this package while running the tests in every
+		// package in the module.
+		args = append(args, "--coverpkg", ".", options.ModulePath+"...")
+	}
+	if options.CrossPackageTests && options.Jobs > 0 {
+		err = testPackagesInParallel(options, file.Name())
+	} else {
+		_, err = options.CaptureOutput("go", append(args, "--coverprofile", file.Name())...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if options.UseProfile != nil {
+		if err := options.UseProfile(file.Name()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	lines, err := options.CaptureOutput("go", "tool", "cover", "--func", file.Name())
+	return lines, strings.Split(string(profile), "\n"), err
+}
+
+// ParseCoverageOutput parses all the coverage lines and turns each into a
+// CoverageLine, returning a slice of CoverageLine and an error.
+func ParseCoverageOutput(modulePath string, output []string) ([]CoverageLine, error) {
+	results := []CoverageLine{}
+	lineSplitter := regexp.MustCompile(`\t+`)
+	percentageExtractor := regexp.MustCompile(`^(.*)%$`)
+
+	for i := range output {
+		if len(output[i]) == 0 {
+			// Skip blank lines.
+			continue
+		}
+		parts := lineSplitter.Split(output[i], -1)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("expected 3 parts, found %v, in \"%v\" => %v", len(parts), output[i], parts)
+		}
+		if parts[0] == "total:" {
+			continue
+		}
+		rawFilename, rawFunction, rawPercentage := parts[0], parts[1], parts[2]
+
+		matches := percentageExtractor.FindStringSubmatch(rawPercentage)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("could not extract percentage from \"%v\"", rawPercentage)
+		}
+		percentage, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing \"%v\" as a float: %w", rawPercentage, err)
+		}
+		if percentage > 100 {
+			return nil, fmt.Errorf("percentage (%v) > 100 in \"%v\"", percentage, rawPercentage)
+		}
+		if percentage < 0 {
+			return nil, fmt.Errorf("percentage (%v) < 0 in \"%v\"", percentage, rawPercentage)
+		}
+
+		fileLineParts := strings.Split(rawFilename, ":")
+		if len(fileLineParts) != 3 {
+			return nil, fmt.Errorf("expected `filename:linenumber:` in \"%v\"", rawFilename)
+		}
+
+		results = append(results, CoverageLine{
+			Filename:   strings.TrimPrefix(fileLineParts[0], modulePath),
+			LineNumber: fileLineParts[1],
+			Function:   rawFunction,
+			Coverage:   percentage,
+		})
+	}
+	return results, nil
+}
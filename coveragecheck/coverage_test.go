@@ -0,0 +1,321 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoverageLineLine(t *testing.T) {
+	assert.Equal(t, 42, CoverageLine{Filename: "api.go", LineNumber: "42", Function: "Get"}.Line())
+}
+
+func TestCaptureOutput(t *testing.T) {
+	output, err := CaptureOutput("cat", "/non-existent")
+	assert.Nil(t, output)
+	assert.ErrorContains(t, err, "cat: /non-existent: No such file or directory")
+
+	output, err = CaptureOutput("cat", "/etc/passwd")
+	assert.Nil(t, err)
+	rootLines := []string{}
+	for _, line := range output {
+		if strings.HasPrefix(line, "root:") {
+			rootLines = append(rootLines, line)
+		}
+	}
+	assert.Len(t, rootLines, 1)
+
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("contents"), 0644))
+	output, err = CaptureOutputInDir(dir)("cat", "file.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"contents"}, output)
+}
+
+func TestGoCoverSuccess(t *testing.T) {
+	fakeOutput := map[string][]string{
+		"test --covermode set --coverprofile": {"ignored"},
+		"tool cover --func":                   {"expected return value"},
+	}
+	commandRun := map[string]bool{}
+	options := newTestOptions()
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		// The random filename is always the last arg, so drop it.
+		parts := args[0 : len(args)-1]
+		key := strings.Join(parts, " ")
+		commandRun[key] = true
+		return fakeOutput[key], nil
+	}
+	actual, _, err := goCover(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"expected return value"}, actual)
+	assert.Equal(t, len(commandRun), 2)
+	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
+	assert.True(t, commandRun["tool cover --func"], commandRun)
+}
+
+func TestGoCoverCountMode(t *testing.T) {
+	commandRun := map[string]bool{}
+	options := newTestOptions()
+	options.CoverMode = "count"
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		// The random filename is always the last arg, so drop it.
+		parts := args[0 : len(args)-1]
+		commandRun[strings.Join(parts, " ")] = true
+		return nil, nil
+	}
+	_, _, err := goCover(options)
+	assert.Nil(t, err)
+	assert.True(t, commandRun["test --covermode count --coverprofile"], commandRun)
+}
+
+func TestGoCoverCrossPackageTests(t *testing.T) {
+	commandRun := map[string]bool{}
+	options := newTestOptions()
+	options.ModulePath = "example.com/mod/"
+	options.CrossPackageTests = true
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		// The random filename is always the last arg, so drop it.
+		parts := args[0 : len(args)-1]
+		commandRun[strings.Join(parts, " ")] = true
+		return nil, nil
+	}
+	_, _, err := goCover(options)
+	assert.Nil(t, err)
+	assert.True(t, commandRun["test --covermode set --coverpkg . example.com/mod/... --coverprofile"], commandRun)
+}
+
+func TestGoCoverJobs(t *testing.T) {
+	options, tested, _ := packagesOptions([]string{"example.com/mod", "example.com/mod/sub"}, map[string]string{
+		"example.com/mod":     "mode: set\nexample.com/mod/api.go:10.20,14.2 2 1\n",
+		"example.com/mod/sub": "mode: set\nexample.com/mod/api.go:10.20,14.2 2 0\n",
+	}, nil)
+	options.CrossPackageTests = true
+	options.Jobs = 2
+	listPackages := options.CaptureOutput
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "tool" {
+			return []string{"expected return value"}, nil
+		}
+		return listPackages(command, args...)
+	}
+	actual, profile, err := goCover(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"expected return value"}, actual)
+	assert.Equal(t, []string{"mode: set", "example.com/mod/api.go:10.20,14.2 2 1", ""}, profile)
+	assert.ElementsMatch(t, []string{"example.com/mod", "example.com/mod/sub"}, *tested)
+}
+
+func TestCaptureOutputContext(t *testing.T) {
+	output, err := CaptureOutputContext(context.Background(), "echo", "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hello", ""}, output)
+
+	// The command is killed when the context is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = CaptureOutputContext(ctx, "sleep", "60")
+	assert.ErrorContains(t, err, "failed running `")
+	assert.Less(t, time.Since(start), 30*time.Second)
+}
+
+func TestGoCoverUseProfileFailure(t *testing.T) {
+	commandRun := map[string]bool{}
+	options := newTestOptions()
+	options.UseProfile = func(string) error {
+		return errors.New("error for testing")
+	}
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		// The random filename is always the last arg, so drop it.
+		parts := args[0 : len(args)-1]
+		commandRun[strings.Join(parts, " ")] = true
+		return nil, nil
+	}
+
+	actual, _, err := goCover(options)
+	assert.ErrorContains(t, err, "error for testing")
+	assert.Nil(t, actual)
+	assert.Equal(t, 1, len(commandRun), commandRun)
+	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
+}
+
+func TestGoCoverUseProfile(t *testing.T) {
+	profilePath := ""
+	options := newTestOptions()
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "test" {
+			profilePath = args[len(args)-1]
+		}
+		return []string{"expected return value"}, nil
+	}
+	options.UseProfile = func(path string) error {
+		assert.Equal(t, profilePath, path)
+		// The profile must exist when UseProfile is called.
+		_, err := os.Stat(path)
+		return err
+	}
+
+	actual, _, err := goCover(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"expected return value"}, actual)
+	assert.NotEmpty(t, profilePath)
+}
+
+func TestGoCoverCaptureFailure(t *testing.T) {
+	options := newTestOptions()
+	options.CaptureOutput = func(string, ...string) ([]string, error) {
+		return []string{"this should not be seen"}, errors.New("error for testing")
+	}
+	actual, _, err := goCover(options)
+	assert.Nil(t, actual)
+	assert.ErrorContains(t, err, "error for testing")
+}
+
+func TestGoCoverReadProfileFailure(t *testing.T) {
+	options := newTestOptions()
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		// Remove the coverage profile so that reading it fails.
+		return nil, os.Remove(args[len(args)-1])
+	}
+	actual, profile, err := goCover(options)
+	assert.Nil(t, actual)
+	assert.Nil(t, profile)
+	assert.ErrorContains(t, err, "no such file or directory")
+}
+
+func TestGoCoverCreateTempFailure(t *testing.T) {
+	options := newTestOptions()
+	options.CreateTemp = func(string, string) (*os.File, error) {
+		return nil, errors.New("error for testing")
+	}
+	actual, _, err := goCover(options)
+	assert.Nil(t, actual)
+	assert.ErrorContains(t, err, "error for testing")
+}
+
+func validCoverageOutput() []string {
+	coverage := `
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:26:		String			100.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:48:		String			31.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:53:		makeExampleConfig	50.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:95:		ParseConfig		100.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:118:	realMain		17.3%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:140:	main			0.0%
+total:											(statements)		38.1%
+`
+	return strings.Split(coverage, "\n")
+}
+
+func TestParseCoverageOutputSuccess(t *testing.T) {
+	results, err := ParseCoverageOutput("github.com/tobinjt/golang-coverage-check/", validCoverageOutput())
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(results))
+
+	expected := []CoverageLine{
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "26",
+			Function:   "String",
+			Coverage:   100.0,
+		},
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "48",
+			Function:   "String",
+			Coverage:   31.0,
+		},
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "53",
+			Function:   "makeExampleConfig",
+			Coverage:   50.0,
+		},
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "95",
+			Function:   "ParseConfig",
+			Coverage:   100.0,
+		},
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "118",
+			Function:   "realMain",
+			Coverage:   17.3,
+		},
+		{
+			Filename:   "golang-coverage-check.go",
+			LineNumber: "140",
+			Function:   "main",
+			Coverage:   0.0,
+		},
+	}
+	assert.Equal(t, expected, results)
+}
+
+func TestParseCoverageOutputFailure(t *testing.T) {
+	badInputLine := `
+github.com/.../golang-coverage-check.go:26:		String			100.0%
+asdf
+github.com/.../golang-coverage-check.go:140:	main			0.0%
+total:											(statements)		38.1%
+`
+	_, err := ParseCoverageOutput("", strings.Split(badInputLine, "\n"))
+	assert.ErrorContains(t, err, "expected 3 parts, found 1")
+
+	badInputLine = `missing-line-number:		String			100.0%`
+	_, err = ParseCoverageOutput("", []string{badInputLine})
+	assert.ErrorContains(t, err, "expected `filename:linenumber:` in \"missing-line-number:\"")
+
+	table := []struct {
+		input string
+		err   string
+	}{
+		{
+			err:   "could not extract percentage from",
+			input: "1.2",
+		},
+		{
+			err:   "could not extract percentage from",
+			input: "qwerty",
+		},
+		{
+			err:   "strconv.ParseFloat: parsing",
+			input: "asdf%",
+		},
+		{
+			err:   "percentage (-12.2) < 0",
+			input: "-12.2%",
+		},
+		{
+			err:   "percentage (105.3) > 100",
+			input: "105.3%",
+		},
+	}
+	for _, test := range table {
+		input := fmt.Sprintf("foo.go:26:		String			%s", test.input)
+		_, err := ParseCoverageOutput("", []string{input})
+		assert.ErrorContains(t, err, test.err)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coveragecheck checks that Go code has sufficient test coverage.  It
+// parses a config of coverage rules, parses the code to find every function,
+// runs the tests to collect coverage, and checks each function against the
+// rules.  Check does all of that; the other exported functions are the
+// individual steps, for tools that need more control.
+package coveragecheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultConfigFile is the config file read when Options.ConfigFile isn't
+// changed.
+const DefaultConfigFile = ".golang-coverage-check.yaml"
+
+// Options configures Check.  Use NewOptions to get an Options with every field
+// set to its standard value.  The functions exist so that tests can replace
+// them to trigger failure handling.
+type Options struct {
+	// Used by Check to run binaries and capture their stdout.
+	CaptureOutput func(string, ...string) ([]string, error)
+	// Like CaptureOutput, but the command must be killed when the context is
+	// done; used to test packages when Jobs is positive, so that FailFast can
+	// cancel them.  It must run commands in the same directory as
+	// CaptureOutput.
+	CaptureOutputContext func(context.Context, string, ...string) ([]string, error)
+	// Used to create the temporary file the coverage profile is written to.
+	CreateTemp func(string, string) (*os.File, error)
+	// If non-nil, called with the path of the coverage profile before it's
+	// removed, e.g. to run `go tool cover --html`.
+	UseProfile func(string) error
+
+	// ConfigFile is the config to read.
+	ConfigFile string
+	// BaselineFile, if non-empty, is a baseline written by Baseline.String;
+	// functions whose coverage is lower than in the baseline fail.
+	BaselineFile string
+	// Dir is the directory parsed to find functions; it must be the directory
+	// containing the package, which is where CaptureOutput runs the tests,
+	// usually the current directory; see CaptureOutputInDir.
+	Dir string
+	// ModulePath is the module path from go.mod followed by "/"; it's removed
+	// from filenames in the coverage output.
+	ModulePath string
+	// CoverMode is passed to `go test --covermode`; "count" is needed for hit
+	// counts in ProfileBlock.Count, otherwise "set" is sufficient.
+	CoverMode string
+	// CrossPackageTests makes the coverage include the tests in every package
+	// in the module, using `go test --coverpkg`, rather than only the tests in
+	// the package.  Functions matching a rule that sets RequireOwnPackageTests
+	// are still checked using coverage from the package's own tests.
+	CrossPackageTests bool
+	// Jobs, if positive, makes CrossPackageTests run a separate `go test` for
+	// each package, at most Jobs at once, and merge the coverage profiles;
+	// otherwise a single `go test` tests every package.  Without
+	// CrossPackageTests only one package is tested, so Jobs has no effect.
+	Jobs int
+	// FailFast cancels the packages that are being tested and skips the
+	// packages that haven't started after the first package whose tests fail,
+	// when Jobs is positive.
+	FailFast bool
+	// TestOutput, if non-nil, is where the output of each package's `go test`
+	// is written when Jobs is positive.  The output of each package is written
+	// in one piece, in the order the packages are listed, as soon as it and
+	// every earlier package have finished.
+	TestOutput io.Writer
+	// AttributeTests makes Check find which tests execute each function, even
+	// if no rule sets MinCoveringTests; see AttributeTests.
+	AttributeTests bool
+}
+
+// NewOptions returns an Options struct with fields set to standard values;
+// ModulePath must be set by the caller.
+func NewOptions() Options {
+	return Options{
+		CaptureOutput:        CaptureOutput,
+		CaptureOutputContext: CaptureOutputContext,
+		CreateTemp:           os.CreateTemp,
+		ConfigFile:           DefaultConfigFile,
+		Dir:                  ".",
+		CoverMode:            "set",
+	}
+}
+
+// Report contains everything collected and calculated by Check.
+type Report struct {
+	// Config is the parsed config.
+	Config Config
+	// Functions contains every function and closure found by ParseFunctions.
+	Functions FunctionInfoMap
+	// Coverage contains the coverage of every function, including closures.
+	Coverage []CoverageLine
+	// Blocks contains the coverage profile.
+	Blocks []ProfileBlock
+	// Results contains the result of checking each function in Coverage; use
+	// ViolationsError to find out whether any function failed.
+	Results []CheckResult
+	// Attribution contains the tests that execute each function; nil unless
+	// Options.AttributeTests is true or a rule sets MinCoveringTests.
+	Attribution Attribution
+}
+
+// Check reads the config, parses the code, runs the tests, and checks
+// coverage.  Errors that prevent checking coverage are returned; insufficient
+// coverage is reported in Report.Results.
+func Check(options Options) (Report, error) {
+	report := Report{}
+	configBytes, err := os.ReadFile(options.ConfigFile)
+	if err != nil {
+		return report, fmt.Errorf("failed reading config %v: %w", options.ConfigFile, err)
+	}
+	report.Config, err = ParseConfig(configBytes)
+	if err != nil {
+		return report, fmt.Errorf("failed parsing config %v: %w", options.ConfigFile, err)
+	}
+
+	report.Functions, err = ParseFunctions(options.Dir, strings.TrimSuffix(options.ModulePath, "/"))
+	if err != nil {
+		return report, fmt.Errorf("failed parsing code: %w", err)
+	}
+
+	report.Coverage, report.Blocks, err = collectCoverage(options, report.Functions)
+	if err != nil {
+		return report, err
+	}
+	report.Results = CheckCoverage(report.Config, report.Coverage, report.Functions, report.Blocks)
+	if options.CrossPackageTests && report.Config.needsOwnPackageCoverage() {
+		ownOptions := options
+		ownOptions.CrossPackageTests = false
+		ownOptions.UseProfile = nil
+		ownCoverage, ownBlocks, err := collectCoverage(ownOptions, report.Functions)
+		if err != nil {
+			return report, fmt.Errorf("failed collecting coverage from the package's own tests: %w", err)
+		}
+		report.Results = CheckOwnPackageCoverage(report.Results,
+			CheckCoverage(report.Config, ownCoverage, report.Functions, ownBlocks))
+	}
+	if options.BaselineFile != "" {
+		baselineBytes, err := os.ReadFile(options.BaselineFile)
+		if err != nil {
+			return report, fmt.Errorf("failed reading baseline %v: %w", options.BaselineFile, err)
+		}
+		baseline, err := ParseBaseline(baselineBytes)
+		if err != nil {
+			return report, fmt.Errorf("failed parsing baseline %v: %w", options.BaselineFile, err)
+		}
+		report.Results = CheckBaseline(report.Results, baseline)
+	}
+	if options.AttributeTests || report.Config.needsAttribution() {
+		report.Attribution, err = AttributeTests(options, report.Functions)
+		if err != nil {
+			return report, err
+		}
+		report.Results = CheckCoveringTests(report.Results, report.Attribution)
+	}
+	return report, nil
+}
+
+// collectCoverage runs the tests and parses the coverage of every function in
+// fInfoMap, including closures, returning the coverage, the coverage profile,
+// and an error.
+func collectCoverage(options Options, fInfoMap FunctionInfoMap) ([]CoverageLine, []ProfileBlock, error) {
+	rawCoverage, rawProfile, err := goCover(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	coverage, err := ParseCoverageOutput(options.ModulePath, rawCoverage)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocks, err := ParseProfile(options.ModulePath, rawProfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(coverage, ClosureCoverage(blocks, fInfoMap)...), blocks, nil
+}
@@ -0,0 +1,267 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOptions() Options {
+	options := NewOptions()
+	options.CaptureOutput = func(string, ...string) ([]string, error) {
+		panic("CaptureOutput was called without being set by the test")
+	}
+	options.CaptureOutputContext = func(context.Context, string, ...string) ([]string, error) {
+		panic("CaptureOutputContext was called without being set by the test")
+	}
+	return options
+}
+
+func TestNewOptions(t *testing.T) {
+	options := NewOptions()
+	assert.Equal(t, DefaultConfigFile, options.ConfigFile)
+	assert.Equal(t, ".", options.Dir)
+	assert.Equal(t, "set", options.CoverMode)
+	assert.Nil(t, options.UseProfile)
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	validConfig := filepath.Join(dir, "valid.yaml")
+	assert.Nil(t, os.WriteFile(validConfig, []byte("default_coverage: 50"), 0644))
+	invalidConfig := filepath.Join(dir, "invalid.yaml")
+	assert.Nil(t, os.WriteFile(invalidConfig, []byte("asdf"), 0644))
+	baseline := filepath.Join(dir, "baseline.json")
+	assert.Nil(t, os.WriteFile(baseline, []byte(`{"schema_version": 2, "functions": {"golang-coverage-check.go:String#2": 40}}`), 0644))
+	// coverageAndProfile returns a CaptureOutput that outputs coverage and
+	// writes profile to the coverage profile.
+	coverageAndProfile := func(coverage []string, profile string) func(string, ...string) ([]string, error) {
+		return func(command string, args ...string) ([]string, error) {
+			if args[0] == "test" {
+				return nil, os.WriteFile(args[len(args)-1], []byte(profile), 0644)
+			}
+			return coverage, nil
+		}
+	}
+
+	table := []struct {
+		desc string
+		err  string
+		mod  func(Options) Options
+	}{
+		{
+			desc: "missing config",
+			err:  "failed reading config " + filepath.Join(dir, "missing.yaml"),
+			mod: func(opts Options) Options {
+				opts.ConfigFile = filepath.Join(dir, "missing.yaml")
+				return opts
+			},
+		},
+		{
+			desc: "invalid config",
+			err:  "failed parsing config " + invalidConfig,
+			mod: func(opts Options) Options {
+				opts.ConfigFile = invalidConfig
+				return opts
+			},
+		},
+		{
+			desc: "missing directory",
+			err:  "failed parsing code: ",
+			mod: func(opts Options) Options {
+				opts.Dir = "does-not-exist"
+				return opts
+			},
+		},
+		{
+			desc: "running tests fails",
+			err:  "tests failed",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(string, ...string) ([]string, error) {
+					return nil, errors.New("tests failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "bad coverage output",
+			err:  "expected 3 parts, found 1",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = coverageAndProfile([]string{"asdf"}, "mode: set\n")
+				return opts
+			},
+		},
+		{
+			desc: "bad coverage profile",
+			err:  "could not parse coverage profile line \"asdf\"",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = coverageAndProfile(validCoverageOutput(), "mode: set\nasdf\n")
+				return opts
+			},
+		},
+		{
+			desc: "missing baseline",
+			err:  "failed reading baseline " + filepath.Join(dir, "missing.json"),
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = coverageAndProfile(validCoverageOutput(), "mode: set\n")
+				opts.BaselineFile = filepath.Join(dir, "missing.json")
+				return opts
+			},
+		},
+		{
+			desc: "invalid baseline",
+			err:  "failed parsing baseline " + invalidConfig,
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = coverageAndProfile(validCoverageOutput(), "mode: set\n")
+				opts.BaselineFile = invalidConfig
+				return opts
+			},
+		},
+	}
+	for _, test := range table {
+		options := newTestOptions()
+		options.ConfigFile = validConfig
+		_, err := Check(test.mod(options))
+		assert.ErrorContains(t, err, test.err, test.desc)
+	}
+
+	options := newTestOptions()
+	options.ConfigFile = validConfig
+	options.ModulePath = "github.com/tobinjt/golang-coverage-check/"
+	options.Dir = parseFunctionsDir
+	options.CaptureOutput = coverageAndProfile(validCoverageOutput(),
+		"mode: set\ngithub.com/tobinjt/golang-coverage-check/functions-for-testing-ParseFunctions.go:31.44,35.2 2 1\n")
+	report, err := Check(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 50.0, report.Config.DefaultCoverage)
+	assert.Contains(t, report.Functions, "github.com/tobinjt/golang-coverage-check.functionAtLine20")
+	assert.Equal(t, 1, len(report.Blocks))
+	// The closures in functions-for-testing-ParseFunctions.go are added after
+	// the coverage output.
+	assert.Equal(t, "functionWithClosures.func1", report.Coverage[6].Function)
+	assert.Equal(t, len(report.Coverage), len(report.Results))
+	assert.ErrorContains(t, ViolationsError(report.Results), "golang-coverage-check.go:48:\tString\t31.0%: actual coverage 31.0% < default coverage 50.0%")
+	assert.NotContains(t, ViolationsError(report.Results).Error(), "baseline")
+
+	assert.Nil(t, report.Attribution)
+	assert.Nil(t, report.Results[0].CoveringTests)
+
+	options.BaselineFile = baseline
+	report, err = Check(options)
+	assert.Nil(t, err)
+	assert.ErrorContains(t, ViolationsError(report.Results), "golang-coverage-check.go:48:\tString\t31.0%: actual coverage 31.0% < baseline coverage 40.0%")
+}
+
+func TestCheckCrossPackageTests(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, os.WriteFile(config, []byte("default_coverage: 50\nrules:\n  - function_regex: ^realMain$\n    require_own_package_tests: true\n    coverage: 50\n"), 0644))
+	// The package's own tests only give realMain 17.3% coverage, but other
+	// packages' tests give it 80% coverage.
+	ownCoverage := validCoverageOutput()
+	crossCoverage := []string{}
+	for _, line := range ownCoverage {
+		crossCoverage = append(crossCoverage, strings.Replace(line, "17.3%", "80.0%", 1))
+	}
+	crossPackage := false
+	options := newTestOptions()
+	options.ConfigFile = config
+	options.ModulePath = "github.com/tobinjt/golang-coverage-check/"
+	options.CrossPackageTests = true
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "test" {
+			crossPackage = args[3] == "--coverpkg"
+			return nil, nil
+		}
+		if crossPackage {
+			return crossCoverage, nil
+		}
+		return ownCoverage, nil
+	}
+	report, err := Check(options)
+	assert.Nil(t, err)
+	assert.ErrorContains(t, ViolationsError(report.Results),
+		"golang-coverage-check.go:118:\trealMain\t17.3%: actual coverage 17.3% < required coverage 50.0%")
+	// The report contains coverage from every package's tests.
+	assert.Equal(t, 80.0, report.Coverage[4].Coverage)
+
+	// Coverage from the package's own tests isn't collected unless a rule
+	// needs it.
+	assert.Nil(t, os.WriteFile(config, []byte("default_coverage: 50\n"), 0644))
+	report, err = Check(options)
+	assert.Nil(t, err)
+	assert.Nil(t, ViolationsError(report.Results[4:5]))
+
+	assert.Nil(t, os.WriteFile(config, []byte("default_coverage: 50\nrules:\n  - function_regex: ^realMain$\n    require_own_package_tests: true\n    coverage: 50\n"), 0644))
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "test" && args[3] != "--coverpkg" {
+			return nil, errors.New("tests failed")
+		}
+		return crossCoverage, nil
+	}
+	_, err = Check(options)
+	assert.ErrorContains(t, err, "failed collecting coverage from the package's own tests: tests failed")
+}
+
+func TestCheckAttributeTests(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	assert.Nil(t, os.WriteFile(config, []byte("default_coverage: 0\nrules:\n  - function_regex: ^functionAtLine20$\n    min_covering_tests: 2\n    coverage: 0\n"), 0644))
+	profile := "mode: set\ngithub.com/tobinjt/golang-coverage-check/functions-for-testing-ParseFunctions.go:20.37,22.2 1 1\n"
+	options := newTestOptions()
+	options.ConfigFile = config
+	options.ModulePath = "github.com/tobinjt/golang-coverage-check/"
+	options.Dir = parseFunctionsDir
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		switch args[1] {
+		case "--list":
+			return []string{"TestFunctionAtLine20"}, nil
+		case "cover":
+			return []string{"github.com/tobinjt/golang-coverage-check/functions-for-testing-ParseFunctions.go:20:\tfunctionAtLine20\t100.0%"}, nil
+		}
+		return nil, os.WriteFile(args[len(args)-1], []byte(profile), 0644)
+	}
+	// min_covering_tests turns on attribution.
+	report, err := Check(options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"TestFunctionAtLine20"},
+		report.Attribution["github.com/tobinjt/golang-coverage-check.functionAtLine20"])
+	assert.Equal(t, []string{"TestFunctionAtLine20"}, report.Results[0].CoveringTests)
+	assert.ErrorContains(t, ViolationsError(report.Results), "1 covering tests < minimum covering tests 2")
+
+	// Options.AttributeTests turns on attribution without min_covering_tests.
+	assert.Nil(t, os.WriteFile(config, []byte("default_coverage: 0\n"), 0644))
+	options.AttributeTests = true
+	report, err = Check(options)
+	assert.Nil(t, err)
+	assert.Nil(t, ViolationsError(report.Results))
+	assert.Equal(t, []string{"TestFunctionAtLine20"}, report.Results[0].CoveringTests)
+
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		if args[1] == "--list" {
+			return nil, errors.New("error for testing")
+		}
+		return nil, os.WriteFile(args[len(args)-1], []byte(profile), 0644)
+	}
+	_, err = Check(options)
+	assert.ErrorContains(t, err, "failed listing tests: error for testing")
+}
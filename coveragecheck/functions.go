@@ -0,0 +1,283 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FunctionInfo describes a function, method, or closure found by
+// ParseFunctions.
+type FunctionInfo struct {
+	// ID identifies the function even when code is edited: the package import
+	// path, the receiver for methods, and the function name, e.g.
+	// example.com/mod.Client.Get.  Functions whose name can be used several
+	// times in a package, init and _, and their closures, also include the
+	// filename, e.g. example.com/mod.init@api.go, as do functions defined in
+	// several files, e.g. with build constraints.  Functions with the same ID
+	// in a file are distinguished by Ordinal, e.g. example.com/mod.init@api.go
+	// and example.com/mod.init@api.go#2, so adding functions to other files
+	// doesn't change IDs.
+	ID string
+	// The filename the function is defined in, without the directory passed to
+	// ParseFunctions so that it matches the filenames in the coverage output.
+	Filename string
+	// The line number of the function definition.
+	LineNumber string
+	// The function name.  Closures are named like the compiler names them:
+	// Parent.func1, Parent.func2, and Parent.func1.1 for a closure inside
+	// Parent.func1.
+	Function string
+	// For functions: empty string.  For methods: the receiver type, without
+	// pointers or type parameters.  For closures: the receiver of the enclosing
+	// function.
+	Receiver string
+	// Exported is true for exported functions, and for exported methods of
+	// exported types.  Always false for closures.
+	Exported bool
+	// ReturnsError is true if any of the function's results is an error.
+	ReturnsError bool
+	// ParamCount is the number of parameters, not including the receiver.
+	ParamCount int
+	// Complexity is the cyclomatic complexity of the function.
+	Complexity int
+	// The column the function definition starts at.
+	StartColumn int
+	// The position of the end of the function.
+	EndLine   int
+	EndColumn int
+	// Closure is true for function literals.
+	Closure bool
+	// Ordinal distinguishes functions with the same filename, receiver, and
+	// name, like init: it's the function's position among them in the file,
+	// counting from 1.  Zero if no other function in the file has the same
+	// receiver and name.
+	Ordinal int
+}
+
+// Line returns LineNumber as an int.  ParseFunctions always sets LineNumber
+// from the position of the function, so there's no error to handle.
+func (fl FunctionInfo) Line() int {
+	line, _ := strconv.Atoi(fl.LineNumber)
+	return line
+}
+
+// FunctionInfoMap maps from FunctionInfo.ID to the function.
+type FunctionInfoMap map[string]FunctionInfo
+
+// FunctionLocationKey turns a filename, line number, and function name into a
+// string key for the map returned by FunctionInfoMap.ByLocation; it must
+// return the same key as FunctionInfo.LocationKey().  The function name is
+// included because a closure can start on the same line as its enclosing
+// function.
+func FunctionLocationKey(filename, lineNumber, function string) string {
+	return filename + ":" + lineNumber + ":" + function
+}
+
+// LocationKey generates a string key for the map returned by
+// FunctionInfoMap.ByLocation; it must return the same key as
+// FunctionLocationKey.
+func (fl FunctionInfo) LocationKey() string {
+	return fl.Filename + ":" + fl.LineNumber + ":" + fl.Function
+}
+
+// ByLocation returns a map from the key returned by FunctionLocationKey to the
+// function at that location, for looking up the functions in the coverage
+// output, which only identifies functions by location.
+func (fmap FunctionInfoMap) ByLocation() map[string]FunctionInfo {
+	locations := map[string]FunctionInfo{}
+	for _, fi := range fmap {
+		locations[fi.LocationKey()] = fi
+	}
+	return locations
+}
+
+// sortFunctions sorts functions by filename and position.
+func sortFunctions(functions []FunctionInfo) {
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Filename != functions[j].Filename {
+			return functions[i].Filename < functions[j].Filename
+		}
+		if functions[i].Line() != functions[j].Line() {
+			return functions[i].Line() < functions[j].Line()
+		}
+		return functions[i].StartColumn < functions[j].StartColumn
+	})
+}
+
+// ParseFunctions parses the code in dir, which is the package with import path
+// importPath, and constructs a map from FunctionInfo.ID to FunctionInfo,
+// returning a FunctionInfoMap and an error.  Closures are included so that
+// they can be checked separately from their enclosing function.
+func ParseFunctions(dir, importPath string) (FunctionInfoMap, error) {
+	fset := token.NewFileSet()
+	packageMap, err := parser.ParseDir(fset, dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	functions := []FunctionInfo{}
+	for _, pkg := range packageMap {
+		packagePath := importPath
+		// External tests are in a different package.
+		if strings.HasSuffix(pkg.Name, "_test") {
+			packagePath += "_test"
+		}
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if function, ok := decl.(*ast.FuncDecl); ok {
+					fl := newFunctionInfo(fset, function, function.Type, function.Name.Name)
+					fl.Exported = function.Name.IsExported()
+					if function.Recv != nil {
+						fl.Receiver = receiverTypeName(function.Recv.List[0].Type)
+						fl.Exported = fl.Exported && ast.IsExported(fl.Receiver)
+					}
+					found := []FunctionInfo{fl}
+					if function.Body != nil {
+						found = append(found, closures(fset, function.Body, fl)...)
+					}
+					for _, fi := range found {
+						name := fi.Function
+						if fi.Receiver != "" {
+							name = fi.Receiver + "." + name
+						}
+						// Duplicates are disambiguated below.
+						fi.ID = packagePath + "." + name
+						functions = append(functions, fi)
+					}
+				}
+			}
+		}
+	}
+
+	sortFunctions(functions)
+	inFile := map[string]int{}
+	files := map[string]map[string]bool{}
+	for _, fi := range functions {
+		inFile[fi.Filename+":"+fi.ID]++
+		if files[fi.ID] == nil {
+			files[fi.ID] = map[string]bool{}
+		}
+		files[fi.ID][fi.Filename] = true
+	}
+	fmap := make(FunctionInfoMap)
+	seenInFile := map[string]int{}
+	for _, fi := range functions {
+		key := fi.Filename + ":" + fi.ID
+		seenInFile[key]++
+		if inFile[key] > 1 {
+			fi.Ordinal = seenInFile[key]
+		}
+		if canRepeat(fi) || len(files[fi.ID]) > 1 {
+			fi.ID += "@" + fi.Filename
+		}
+		if fi.Ordinal > 1 {
+			fi.ID = fmt.Sprintf("%s#%d", fi.ID, fi.Ordinal)
+		}
+		fmap[fi.ID] = fi
+	}
+	return fmap, nil
+}
+
+// canRepeat reports whether the function, or the function enclosing a
+// closure, has a name that can be used several times in a package: init
+// functions and functions or methods named _.
+func canRepeat(fi FunctionInfo) bool {
+	name := strings.SplitN(fi.Function, ".", 2)[0]
+	return name == "_" || (name == "init" && fi.Receiver == "")
+}
+
+// newFunctionInfo creates a FunctionInfo for the function or closure in node,
+// with funcType being the signature of node.
+func newFunctionInfo(fset *token.FileSet, node ast.Node, funcType *ast.FuncType, name string) FunctionInfo {
+	start := fset.Position(node.Pos())
+	end := fset.Position(node.End())
+	fl := FunctionInfo{
+		Filename:    filepath.Base(start.Filename),
+		LineNumber:  fmt.Sprintf("%d", start.Line),
+		Function:    name,
+		Receiver:    "",
+		StartColumn: start.Column,
+		EndLine:     end.Line,
+		EndColumn:   end.Column,
+		Complexity:  cyclomaticComplexity(node),
+	}
+	for _, param := range funcType.Params.List {
+		// Unnamed parameters have no names but are still a parameter.
+		if len(param.Names) == 0 {
+			fl.ParamCount++
+		}
+		fl.ParamCount += len(param.Names)
+	}
+	if funcType.Results != nil {
+		for _, result := range funcType.Results.List {
+			if ident, ok := result.Type.(*ast.Ident); ok && ident.Name == "error" {
+				fl.ReturnsError = true
+			}
+		}
+	}
+	return fl
+}
+
+// receiverTypeName returns the name of the type in a method receiver,
+// removing pointers, parentheses, and type parameters.
+func receiverTypeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(t.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(t.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// closures returns a FunctionInfo for each function literal in body,
+// recursing into each function literal to find nested function literals.
+// parent is the function that body belongs to.
+func closures(fset *token.FileSet, body *ast.BlockStmt, parent FunctionInfo) []FunctionInfo {
+	found := []FunctionInfo{}
+	count := 0
+	ast.Inspect(body, func(node ast.Node) bool {
+		literal, ok := node.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		count++
+		name := fmt.Sprintf("%s.func%d", parent.Function, count)
+		if parent.Closure {
+			name = fmt.Sprintf("%s.%d", parent.Function, count)
+		}
+		fl := newFunctionInfo(fset, literal, literal.Type, name)
+		fl.Receiver = parent.Receiver
+		fl.Closure = true
+		found = append(found, fl)
+		found = append(found, closures(fset, literal.Body, fl)...)
+		// Nested function literals were handled by the recursive call.
+		return false
+	})
+	return found
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"go/ast"
+	"go/parser"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseFunctionsDir contains the functions parsed by the tests for
+// ParseFunctions() and Check().
+const parseFunctionsDir = "testdata/parsefunctions"
+
+func TestParseFunctionsFailure(t *testing.T) {
+	_, err := ParseFunctions("does-not-exist", "example.com/mod")
+	assert.Error(t, err)
+}
+
+func TestParseFunctionsSuccess(t *testing.T) {
+	fmap, err := ParseFunctions(parseFunctionsDir, "example.com/mod")
+	assert.Nil(t, err)
+	fis := []FunctionInfo{
+		{
+			ID:          "example.com/mod.functionAtLine20",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "20",
+			Function:    "functionAtLine20",
+			Receiver:    "",
+			StartColumn: 1,
+			EndLine:     22,
+			EndColumn:   2,
+			Complexity:  1,
+		},
+		{
+			ID:          "example.com/mod.methodReceiver.String",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "26",
+			Function:    "String",
+			Receiver:    "methodReceiver",
+			StartColumn: 1,
+			EndLine:     28,
+			EndColumn:   2,
+			Complexity:  1,
+		},
+		{
+			ID:          "example.com/mod.functionWithClosures.func1",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "31",
+			Function:    "functionWithClosures.func1",
+			Receiver:    "",
+			StartColumn: 11,
+			EndLine:     34,
+			EndColumn:   3,
+			Complexity:  1,
+			Closure:     true,
+		},
+		{
+			ID:          "example.com/mod.functionWithClosures.func1.1",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "32",
+			Function:    "functionWithClosures.func1.1",
+			Receiver:    "",
+			StartColumn: 13,
+			EndLine:     32,
+			EndColumn:   54,
+			Complexity:  1,
+			Closure:     true,
+		},
+		{
+			ID:          "example.com/mod.functionWithClosures.func2",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "35",
+			Function:    "functionWithClosures.func2",
+			Receiver:    "",
+			StartColumn: 9,
+			EndLine:     37,
+			EndColumn:   3,
+			Complexity:  1,
+			Closure:     true,
+		},
+		{
+			ID:          "example.com/mod.methodReceiver.closureInMethod.func1",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "41",
+			Function:    "closureInMethod.func1",
+			Receiver:    "methodReceiver",
+			StartColumn: 9,
+			EndLine:     41,
+			EndColumn:   53,
+			Complexity:  1,
+			Closure:     true,
+		},
+		{
+			ID:           "example.com/mod.ExportedReceiver.ExportedMethod",
+			Filename:     "functions-for-testing-ParseFunctions.go",
+			LineNumber:   "46",
+			Function:     "ExportedMethod",
+			Receiver:     "ExportedReceiver",
+			Exported:     true,
+			ReturnsError: true,
+			ParamCount:   4,
+			StartColumn:  1,
+			EndLine:      48,
+			EndColumn:    2,
+			Complexity:   1,
+		},
+		{
+			ID:           "example.com/mod.methodReceiver.UnexportedTypeMethod",
+			Filename:     "functions-for-testing-ParseFunctions.go",
+			LineNumber:   "50",
+			Function:     "UnexportedTypeMethod",
+			Receiver:     "methodReceiver",
+			Exported:     false,
+			ReturnsError: true,
+			ParamCount:   1,
+			StartColumn:  1,
+			EndLine:      52,
+			EndColumn:    2,
+			Complexity:   1,
+		},
+		{
+			// The receiver doesn't include the pointer.
+			ID:          "example.com/mod.ExportedReceiver.PointerMethod",
+			Filename:    "functions-for-testing-ParseFunctions.go",
+			LineNumber:  "54",
+			Function:    "PointerMethod",
+			Receiver:    "ExportedReceiver",
+			Exported:    true,
+			StartColumn: 1,
+			EndLine:     56,
+			EndColumn:   2,
+			Complexity:  1,
+		},
+	}
+	for _, fi := range fis {
+		key := fi.ID
+		if assert.Contains(t, fmap, key) {
+			assert.Equal(t, fi, fmap[key])
+		}
+	}
+
+	// Filenames don't include the directory that was parsed.
+	fmapFromParent, err := ParseFunctions(filepath.Join("..", "coveragecheck", parseFunctionsDir), "example.com/mod")
+	assert.Nil(t, err)
+	assert.Equal(t, fmap, fmapFromParent)
+}
+
+func TestParseFunctionsIDs(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"b.go":           "package mod\n\nfunc init() {}\n\ntype T struct{}\n\nfunc (t *T) String() string { return \"\" }\n\nfunc (t *T) init() {}\n",
+		"a.go":           "package mod\n\nfunc init() {}\nfunc init() { _ = func() {} }\n",
+		"a_test.go":      "package mod_test\n\nfunc init() {}\n",
+		"open_linux.go":  "package mod\n\nfunc Open() {}\n",
+		"open_darwin.go": "package mod\n\nfunc Open() {}\n",
+	}
+	for name, contents := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	fmap, err := ParseFunctions(dir, "example.com/mod")
+	assert.Nil(t, err)
+	locations := map[string]string{}
+	ordinals := map[string]int{}
+	for id, fi := range fmap {
+		assert.Equal(t, id, fi.ID)
+		locations[id] = fi.Filename + ":" + fi.LineNumber
+		ordinals[id] = fi.Ordinal
+	}
+	// init functions and functions defined in several files include the
+	// filename, and functions with the same ID in a file are numbered in
+	// order.
+	assert.Equal(t, map[string]string{
+		"example.com/mod.init@a.go":           "a.go:3",
+		"example.com/mod.init@a.go#2":         "a.go:4",
+		"example.com/mod.init.func1@a.go":     "a.go:4",
+		"example.com/mod.init@b.go":           "b.go:3",
+		"example.com/mod.T.String":            "b.go:7",
+		"example.com/mod.T.init":              "b.go:9",
+		"example.com/mod.Open@open_darwin.go": "open_darwin.go:3",
+		"example.com/mod.Open@open_linux.go":  "open_linux.go:3",
+		"example.com/mod_test.init@a_test.go": "a_test.go:3",
+	}, locations)
+	// Only functions with the same receiver and name in the same file have
+	// ordinals.
+	assert.Equal(t, map[string]int{
+		"example.com/mod.init@a.go":           1,
+		"example.com/mod.init@a.go#2":         2,
+		"example.com/mod.init.func1@a.go":     0,
+		"example.com/mod.init@b.go":           0,
+		"example.com/mod.T.String":            0,
+		"example.com/mod.T.init":              0,
+		"example.com/mod.Open@open_darwin.go": 0,
+		"example.com/mod.Open@open_linux.go":  0,
+		"example.com/mod_test.init@a_test.go": 0,
+	}, ordinals)
+
+	// Adding an init function to another file doesn't change IDs.
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "0.go"), []byte("package mod\n\nfunc init() {}\n"), 0644))
+	fmapWithInit, err := ParseFunctions(dir, "example.com/mod")
+	assert.Nil(t, err)
+	assert.Contains(t, fmapWithInit, "example.com/mod.init@0.go")
+	delete(fmapWithInit, "example.com/mod.init@0.go")
+	assert.Equal(t, fmap, fmapWithInit)
+}
+
+func TestByLocation(t *testing.T) {
+	fi := FunctionInfo{ID: "example.com/mod.Get", Filename: "api.go", LineNumber: "12", Function: "Get"}
+	fmap := FunctionInfoMap{fi.ID: fi}
+	assert.Equal(t, map[string]FunctionInfo{FunctionLocationKey("api.go", "12", "Get"): fi}, fmap.ByLocation())
+	assert.Equal(t, 12, fi.Line())
+}
+
+func TestReceiverTypeName(t *testing.T) {
+	table := []struct {
+		receiver string
+		expected string
+	}{
+		{receiver: "T", expected: "T"},
+		{receiver: "*T", expected: "T"},
+		{receiver: "(*T)", expected: "T"},
+		{receiver: "T[K]", expected: "T"},
+		{receiver: "*T[K, V]", expected: "T"},
+	}
+	for _, test := range table {
+		expr, err := parser.ParseExpr(test.receiver)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, receiverTypeName(expr), test.receiver)
+	}
+	assert.Equal(t, "", receiverTypeName(&ast.ArrayType{}))
+}
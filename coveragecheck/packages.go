@@ -0,0 +1,173 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listPackages returns the import path of every package in the module, using
+// `go list`.
+func listPackages(options Options) ([]string, error) {
+	lines, err := options.CaptureOutput("go", "list", options.ModulePath+"...")
+	if err != nil {
+		return nil, err
+	}
+	packages := []string{}
+	for _, line := range lines {
+		if line != "" {
+			packages = append(packages, line)
+		}
+	}
+	return packages, nil
+}
+
+// testPackagesInParallel runs the tests in every package in the module with a
+// separate `go test` for each package, at most Options.Jobs at once, measuring
+// coverage of the package being checked.  The coverage profiles are merged and
+// written to profilePath.  The output of each package is written to
+// Options.TestOutput, and failures are reported, in the order the packages are
+// listed, regardless of the order the tests finish in.  With Options.FailFast,
+// the first failure cancels the packages that are being tested and skips the
+// packages that haven't started.
+func testPackagesInParallel(options Options, profilePath string) error {
+	packages, err := listPackages(options)
+	if err != nil {
+		return fmt.Errorf("failed listing packages: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outputs := make([][]string, len(packages))
+	profiles := make([][]string, len(packages))
+	errs := make([]error, len(packages))
+	cancelled := make([]bool, len(packages))
+	finished := make([]bool, len(packages))
+	started, written := 0, 0
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan struct{}, options.Jobs)
+	for i, pkg := range packages {
+		jobs <- struct{}{}
+		if ctx.Err() != nil {
+			<-jobs
+			break
+		}
+		started++
+		wg.Add(1)
+		go func(i int, pkg string) {
+			defer wg.Done()
+			defer func() { <-jobs }()
+			output, profile, err := testPackage(ctx, options, pkg)
+			mutex.Lock()
+			defer mutex.Unlock()
+			outputs[i], profiles[i], errs[i], finished[i] = output, profile, err, true
+			if err != nil && ctx.Err() != nil {
+				// Killed because another package failed first.
+				cancelled[i] = true
+			} else if err != nil && options.FailFast {
+				cancel()
+			}
+			// Packages are started in order, so packages that were skipped
+			// are after every package that was started.
+			for written < len(packages) && finished[written] {
+				if options.TestOutput != nil && outputs[written] != nil {
+					fmt.Fprint(options.TestOutput, strings.Join(outputs[written], "\n"))
+				}
+				written++
+			}
+		}(i, pkg)
+	}
+	wg.Wait()
+
+	messages := []string{}
+	for i, err := range errs {
+		if cancelled[i] {
+			messages = append(messages, fmt.Sprintf("cancelled testing %v after the first failure", packages[i]))
+		} else if err != nil {
+			messages = append(messages, fmt.Sprintf("failed testing %v: %v", packages[i], err))
+		}
+	}
+	if started < len(packages) {
+		messages = append(messages, fmt.Sprintf("skipped testing %d packages after the first failure", len(packages)-started))
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "\n"))
+	}
+	merged := strings.Join(mergeProfiles(options.CoverMode, profiles), "\n") + "\n"
+	return os.WriteFile(profilePath, []byte(merged), 0644)
+}
+
+// testPackage runs the tests in pkg, measuring coverage of the package being
+// checked, and returns the output of `go test`, the coverage profile, and an
+// error.  The tests are killed if ctx is done.
+func testPackage(ctx context.Context, options Options, pkg string) ([]string, []string, error) {
+	file, err := options.CreateTemp("", "golang-coverage-check")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(file.Name())
+
+	output, err := options.CaptureOutputContext(ctx, "go", "test", "--covermode", options.CoverMode, "--coverpkg", ".", pkg, "--coverprofile", file.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	return output, strings.Split(string(profile), "\n"), nil
+}
+
+// mergeProfiles merges coverage profiles of the same package into a single
+// profile.  Blocks are identified by position and number of statements; with
+// --covermode=set a block is executed if any profile executed it, otherwise
+// the counts are added.  Blocks are output in the order they first appear.
+func mergeProfiles(coverMode string, profiles [][]string) []string {
+	blockParser := regexp.MustCompile(`^(.+) (\d+)$`)
+	blocks := []string{}
+	counts := map[string]int{}
+	for _, profile := range profiles {
+		for _, line := range profile {
+			matches := blockParser.FindStringSubmatch(line)
+			if len(matches) == 0 {
+				// Skip "mode:" lines and blank lines.
+				continue
+			}
+			// The regex only matches digits so conversion cannot fail.
+			count, _ := strconv.Atoi(matches[2])
+			previous, seen := counts[matches[1]]
+			if !seen {
+				blocks = append(blocks, matches[1])
+			}
+			if coverMode != "set" {
+				count += previous
+			} else if previous > count {
+				count = previous
+			}
+			counts[matches[1]] = count
+		}
+	}
+	merged := []string{"mode: " + coverMode}
+	for _, block := range blocks {
+		merged = append(merged, fmt.Sprintf("%s %d", block, counts[block]))
+	}
+	return merged
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// packagesOptions returns Options that list packages and test them, writing
+// profiles[pkg] to the coverage profile and outputting "ok  pkg" when testing
+// pkg, or returning an error if profiles[pkg] is empty.  Testing a package
+// takes delays[pkg], or 10ms if unset, unless it's cancelled.  It records the
+// packages tested and the maximum number tested at once.
+func packagesOptions(packages []string, profiles map[string]string, delays map[string]time.Duration) (Options, *[]string, *int) {
+	var mutex sync.Mutex
+	tested := []string{}
+	running, maxRunning := 0, 0
+	options := newTestOptions()
+	options.ModulePath = "example.com/mod/"
+	options.CaptureOutput = func(command string, args ...string) ([]string, error) {
+		return append(append([]string{}, packages...), ""), nil
+	}
+	options.CaptureOutputContext = func(ctx context.Context, command string, args ...string) ([]string, error) {
+		// The package is before --coverprofile and the profile is the last arg.
+		pkg := args[len(args)-3]
+		mutex.Lock()
+		tested = append(tested, pkg)
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+		delay, ok := delays[pkg]
+		if !ok {
+			delay = 10 * time.Millisecond
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if profiles[pkg] == "" {
+			return nil, errors.New("tests failed in " + pkg)
+		}
+		return []string{"ok  \t" + pkg, ""}, os.WriteFile(args[len(args)-1], []byte(profiles[pkg]), 0644)
+	}
+	return options, &tested, &maxRunning
+}
+
+func TestTestPackagesInParallel(t *testing.T) {
+	packages := []string{"example.com/mod", "example.com/mod/a", "example.com/mod/b", "example.com/mod/c"}
+	profiles := map[string]string{
+		"example.com/mod":   "mode: set\nexample.com/mod/api.go:10.20,14.2 2 1\nexample.com/mod/api.go:20.20,22.2 1 0\n",
+		"example.com/mod/a": "mode: set\nexample.com/mod/api.go:10.20,14.2 2 0\nexample.com/mod/api.go:20.20,22.2 1 1\n",
+		"example.com/mod/b": "mode: set\nexample.com/mod/api.go:10.20,14.2 2 0\nexample.com/mod/api.go:20.20,22.2 1 0\n",
+		"example.com/mod/c": "mode: set\n",
+	}
+	// Earlier packages take longer, so they finish in reverse order.
+	delays := map[string]time.Duration{
+		"example.com/mod":   80 * time.Millisecond,
+		"example.com/mod/a": 60 * time.Millisecond,
+		"example.com/mod/b": 40 * time.Millisecond,
+		"example.com/mod/c": 20 * time.Millisecond,
+	}
+	options, tested, maxRunning := packagesOptions(packages, profiles, delays)
+	options.Jobs = 2
+	output := new(bytes.Buffer)
+	options.TestOutput = output
+	profilePath := filepath.Join(t.TempDir(), "profile")
+	assert.Nil(t, testPackagesInParallel(options, profilePath))
+	profile, err := os.ReadFile(profilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "mode: set\nexample.com/mod/api.go:10.20,14.2 2 1\nexample.com/mod/api.go:20.20,22.2 1 1\n", string(profile))
+	assert.ElementsMatch(t, packages, *tested)
+	assert.Equal(t, 2, *maxRunning)
+	// Output is in the order the packages are listed.
+	assert.Equal(t, "ok  \texample.com/mod\nok  \texample.com/mod/a\nok  \texample.com/mod/b\nok  \texample.com/mod/c\n", output.String())
+}
+
+func TestTestPackagesInParallelFailures(t *testing.T) {
+	packages := []string{"example.com/mod", "example.com/mod/a", "example.com/mod/b", "example.com/mod/c"}
+	// Packages a and c fail.
+	profiles := map[string]string{
+		"example.com/mod":   "mode: set\n",
+		"example.com/mod/b": "mode: set\n",
+	}
+	profilePath := filepath.Join(t.TempDir(), "profile")
+
+	// Every failure is reported, in the order the packages are listed.
+	options, tested, _ := packagesOptions(packages, profiles, nil)
+	options.Jobs = 4
+	err := testPackagesInParallel(options, profilePath)
+	assert.EqualError(t, err, "failed testing example.com/mod/a: tests failed in example.com/mod/a\n"+
+		"failed testing example.com/mod/c: tests failed in example.com/mod/c")
+	assert.Equal(t, 4, len(*tested))
+
+	// Packages after the first failure aren't started with FailFast.
+	options, tested, _ = packagesOptions(packages, profiles, nil)
+	options.Jobs = 1
+	options.FailFast = true
+	err = testPackagesInParallel(options, profilePath)
+	assert.EqualError(t, err, "failed testing example.com/mod/a: tests failed in example.com/mod/a\n"+
+		"skipped testing 2 packages after the first failure")
+	assert.Equal(t, []string{"example.com/mod", "example.com/mod/a"}, *tested)
+}
+
+func TestTestPackagesInParallelFailFastCancels(t *testing.T) {
+	packages := []string{"example.com/mod", "example.com/mod/a"}
+	// The first package would take far longer than the test if it wasn't
+	// cancelled when the second package fails.
+	delays := map[string]time.Duration{"example.com/mod": time.Hour}
+	options, tested, _ := packagesOptions(packages, map[string]string{"example.com/mod": "mode: set\n"}, delays)
+	options.Jobs = 2
+	options.FailFast = true
+	err := testPackagesInParallel(options, filepath.Join(t.TempDir(), "profile"))
+	assert.EqualError(t, err, "cancelled testing example.com/mod after the first failure\n"+
+		"failed testing example.com/mod/a: tests failed in example.com/mod/a")
+	assert.ElementsMatch(t, packages, *tested)
+}
+
+func TestTestPackagesInParallelErrors(t *testing.T) {
+	table := []struct {
+		desc        string
+		err         string
+		profilePath string
+		mod         func(Options) Options
+	}{
+		{
+			desc: "listing packages fails",
+			err:  "failed listing packages: error for testing",
+			mod: func(opts Options) Options {
+				opts.CaptureOutput = func(string, ...string) ([]string, error) {
+					return nil, errors.New("error for testing")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "creating the profile fails",
+			err:  "failed testing example.com/mod: error for testing",
+			mod: func(opts Options) Options {
+				opts.CreateTemp = func(string, string) (*os.File, error) {
+					return nil, errors.New("error for testing")
+				}
+				return opts
+			},
+		},
+		{
+			desc: "reading the profile fails",
+			err:  "failed testing example.com/mod: ",
+			mod: func(opts Options) Options {
+				opts.CaptureOutputContext = func(ctx context.Context, command string, args ...string) ([]string, error) {
+					return nil, os.Remove(args[len(args)-1])
+				}
+				return opts
+			},
+		},
+		{
+			desc:        "writing the merged profile fails",
+			err:         "no such file or directory",
+			profilePath: filepath.Join(t.TempDir(), "does-not-exist", "profile"),
+			mod:         func(opts Options) Options { return opts },
+		},
+	}
+	for _, test := range table {
+		options, _, _ := packagesOptions([]string{"example.com/mod"}, map[string]string{"example.com/mod": "mode: set\n"}, nil)
+		options.Jobs = 1
+		profilePath := test.profilePath
+		if profilePath == "" {
+			profilePath = filepath.Join(t.TempDir(), "profile")
+		}
+		err := testPackagesInParallel(test.mod(options), profilePath)
+		assert.ErrorContains(t, err, test.err, test.desc)
+	}
+}
+
+func TestMergeProfiles(t *testing.T) {
+	profiles := [][]string{
+		{"mode: count", "api.go:10.20,14.2 2 3", "api.go:20.20,22.2 1 0", ""},
+		{"mode: count", "api.go:20.20,22.2 1 2", "api.go:10.20,14.2 2 1", "api.go:30.20,32.2 1 0", ""},
+	}
+	assert.Equal(t, []string{"mode: count", "api.go:10.20,14.2 2 4", "api.go:20.20,22.2 1 2", "api.go:30.20,32.2 1 0"},
+		mergeProfiles("count", profiles))
+	profiles = [][]string{
+		{"mode: set", "api.go:10.20,14.2 2 1", "api.go:20.20,22.2 1 0", ""},
+		{"mode: set", "api.go:20.20,22.2 1 1", "api.go:10.20,14.2 2 1", "api.go:30.20,32.2 1 0", ""},
+	}
+	assert.Equal(t, []string{"mode: set", "api.go:10.20,14.2 2 1", "api.go:20.20,22.2 1 1", "api.go:30.20,32.2 1 0"},
+		mergeProfiles("set", profiles))
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProfileBlock represents a single block of code from the coverage profile
+// written by `go test --coverprofile`.
+type ProfileBlock struct {
+	// Filename is the name of the source file, with the module path removed.
+	Filename string
+	// StartLine and StartColumn are the position of the start of the block.
+	StartLine   int
+	StartColumn int
+	// EndLine and EndColumn are the position of the end of the block.
+	EndLine   int
+	EndColumn int
+	// NumStatements is the number of statements in the block.
+	NumStatements int
+	// Count is the number of times the block was executed; with
+	// --covermode=set it is either 0 or 1.
+	Count int
+}
+
+// Contains reports whether the block is entirely inside the function.
+func (block ProfileBlock) Contains(fi FunctionInfo) bool {
+	if block.Filename != fi.Filename {
+		return false
+	}
+	start := fi.Line()
+	if block.StartLine < start || (block.StartLine == start && block.StartColumn < fi.StartColumn) {
+		return false
+	}
+	if block.EndLine > fi.EndLine || (block.EndLine == fi.EndLine && block.EndColumn > fi.EndColumn) {
+		return false
+	}
+	return true
+}
+
+// ParseProfile parses the contents of a coverage profile and turns each line
+// into a ProfileBlock, returning a slice of ProfileBlock and an error.
+func ParseProfile(modulePath string, profile []string) ([]ProfileBlock, error) {
+	blocks := []ProfileBlock{}
+	blockParser := regexp.MustCompile(`^(.+):(\d+)\.(\d+),(\d+)\.(\d+) (\d+) (\d+)$`)
+
+	for _, line := range profile {
+		if len(line) == 0 || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		matches := blockParser.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("could not parse coverage profile line \"%v\"", line)
+		}
+		// The regex only matches digits so conversion cannot fail.
+		numbers := []int{}
+		for _, match := range matches[2:] {
+			number, _ := strconv.Atoi(match)
+			numbers = append(numbers, number)
+		}
+		blocks = append(blocks, ProfileBlock{
+			Filename:      strings.TrimPrefix(matches[1], modulePath),
+			StartLine:     numbers[0],
+			StartColumn:   numbers[1],
+			EndLine:       numbers[2],
+			EndColumn:     numbers[3],
+			NumStatements: numbers[4],
+			Count:         numbers[5],
+		})
+	}
+	return blocks, nil
+}
+
+// ClosureCoverage calculates coverage for every closure in fInfoMap from the
+// profile blocks inside the closure, because `go tool cover --func` includes
+// closures in the coverage of their enclosing function.  Closures in files
+// without any blocks are skipped, because `go test` doesn't instrument test
+// files.  Returns a slice of CoverageLine sorted by filename and line number.
+func ClosureCoverage(blocks []ProfileBlock, fInfoMap FunctionInfoMap) []CoverageLine {
+	instrumented := map[string]bool{}
+	for _, block := range blocks {
+		instrumented[block.Filename] = true
+	}
+	closures := []FunctionInfo{}
+	for _, fi := range fInfoMap {
+		if fi.Closure && instrumented[fi.Filename] {
+			closures = append(closures, fi)
+		}
+	}
+	sortFunctions(closures)
+
+	results := []CoverageLine{}
+	for _, fi := range closures {
+		inside := []ProfileBlock{}
+		for _, block := range blocks {
+			if block.Contains(fi) {
+				inside = append(inside, block)
+			}
+		}
+		_, _, percentage := StatementCoverage(inside)
+		results = append(results, CoverageLine{
+			Filename:   fi.Filename,
+			LineNumber: fi.LineNumber,
+			Function:   fi.Function,
+			Coverage:   percentage,
+		})
+	}
+	return results
+}
+
+// lineRange is an inclusive range of line numbers.
+type lineRange struct {
+	start int
+	end   int
+}
+
+func (lr lineRange) String() string {
+	if lr.start == lr.end {
+		return strconv.Itoa(lr.start)
+	}
+	return fmt.Sprintf("%d-%d", lr.start, lr.end)
+}
+
+// uncoveredLines finds the blocks inside the function that were never
+// executed and merges them into line ranges, returning a string like
+// "foo.go:120-128, 140", or an empty string if every block was executed.
+func uncoveredLines(blocks []ProfileBlock, fi FunctionInfo) string {
+	ranges := []lineRange{}
+	for _, block := range blocks {
+		if block.Count == 0 && block.Contains(fi) {
+			ranges = append(ranges, lineRange{start: block.StartLine, end: block.EndLine})
+		}
+	}
+	if len(ranges) == 0 {
+		return ""
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	merged := []string{}
+	current := ranges[0]
+	for _, lr := range ranges[1:] {
+		if lr.start <= current.end+1 {
+			if lr.end > current.end {
+				current.end = lr.end
+			}
+			continue
+		}
+		merged = append(merged, current.String())
+		current = lr
+	}
+	merged = append(merged, current.String())
+	return fi.Filename + ":" + strings.Join(merged, ", ")
+}
+
+// StatementCoverage counts the statements in blocks and how many were
+// executed, returning the total, the number executed, and the percentage
+// executed.
+func StatementCoverage(blocks []ProfileBlock) (int, int, float64) {
+	total, covered := 0, 0
+	for _, block := range blocks {
+		total += block.NumStatements
+		if block.Count > 0 {
+			covered += block.NumStatements
+		}
+	}
+	if total == 0 {
+		return 0, 0, 0
+	}
+	return total, covered, 100 * float64(covered) / float64(total)
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coveragecheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validProfile() []string {
+	profile := `mode: set
+github.com/tobinjt/golang-coverage-check/handlers.go:10.30,12.20 2 1
+github.com/tobinjt/golang-coverage-check/handlers.go:12.20,15.4 3 0
+github.com/tobinjt/golang-coverage-check/handlers.go:15.4,16.3 1 1
+github.com/tobinjt/golang-coverage-check/handlers.go:17.2,17.10 1 1
+github.com/tobinjt/golang-coverage-check/handlers.go:20.25,22.2 4 1
+`
+	return strings.Split(profile, "\n")
+}
+
+func TestParseProfileSuccess(t *testing.T) {
+	blocks, err := ParseProfile("github.com/tobinjt/golang-coverage-check/", validProfile())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(blocks))
+	assert.Equal(t, ProfileBlock{
+		Filename:      "handlers.go",
+		StartLine:     12,
+		StartColumn:   20,
+		EndLine:       15,
+		EndColumn:     4,
+		NumStatements: 3,
+		Count:         0,
+	}, blocks[1])
+}
+
+func TestParseProfileFailure(t *testing.T) {
+	for _, input := range []string{
+		"asdf",
+		"handlers.go:10.30,12.20 2",
+		"handlers.go:10,12.20 2 1",
+		"handlers.go:10.30,12.20 2 -1",
+	} {
+		_, err := ParseProfile("", []string{"mode: set", input})
+		assert.ErrorContains(t, err, "could not parse coverage profile line \""+input+"\"")
+	}
+}
+
+func TestProfileBlockContains(t *testing.T) {
+	fi := FunctionInfo{
+		Filename:    "handlers.go",
+		LineNumber:  "12",
+		StartColumn: 20,
+		EndLine:     16,
+		EndColumn:   3,
+	}
+	table := []struct {
+		desc     string
+		block    ProfileBlock
+		contains bool
+	}{
+		{
+			desc:     "exactly the same extent",
+			block:    ProfileBlock{Filename: "handlers.go", StartLine: 12, StartColumn: 20, EndLine: 16, EndColumn: 3},
+			contains: true,
+		},
+		{
+			desc:     "different file",
+			block:    ProfileBlock{Filename: "main.go", StartLine: 12, StartColumn: 20, EndLine: 16, EndColumn: 3},
+			contains: false,
+		},
+		{
+			desc:     "starts on an earlier line",
+			block:    ProfileBlock{Filename: "handlers.go", StartLine: 11, StartColumn: 20, EndLine: 16, EndColumn: 3},
+			contains: false,
+		},
+		{
+			desc:     "starts in an earlier column",
+			block:    ProfileBlock{Filename: "handlers.go", StartLine: 12, StartColumn: 19, EndLine: 16, EndColumn: 3},
+			contains: false,
+		},
+		{
+			desc:     "ends on a later line",
+			block:    ProfileBlock{Filename: "handlers.go", StartLine: 12, StartColumn: 20, EndLine: 17, EndColumn: 3},
+			contains: false,
+		},
+		{
+			desc:     "ends in a later column",
+			block:    ProfileBlock{Filename: "handlers.go", StartLine: 12, StartColumn: 20, EndLine: 16, EndColumn: 4},
+			contains: false,
+		},
+	}
+	for _, test := range table {
+		assert.Equal(t, test.contains, test.block.Contains(fi), test.desc)
+	}
+}
+
+func TestClosureCoverage(t *testing.T) {
+	blocks, err := ParseProfile("github.com/tobinjt/golang-coverage-check/", validProfile())
+	assert.Nil(t, err)
+	blocks = append(blocks, ProfileBlock{
+		Filename:      "aaa.go",
+		StartLine:     21,
+		StartColumn:   2,
+		EndLine:       21,
+		EndColumn:     10,
+		NumStatements: 1,
+		Count:         1,
+	})
+
+	fInfoMap := FunctionInfoMap{}
+	for _, fi := range []FunctionInfo{
+		{
+			Filename:    "handlers.go",
+			LineNumber:  "10",
+			Function:    "serve",
+			StartColumn: 1,
+			EndLine:     18,
+			EndColumn:   2,
+		},
+		{
+			Filename:    "handlers.go",
+			LineNumber:  "12",
+			Function:    "serve.func1",
+			StartColumn: 10,
+			EndLine:     16,
+			EndColumn:   3,
+			Closure:     true,
+		},
+		{
+			Filename:    "handlers.go",
+			LineNumber:  "13",
+			Function:    "serve.func1.1",
+			StartColumn: 5,
+			EndLine:     13,
+			EndColumn:   20,
+			Closure:     true,
+		},
+		{
+			Filename:    "handlers.go",
+			LineNumber:  "20",
+			Function:    "other.func1",
+			StartColumn: 20,
+			EndLine:     22,
+			EndColumn:   2,
+			Closure:     true,
+		},
+		{
+			Filename:    "aaa.go",
+			LineNumber:  "20",
+			Function:    "other.func1",
+			StartColumn: 20,
+			EndLine:     22,
+			EndColumn:   2,
+			Closure:     true,
+		},
+		{
+			// Skipped because there are no blocks for this file.
+			Filename:    "aaa_test.go",
+			LineNumber:  "20",
+			Function:    "other.func1",
+			StartColumn: 20,
+			EndLine:     22,
+			EndColumn:   2,
+			Closure:     true,
+		},
+		{
+			Filename:    "handlers.go",
+			LineNumber:  "12",
+			Function:    "serve.func2",
+			StartColumn: 5,
+			EndLine:     12,
+			EndColumn:   8,
+			Closure:     true,
+		},
+	} {
+		fInfoMap[fi.LocationKey()] = fi
+	}
+
+	expected := []CoverageLine{
+		{
+			Filename:   "aaa.go",
+			LineNumber: "20",
+			Function:   "other.func1",
+			Coverage:   100.0,
+		},
+		{
+			// No blocks, so no coverage.
+			Filename:   "handlers.go",
+			LineNumber: "12",
+			Function:   "serve.func2",
+			Coverage:   0.0,
+		},
+		{
+			Filename:   "handlers.go",
+			LineNumber: "12",
+			Function:   "serve.func1",
+			Coverage:   25.0,
+		},
+		{
+			// No blocks, so no coverage.
+			Filename:   "handlers.go",
+			LineNumber: "13",
+			Function:   "serve.func1.1",
+			Coverage:   0.0,
+		},
+		{
+			Filename:   "handlers.go",
+			LineNumber: "20",
+			Function:   "other.func1",
+			Coverage:   100.0,
+		},
+	}
+	assert.Equal(t, expected, ClosureCoverage(blocks, fInfoMap))
+}
+
+func TestUncoveredLines(t *testing.T) {
+	fi := FunctionInfo{
+		Filename:    "handlers.go",
+		LineNumber:  "100",
+		StartColumn: 1,
+		EndLine:     200,
+		EndColumn:   2,
+	}
+	block := func(start, end, count int) ProfileBlock {
+		return ProfileBlock{
+			Filename:    "handlers.go",
+			StartLine:   start,
+			StartColumn: 2,
+			EndLine:     end,
+			EndColumn:   3,
+			Count:       count,
+		}
+	}
+	table := []struct {
+		desc     string
+		blocks   []ProfileBlock
+		expected string
+	}{
+		{
+			desc:     "no blocks",
+			blocks:   nil,
+			expected: "",
+		},
+		{
+			desc:     "every block executed",
+			blocks:   []ProfileBlock{block(100, 110, 1), block(111, 120, 1)},
+			expected: "",
+		},
+		{
+			desc:     "single line",
+			blocks:   []ProfileBlock{block(100, 110, 1), block(140, 140, 0)},
+			expected: "handlers.go:140",
+		},
+		{
+			desc: "overlapping, adjacent, and separate blocks are merged and sorted",
+			blocks: []ProfileBlock{
+				block(140, 140, 0),
+				block(125, 128, 0),
+				block(120, 126, 0),
+				block(121, 122, 0),
+				block(129, 130, 1),
+				block(150, 151, 0),
+				block(152, 155, 0),
+			},
+			expected: "handlers.go:120-128, 140, 150-155",
+		},
+		{
+			desc:     "blocks outside the function are ignored",
+			blocks:   []ProfileBlock{block(90, 95, 0), block(201, 210, 0)},
+			expected: "",
+		},
+	}
+	for _, test := range table {
+		assert.Equal(t, test.expected, uncoveredLines(test.blocks, fi), test.desc)
+	}
+}
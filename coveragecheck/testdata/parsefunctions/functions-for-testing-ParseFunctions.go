@@ -0,0 +1,56 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// These functions are parsed by the tests for ParseFunctions() and Check().
+// This isn't compiled, so they don't become part of the package's API.
+
+package coveragecheck
+
+func functionAtLine20() string {
+	return "This function is at line 20 to test ParseFunctions()"
+}
+
+type methodReceiver struct{}
+
+func (mr methodReceiver) String() string {
+	return "This method has a methodReceiver receiver to test ParseFunctions()"
+}
+
+func functionWithClosures() func() string {
+	inner := func() string {
+		nested := func() string { return "nested closure" }
+		return nested()
+	}
+	return func() string {
+		return "closure calling " + inner()
+	}
+}
+
+func (mr methodReceiver) closureInMethod() string {
+	return func() string { return "closure in method" }()
+}
+
+type ExportedReceiver struct{}
+
+func (er ExportedReceiver) ExportedMethod(a, b int, _ string, c ...string) (string, error) {
+	return "This method is exported to test ParseFunctions()", nil
+}
+
+func (mr methodReceiver) UnexportedTypeMethod(int) error {
+	return nil
+}
+
+func (er *ExportedReceiver) PointerMethod() string {
+	return "This method has a pointer receiver to test ParseFunctions()"
+}
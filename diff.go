@@ -0,0 +1,136 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+	"golang.org/x/mod/modfile"
+)
+
+// diffCommand is the argument that compares coverage at two git revisions
+// instead of checking coverage.
+const diffCommand = "diff"
+
+// coverageAtRevision checks out revision in a temporary git worktree at
+// worktree, runs the tests for the package that's in the current directory,
+// and returns a historyEntry summarising the coverage.  The config isn't
+// used because only coverage is needed.
+func coverageAtRevision(options Options, worktree, prefix, revision string) (historyEntry, error) {
+	if _, err := options.captureOutput("git", "worktree", "add", "--detach", worktree, revision); err != nil {
+		return historyEntry{}, fmt.Errorf("failed checking out %v: %w", revision, err)
+	}
+	// Failing to remove the worktree is harmless because the directory is
+	// removed by diffRevisions and `git worktree prune` cleans up.
+	defer options.captureOutput("git", "worktree", "remove", "--force", worktree)
+
+	dir := filepath.Join(worktree, prefix)
+	modBytes, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return historyEntry{}, fmt.Errorf("failed reading go.mod at %v: %w", revision, err)
+	}
+	modulePath := modfile.ModulePath(modBytes) + "/"
+	checkOptions := coveragecheck.NewOptions()
+	checkOptions.CaptureOutput = options.captureOutputInDir(dir)
+	checkOptions.CreateTemp = options.createTemp
+	checkOptions.ConfigFile = os.DevNull
+	checkOptions.Dir = dir
+	checkOptions.ModulePath = modulePath
+	run, err := coveragecheck.Check(checkOptions)
+	if err != nil {
+		return historyEntry{}, fmt.Errorf("failed checking coverage at %v: %w", revision, err)
+	}
+	return makeHistoryEntry(modulePath, revision, options.now(), run), nil
+}
+
+// describeDiff describes the differences in coverage between from and to:
+// the change in total coverage, every function whose coverage changed, and
+// the functions that were added or removed, with their coverage.
+func describeDiff(from, to historyEntry) []string {
+	lines := []string{
+		fmt.Sprintf("Comparing coverage at %s with %s:", from.Commit, to.Commit),
+		coverageChange{name: "Total coverage", from: from.Coverage, to: to.Coverage}.String(),
+		"Changed functions:",
+	}
+	changes := coverageChanges(from.Functions, to.Functions)
+	for _, change := range changes {
+		lines = append(lines, "  "+change.String())
+	}
+	if len(changes) == 0 {
+		lines = append(lines, "  none")
+	}
+	lines = append(lines, "New functions:")
+	lines = append(lines, onlyIn(to.Functions, from.Functions)...)
+	lines = append(lines, "Removed functions:")
+	lines = append(lines, onlyIn(from.Functions, to.Functions)...)
+	return lines
+}
+
+// onlyIn describes the functions in functions that aren't in other, with
+// their coverage, sorted by name.
+func onlyIn(functions, other map[string]float64) []string {
+	names := []string{}
+	for name := range functions {
+		if _, ok := other[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %s: %.1f%%", name, functions[name]))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "  none")
+	}
+	return lines
+}
+
+// diffRevisions implements the diff command: it checks coverage at the first
+// revision and the second revision, which defaults to HEAD, using temporary git
+// worktrees so that the current checkout isn't changed, and describes the
+// differences.
+func diffRevisions(options Options) ([]string, error) {
+	revisions := options.parsedArgs[1:]
+	if len(revisions) == 1 {
+		revisions = append(revisions, "HEAD")
+	}
+	// The package being checked might not be at the top of the repository.
+	output, err := options.captureOutput("git", "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("failed finding the current directory in the git repository: %w", err)
+	}
+	prefix := strings.TrimSpace(strings.Join(output, ""))
+
+	dir, err := options.mkdirTemp("", "golang-coverage-check-diff")
+	if err != nil {
+		return nil, fmt.Errorf("failed creating directory for git worktrees: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	entries := []historyEntry{}
+	for i, revision := range revisions {
+		entry, err := coverageAtRevision(options, filepath.Join(dir, fmt.Sprintf("worktree%d", i)), prefix, revision)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return describeDiff(entries[0], entries[1]), nil
+}
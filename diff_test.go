@@ -0,0 +1,161 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeDiff(t *testing.T) {
+	from := historyEntry{
+		Commit:    "main",
+		Coverage:  50,
+		Functions: map[string]float64{"api.go:Get": 100, "api.go:Put": 50, "api.go:Old": 10, "api.go:Same": 20},
+	}
+	to := historyEntry{
+		Commit:    "HEAD",
+		Coverage:  60,
+		Functions: map[string]float64{"api.go:Get": 80, "api.go:Put": 75, "api.go:New": 0, "api.go:Same": 20},
+	}
+	assert.Equal(t, []string{
+		"Comparing coverage at main with HEAD:",
+		"Total coverage: 50.0% -> 60.0% (+10.0%)",
+		"Changed functions:",
+		"  api.go:Get: 100.0% -> 80.0% (-20.0%)",
+		"  api.go:Put: 50.0% -> 75.0% (+25.0%)",
+		"New functions:",
+		"  api.go:New: 0.0%",
+		"Removed functions:",
+		"  api.go:Old: 10.0%",
+	}, describeDiff(from, to))
+	assert.Equal(t, []string{
+		"Comparing coverage at HEAD with HEAD:",
+		"Total coverage: 60.0% -> 60.0% (+0.0%)",
+		"Changed functions:",
+		"  none",
+		"New functions:",
+		"  none",
+		"Removed functions:",
+		"  none",
+	}, describeDiff(to, to))
+}
+
+// diffTestOptions returns Options for the diff command where `git worktree add`
+// creates a package with a function for each revision, and its tests report
+// coverage from coverage.
+func diffTestOptions(t *testing.T, coverage map[string]string) Options {
+	options := newTestOptions()
+	tempDir := t.TempDir()
+	options.mkdirTemp = func(string, string) (string, error) {
+		return tempDir, nil
+	}
+	revisions := map[string]string{}
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		if command != "git" {
+			return nil, fmt.Errorf("unexpected command %v %v", command, args)
+		}
+		switch args[0] {
+		case "rev-parse":
+			return []string{"sub/", ""}, nil
+		case "worktree":
+			if args[1] == "add" {
+				revision := args[len(args)-1]
+				dir := filepath.Join(args[len(args)-2], "sub")
+				revisions[dir] = revision
+				writeTestFile(t, filepath.Join(dir, "go.mod"), "module example.com/mod\n")
+				writeTestFile(t, filepath.Join(dir, "api.go"), "package mod\n\nfunc "+revision+"() {}\n")
+			}
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected command %v %v", command, args)
+	}
+	options.captureOutputInDir = func(dir string) func(string, ...string) ([]string, error) {
+		return func(command string, args ...string) ([]string, error) {
+			if args[0] == "test" {
+				return nil, os.WriteFile(args[len(args)-1], []byte("mode: set\n"), 0644)
+			}
+			return []string{coverage[revisions[dir]], "total:\t(statements)\t0.0%"}, nil
+		}
+	}
+	return options
+}
+
+func TestDiffRevisions(t *testing.T) {
+	options := diffTestOptions(t, map[string]string{
+		"main": "example.com/mod/api.go:3:\tmain\t50.0%",
+		"HEAD": "example.com/mod/api.go:3:\tHEAD\t75.0%",
+	})
+	options.rawArgs = []string{diffCommand, "main"}
+	stdout, stderr, err := realMain(options)
+	assert.Nil(t, err)
+	assert.Empty(t, stderr)
+	assert.Equal(t, []string{
+		"Comparing coverage at main with HEAD:",
+		"Total coverage: 0.0% -> 0.0% (+0.0%)",
+		"Changed functions:",
+		"  none",
+		"New functions:",
+		"  example.com/mod.HEAD: 75.0%",
+		"Removed functions:",
+		"  example.com/mod.main: 50.0%",
+	}, stdout)
+}
+
+func TestDiffRevisionsFailures(t *testing.T) {
+	options := diffTestOptions(t, map[string]string{"main": "not coverage"})
+	options.parsedArgs = []string{diffCommand, "main", "HEAD"}
+	_, err := diffRevisions(options)
+	assert.ErrorContains(t, err, "failed checking coverage at main: expected 3 parts, found 1")
+
+	options = diffTestOptions(t, nil)
+	options.parsedArgs = []string{diffCommand, "main", "HEAD"}
+	captureOutput := options.captureOutput
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "worktree" && args[1] == "add" {
+			// Create the worktree without go.mod.
+			return nil, os.MkdirAll(args[len(args)-2], 0755)
+		}
+		return captureOutput(command, args...)
+	}
+	_, err = diffRevisions(options)
+	assert.ErrorContains(t, err, "failed reading go.mod at main: ")
+
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "worktree" {
+			return nil, errors.New("unknown revision")
+		}
+		return captureOutput(command, args...)
+	}
+	_, err = diffRevisions(options)
+	assert.EqualError(t, err, "failed checking out main: unknown revision")
+
+	options.mkdirTemp = func(string, string) (string, error) {
+		return "", errors.New("mkdirTemp failed")
+	}
+	_, err = diffRevisions(options)
+	assert.EqualError(t, err, "failed creating directory for git worktrees: mkdirTemp failed")
+
+	options.captureOutput = func(string, ...string) ([]string, error) {
+		return nil, errors.New("not a git repository")
+	}
+	_, err = diffRevisions(options)
+	assert.EqualError(t, err, "failed finding the current directory in the git repository: not a git repository")
+}
@@ -0,0 +1,316 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// fileCoverage is the line and function coverage of a single source file,
+// collected for --cobertura and --lcov.
+type fileCoverage struct {
+	// Filename is the name of the source file, with the module path removed.
+	Filename string
+	// Lines maps line numbers to the number of times they were executed.
+	Lines map[int]int
+	// Functions is sorted by line number.
+	Functions []functionCoverage
+}
+
+// functionCoverage is the coverage of a single function, collected for
+// --cobertura and --lcov.
+type functionCoverage struct {
+	Name string
+	// StartLine and EndLine are the first and last lines of the function.
+	StartLine int
+	EndLine   int
+	// Hits is the number of times the function was called, i.e. the count of
+	// its first block.
+	Hits       int
+	Complexity int
+}
+
+// sortedLines returns the line numbers in Lines between start and end
+// inclusive, sorted.
+func (fc fileCoverage) sortedLines(start, end int) []int {
+	lines := []int{}
+	for line := range fc.Lines {
+		if line >= start && line <= end {
+			lines = append(lines, line)
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// countLines returns the number of lines between start and end inclusive, and
+// how many of them were executed.
+func (fc fileCoverage) countLines(start, end int) (int, int) {
+	total, hit := 0, 0
+	for _, line := range fc.sortedLines(start, end) {
+		total++
+		if fc.Lines[line] > 0 {
+			hit++
+		}
+	}
+	return total, hit
+}
+
+// collectCoverage combines the profile blocks, the coverage of each function,
+// and fInfoMap into the coverage of each file, sorted by filename.  A line's
+// count is the highest count of the blocks that span it.
+func collectCoverage(coverage []coveragecheck.CoverageLine, fInfoMap coveragecheck.FunctionInfoMap, blocks []coveragecheck.ProfileBlock) []fileCoverage {
+	files := map[string]*fileCoverage{}
+	getFile := func(filename string) *fileCoverage {
+		fc, ok := files[filename]
+		if !ok {
+			fc = &fileCoverage{Filename: filename, Lines: map[int]int{}}
+			files[filename] = fc
+		}
+		return fc
+	}
+
+	for _, block := range blocks {
+		fc := getFile(block.Filename)
+		for line := block.StartLine; line <= block.EndLine; line++ {
+			if count, ok := fc.Lines[line]; !ok || block.Count > count {
+				fc.Lines[line] = block.Count
+			}
+		}
+	}
+
+	locations := fInfoMap.ByLocation()
+	for _, cov := range coverage {
+		line := cov.Line()
+		fi := locations[coveragecheck.FunctionLocationKey(cov.Filename, cov.LineNumber, cov.Function)]
+		function := functionCoverage{
+			Name:       cov.Function,
+			StartLine:  line,
+			EndLine:    fi.EndLine,
+			Complexity: fi.Complexity,
+		}
+		if function.EndLine < line {
+			function.EndLine = line
+		}
+		var first *coveragecheck.ProfileBlock
+		for i, block := range blocks {
+			if block.Contains(fi) && (first == nil || block.StartLine < first.StartLine ||
+				(block.StartLine == first.StartLine && block.StartColumn < first.StartColumn)) {
+				first = &blocks[i]
+			}
+		}
+		if first != nil {
+			function.Hits = first.Count
+		}
+		fc := getFile(cov.Filename)
+		fc.Functions = append(fc.Functions, function)
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := []fileCoverage{}
+	for _, name := range names {
+		fc := files[name]
+		sort.SliceStable(fc.Functions, func(i, j int) bool {
+			return fc.Functions[i].StartLine < fc.Functions[j].StartLine
+		})
+		results = append(results, *fc)
+	}
+	return results
+}
+
+// makeLCOV creates the LCOV tracefile written by --lcov; see
+// https://manpages.debian.org/stretch/lcov/geninfo.1.en.html#FILES.
+func makeLCOV(files []fileCoverage) string {
+	lines := []string{}
+	for _, fc := range files {
+		lines = append(lines, "TN:", "SF:"+fc.Filename)
+		functionsHit := 0
+		for _, function := range fc.Functions {
+			lines = append(lines, fmt.Sprintf("FN:%d,%s", function.StartLine, function.Name))
+		}
+		for _, function := range fc.Functions {
+			lines = append(lines, fmt.Sprintf("FNDA:%d,%s", function.Hits, function.Name))
+			if function.Hits > 0 {
+				functionsHit++
+			}
+		}
+		lines = append(lines, fmt.Sprintf("FNF:%d", len(fc.Functions)), fmt.Sprintf("FNH:%d", functionsHit))
+		for _, line := range fc.sortedLines(0, math.MaxInt) {
+			lines = append(lines, fmt.Sprintf("DA:%d,%d", line, fc.Lines[line]))
+		}
+		total, hit := fc.countLines(0, math.MaxInt)
+		lines = append(lines, fmt.Sprintf("LF:%d", total), fmt.Sprintf("LH:%d", hit), "end_of_record")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// The types below are the subset of Cobertura XML written by --cobertura; see
+// https://github.com/cobertura/web/blob/master/htdocs/xml/coverage-04.dtd.
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity int               `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// coberturaRate formats the fraction of lines that were executed.
+func coberturaRate(total, hit int) string {
+	if total == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(hit)/float64(total), 'f', -1, 64)
+}
+
+// coberturaLines returns the lines of fc between start and end inclusive.
+func coberturaLines(fc fileCoverage, start, end int) []coberturaLine {
+	lines := []coberturaLine{}
+	for _, line := range fc.sortedLines(start, end) {
+		lines = append(lines, coberturaLine{Number: line, Hits: fc.Lines[line]})
+	}
+	return lines
+}
+
+// makeCobertura creates the Cobertura XML report written by --cobertura, with
+// a package for each Go package and a class for each file.  Go coverage
+// profiles don't record branches, so branch rates are always zero.
+func makeCobertura(modulePath string, files []fileCoverage) string {
+	report := coberturaCoverage{
+		BranchRate: "0",
+		Sources:    []string{"."},
+	}
+	packages := map[string]*coberturaPackage{}
+	packageTotals := map[string][]int{}
+	for _, fc := range files {
+		name := packageName(modulePath, fc.Filename)
+		pkg, ok := packages[name]
+		if !ok {
+			pkg = &coberturaPackage{Name: name, BranchRate: "0"}
+			packages[name] = pkg
+			packageTotals[name] = []int{0, 0}
+		}
+		total, hit := fc.countLines(0, math.MaxInt)
+		class := coberturaClass{
+			Name:       fc.Filename,
+			Filename:   fc.Filename,
+			LineRate:   coberturaRate(total, hit),
+			BranchRate: "0",
+			Methods:    []coberturaMethod{},
+			Lines:      coberturaLines(fc, 0, math.MaxInt),
+		}
+		for _, function := range fc.Functions {
+			functionTotal, functionHit := fc.countLines(function.StartLine, function.EndLine)
+			class.Methods = append(class.Methods, coberturaMethod{
+				Name:       function.Name,
+				LineRate:   coberturaRate(functionTotal, functionHit),
+				BranchRate: "0",
+				Complexity: function.Complexity,
+				Lines:      coberturaLines(fc, function.StartLine, function.EndLine),
+			})
+			class.Complexity += function.Complexity
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		pkg.Complexity += class.Complexity
+		packageTotals[name][0] += total
+		packageTotals[name][1] += hit
+		report.Complexity += class.Complexity
+		report.LinesValid += total
+		report.LinesCovered += hit
+	}
+
+	names := []string{}
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pkg := packages[name]
+		pkg.LineRate = coberturaRate(packageTotals[name][0], packageTotals[name][1])
+		report.Packages = append(report.Packages, *pkg)
+	}
+	report.LineRate = coberturaRate(report.LinesValid, report.LinesCovered)
+	// Marshalling these types cannot fail.
+	bytes, _ := xml.MarshalIndent(report, "", "  ")
+	return xml.Header + string(bytes) + "\n"
+}
+
+// exportCoverage writes the reports requested by --cobertura and --lcov.
+func exportCoverage(options Options, coverage []coveragecheck.CoverageLine, fInfoMap coveragecheck.FunctionInfoMap, blocks []coveragecheck.ProfileBlock) error {
+	if options.coberturaPath == "" && options.lcovPath == "" {
+		return nil
+	}
+	files := collectCoverage(coverage, fInfoMap, blocks)
+	if options.coberturaPath != "" {
+		report := makeCobertura(options.modulePath, files)
+		if err := options.writeFile(options.coberturaPath, []byte(report), 0644); err != nil {
+			return fmt.Errorf("failed writing Cobertura report to %v: %w", options.coberturaPath, err)
+		}
+	}
+	if options.lcovPath != "" {
+		if err := options.writeFile(options.lcovPath, []byte(makeLCOV(files)), 0644); err != nil {
+			return fmt.Errorf("failed writing LCOV tracefile to %v: %w", options.lcovPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func exportTestInputs() ([]coveragecheck.CoverageLine, coveragecheck.FunctionInfoMap, []coveragecheck.ProfileBlock) {
+	coverage := []coveragecheck.CoverageLine{
+		{Filename: "sub/api.go", LineNumber: "20", Function: "Put", Coverage: 0},
+		{Filename: "sub/api.go", LineNumber: "10", Function: "Get", Coverage: 75},
+		// Not in fInfoMap and without blocks.
+		{Filename: "main.go", LineNumber: "3", Function: "main", Coverage: 0},
+	}
+	fInfoMap := coveragecheck.FunctionInfoMap{}
+	for _, fi := range []coveragecheck.FunctionInfo{
+		{Filename: "sub/api.go", LineNumber: "10", Function: "Get", StartColumn: 1, EndLine: 14, EndColumn: 2, Complexity: 2},
+		{Filename: "sub/api.go", LineNumber: "20", Function: "Put", StartColumn: 1, EndLine: 21, EndColumn: 2, Complexity: 1},
+	} {
+		fInfoMap[fi.LocationKey()] = fi
+	}
+	blocks := []coveragecheck.ProfileBlock{
+		{Filename: "sub/api.go", StartLine: 12, StartColumn: 2, EndLine: 13, EndColumn: 3, NumStatements: 1, Count: 0},
+		{Filename: "sub/api.go", StartLine: 10, StartColumn: 20, EndLine: 12, EndColumn: 2, NumStatements: 2, Count: 3},
+		{Filename: "sub/api.go", StartLine: 20, StartColumn: 20, EndLine: 21, EndColumn: 2, NumStatements: 1, Count: 0},
+	}
+	return coverage, fInfoMap, blocks
+}
+
+func TestCollectCoverage(t *testing.T) {
+	expected := []fileCoverage{
+		{
+			Filename:  "main.go",
+			Lines:     map[int]int{},
+			Functions: []functionCoverage{{Name: "main", StartLine: 3, EndLine: 3}},
+		},
+		{
+			Filename: "sub/api.go",
+			Lines:    map[int]int{10: 3, 11: 3, 12: 3, 13: 0, 20: 0, 21: 0},
+			Functions: []functionCoverage{
+				{Name: "Get", StartLine: 10, EndLine: 14, Hits: 3, Complexity: 2},
+				{Name: "Put", StartLine: 20, EndLine: 21, Hits: 0, Complexity: 1},
+			},
+		},
+	}
+	assert.Equal(t, expected, collectCoverage(exportTestInputs()))
+}
+
+func TestMakeLCOV(t *testing.T) {
+	expected := `TN:
+SF:main.go
+FN:3,main
+FNDA:0,main
+FNF:1
+FNH:0
+LF:0
+LH:0
+end_of_record
+TN:
+SF:sub/api.go
+FN:10,Get
+FN:20,Put
+FNDA:3,Get
+FNDA:0,Put
+FNF:2
+FNH:1
+DA:10,3
+DA:11,3
+DA:12,3
+DA:13,0
+DA:20,0
+DA:21,0
+LF:6
+LH:3
+end_of_record
+`
+	assert.Equal(t, expected, makeLCOV(collectCoverage(exportTestInputs())))
+}
+
+func TestMakeCobertura(t *testing.T) {
+	expected := strings.ReplaceAll(`<?xml version="1.0" encoding="UTF-8"?>
+<coverage line-rate="0.5" branch-rate="0" lines-covered="3" lines-valid="6" branches-covered="0" branches-valid="0" complexity="3">
+	<sources>
+		<source>.</source>
+	</sources>
+	<packages>
+		<package name="example.com/mod" line-rate="0" branch-rate="0" complexity="0">
+			<classes>
+				<class name="main.go" filename="main.go" line-rate="0" branch-rate="0" complexity="0">
+					<methods>
+						<method name="main" signature="" line-rate="0" branch-rate="0" complexity="0">
+							<lines></lines>
+						</method>
+					</methods>
+					<lines></lines>
+				</class>
+			</classes>
+		</package>
+		<package name="example.com/mod/sub" line-rate="0.5" branch-rate="0" complexity="3">
+			<classes>
+				<class name="sub/api.go" filename="sub/api.go" line-rate="0.5" branch-rate="0" complexity="3">
+					<methods>
+						<method name="Get" signature="" line-rate="0.75" branch-rate="0" complexity="2">
+							<lines>
+								<line number="10" hits="3"></line>
+								<line number="11" hits="3"></line>
+								<line number="12" hits="3"></line>
+								<line number="13" hits="0"></line>
+							</lines>
+						</method>
+						<method name="Put" signature="" line-rate="0" branch-rate="0" complexity="1">
+							<lines>
+								<line number="20" hits="0"></line>
+								<line number="21" hits="0"></line>
+							</lines>
+						</method>
+					</methods>
+					<lines>
+						<line number="10" hits="3"></line>
+						<line number="11" hits="3"></line>
+						<line number="12" hits="3"></line>
+						<line number="13" hits="0"></line>
+						<line number="20" hits="0"></line>
+						<line number="21" hits="0"></line>
+					</lines>
+				</class>
+			</classes>
+		</package>
+	</packages>
+</coverage>
+`, "\t", "  ")
+	assert.Equal(t, expected, makeCobertura("example.com/mod/", collectCoverage(exportTestInputs())))
+}
+
+func TestExportCoverage(t *testing.T) {
+	coverage, fInfoMap, blocks := exportTestInputs()
+	written := map[string]string{}
+	options := newTestOptions()
+	options.modulePath = "example.com/mod/"
+	options.writeFile = func(path string, data []byte, perm os.FileMode) error {
+		written[path] = string(data)
+		return nil
+	}
+
+	// Nothing is written unless requested.
+	assert.Nil(t, exportCoverage(options, coverage, fInfoMap, blocks))
+	assert.Empty(t, written)
+
+	options.coberturaPath = "coverage.xml"
+	options.lcovPath = "coverage.lcov"
+	assert.Nil(t, exportCoverage(options, coverage, fInfoMap, blocks))
+	files := collectCoverage(coverage, fInfoMap, blocks)
+	assert.Equal(t, map[string]string{
+		"coverage.xml":  makeCobertura(options.modulePath, files),
+		"coverage.lcov": makeLCOV(files),
+	}, written)
+
+	options.writeFile = func(string, []byte, os.FileMode) error {
+		return errors.New("writeFile failed")
+	}
+	assert.ErrorContains(t, exportCoverage(options, coverage, fInfoMap, blocks),
+		"failed writing Cobertura report to coverage.xml: writeFile failed")
+	options.coberturaPath = ""
+	assert.ErrorContains(t, exportCoverage(options, coverage, fInfoMap, blocks),
+		"failed writing LCOV tracefile to coverage.lcov: writeFile failed")
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// githubSummaryEnvVar names the file that GitHub Actions displays as the job
+// summary; see
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions.
+const githubSummaryEnvVar = "GITHUB_STEP_SUMMARY"
+
+// escapeGitHubData escapes the message of a workflow command.
+func escapeGitHubData(data string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(data)
+}
+
+// escapeGitHubProperty escapes a property of a workflow command.
+func escapeGitHubProperty(property string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(escapeGitHubData(property))
+}
+
+// makeGitHubAnnotations creates the workflow commands used by --format=github
+// from the results of coveragecheck.CheckCoverage, returning one `::error` or
+// `::warning` command per violation so that GitHub annotates the function in
+// pull requests.
+func makeGitHubAnnotations(results []coveragecheck.CheckResult) []string {
+	annotations := []string{}
+	for _, result := range results {
+		for _, message := range result.Messages() {
+			annotations = append(annotations, fmt.Sprintf("::%s file=%s,line=%s,title=%s::%s",
+				result.Severity,
+				escapeGitHubProperty(result.Coverage.Filename),
+				escapeGitHubProperty(result.Coverage.LineNumber),
+				escapeGitHubProperty("Coverage check failed for "+qualifiedFunctionName(result)),
+				escapeGitHubData(message)))
+		}
+	}
+	return annotations
+}
+
+// escapeMarkdownCell escapes text so it can be used in a Markdown table cell.
+func escapeMarkdownCell(text string) string {
+	return strings.NewReplacer("|", "\\|", "\n", "<br>", "\t", " ").Replace(text)
+}
+
+// makeGitHubSummary creates the Markdown job summary written by
+// --format=github, containing the totals and a table of every function that
+// failed its checks.
+func makeGitHubSummary(results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) string {
+	passed, failed := 0, []coveragecheck.CheckResult{}
+	for _, result := range results {
+		if result.Passed {
+			passed++
+		} else {
+			failed = append(failed, result)
+		}
+	}
+	_, _, percentage := coveragecheck.StatementCoverage(blocks)
+	lines := []string{
+		"## Coverage check",
+		"",
+		fmt.Sprintf("%d of %d functions passed; %.1f%% of statements are covered.", passed, len(results), percentage),
+	}
+	if len(failed) > 0 {
+		lines = append(lines,
+			"",
+			"| Function | Location | Coverage | Required | Severity | Violations |",
+			"| --- | --- | ---: | ---: | --- | --- |")
+		for _, result := range failed {
+			lines = append(lines, fmt.Sprintf("| `%s` | %s:%s | %.1f%% | %.1f%% | %s | %s |",
+				qualifiedFunctionName(result),
+				escapeMarkdownCell(result.Coverage.Filename),
+				result.Coverage.LineNumber,
+				result.Coverage.Coverage,
+				result.RequiredCoverage,
+				result.Severity,
+				escapeMarkdownCell(strings.Join(result.Messages(), "\n"))))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// appendFile appends data to the file at path, creating it if necessary.
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
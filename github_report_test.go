@@ -0,0 +1,96 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func githubTestResults() []coveragecheck.CheckResult {
+	return []coveragecheck.CheckResult{
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			Function:         coveragecheck.FunctionInfo{Receiver: "Client"},
+			RequiredCoverage: 100,
+			Passed:           false,
+			Severity:         coveragecheck.SeverityError,
+			Violations:       []coveragecheck.Violation{{Message: "api.go:12:\tGet\t50.0%: 100% | more\nlines"}},
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "a,b:c.go", LineNumber: "20", Function: "Put", Coverage: 75},
+			RequiredCoverage: 80,
+			Passed:           false,
+			Severity:         coveragecheck.SeverityWarning,
+			Violations:       []coveragecheck.Violation{{Message: "first"}, {Message: "second"}},
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "main.go", LineNumber: "3", Function: "main", Coverage: 100},
+			RequiredCoverage: 80,
+			Passed:           true,
+			Severity:         coveragecheck.SeverityError,
+		},
+	}
+}
+
+func TestMakeGitHubAnnotations(t *testing.T) {
+	expected := []string{
+		"::error file=api.go,line=12,title=Coverage check failed for Client.Get::api.go:12:\tGet\t50.0%25: 100%25 | more%0Alines",
+		"::warning file=a%2Cb%3Ac.go,line=20,title=Coverage check failed for Put::first",
+		"::warning file=a%2Cb%3Ac.go,line=20,title=Coverage check failed for Put::second",
+	}
+	assert.Equal(t, expected, makeGitHubAnnotations(githubTestResults()))
+	assert.Equal(t, []string{}, makeGitHubAnnotations(nil))
+}
+
+func TestMakeGitHubSummary(t *testing.T) {
+	blocks := []coveragecheck.ProfileBlock{
+		{NumStatements: 3, Count: 1},
+		{NumStatements: 1, Count: 0},
+	}
+	expected := `## Coverage check
+
+1 of 3 functions passed; 75.0% of statements are covered.
+
+| Function | Location | Coverage | Required | Severity | Violations |
+| --- | --- | ---: | ---: | --- | --- |
+| ` + "`Client.Get`" + ` | api.go:12 | 50.0% | 100.0% | error | api.go:12: Get 50.0%: 100% \| more<br>lines |
+| ` + "`Put`" + ` | a,b:c.go:20 | 75.0% | 80.0% | warning | first<br>second |
+`
+	assert.Equal(t, expected, makeGitHubSummary(githubTestResults(), blocks))
+
+	expected = `## Coverage check
+
+1 of 1 functions passed; 0.0% of statements are covered.
+`
+	assert.Equal(t, expected, makeGitHubSummary(githubTestResults()[2:], nil))
+}
+
+func TestAppendFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	assert.Nil(t, appendFile(path, []byte("first\n")))
+	assert.Nil(t, appendFile(path, []byte("second\n")))
+	contents, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "first\nsecond\n", string(contents))
+
+	assert.ErrorContains(t, appendFile(filepath.Join(path, "not-a-dir"), nil), "not a directory")
+	// Writing to /dev/full always fails with ENOSPC.
+	assert.ErrorContains(t, appendFile("/dev/full", []byte("data")), "no space left on device")
+}
@@ -15,50 +15,66 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
 	"io"
+	"net/http"
 	"os"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 	"unicode"
 
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
 	"golang.org/x/mod/modfile"
-	"gopkg.in/yaml.v2"
 )
 
 // Constants used with --coverage_html.
 const htmlOpenInBrowser = "browser"
 const htmlShowPath = "path"
 
-// Used when sleeping between reads.
-const sleepTime = 10 * time.Millisecond
+// Constants used with --format.
+const formatText = "text"
+const formatJSON = "json"
+const formatSARIF = "sarif"
+const formatJUnit = "junit"
+const formatGitHub = "github"
+const formatMarkdown = "markdown"
+
+// outputFormats lists the valid options for --format.
+var outputFormats = []string{formatText, formatJSON, formatSARIF, formatJUnit, formatGitHub, formatMarkdown}
 
 // Options contains all the flags and dependency-injected functions used in
 // this program.  It exists so that tests can easily replace flags and
 // functions to trigger failure handling.
 type Options struct {
 	// Function pointers for dependency injection.
+	// Used to write the job summary for --format=github.
+	appendFile func(string, []byte) error
 	// Used by goCover to run binaries and capture their stdout.
 	captureOutput func(string, ...string) ([]string, error)
-	// Makes the shell script used by --coverage_html=path executable.
-	chmod func(*os.File, os.FileMode) error
+	// Used by --jobs to run each package's tests so that --fail_fast can
+	// cancel them.
+	captureOutputContext func(context.Context, string, ...string) ([]string, error)
+	// Used by the diff command to run the tests in a git worktree.
+	captureOutputInDir func(string) func(string, ...string) ([]string, error)
 	// Used to create a temporary file.
 	createTemp func(string, string) (*os.File, error)
 	// Called when exiting on error.
 	exit func(int)
-	// Reads a line from the output file created by --coverage_html=path,
-	// retrying on EOF.
-	readLineWithRetry func(*os.File) (string, error)
-	// Used to set $BROWSER in goCoverCapturePath.
-	setenv func(string, string) error
+	// Used to look up $GITHUB_STEP_SUMMARY.
+	getenv func(string) string
+	// Runs the HTTP server for --serve.
+	listenAndServe func(string, http.Handler) error
+	// Used by --watch to wait between checking for changes.
+	sleep func(time.Duration)
+	// Used to timestamp entries written by --history.
+	now func() time.Time
+	// Used to create the directory for the HTML report for
+	// --coverage_html=path.
+	mkdirTemp func(string, string) (string, error)
+	// Used to write the files requested by --cobertura, --lcov, and --badge.
+	writeFile func(string, []byte, os.FileMode) error
 
 	// Paths to read from.
 	// The config file to read, .golang-coverage-check.yaml except when
@@ -67,8 +83,8 @@ type Options struct {
 	// The file to read module metadata from, "go.mod" except when testing error
 	// handling.
 	goMod string
-	// The directory makeFunctionInfoMap() parses, "." except when testing error
-	// handling.
+	// The directory coveragecheck.ParseFunctions() parses, "." except when
+	// testing error handling.
 	dirToParse string
 
 	// Flags.
@@ -80,9 +96,50 @@ type Options struct {
 	// Set by --debug_matching; output debugging information about matching
 	// coverage lines to rules.
 	debugMatching bool
-	// Set by --coverage_html; if non-empty, generate HTML output, either opening
-	// a browser or outputting the path to the generated HTML.
+	// Set by --coverage_html; if non-empty, write the HTML report to a
+	// temporary directory, then either open it in a browser or output the path
+	// to it.
 	coverageHTML string
+	// Set by --html_report; if non-empty, the directory to write the HTML
+	// report to.
+	htmlReportDir string
+	// Set by --serve; if non-empty, the address to serve the HTML report on.
+	serveAddr string
+	// Set by --watch; check coverage every time the code or config changes.
+	watch bool
+	// Set by --format; the format of the report written to stdout.
+	format string
+	// Set by --cobertura; if non-empty, the path to write a Cobertura XML
+	// report of the collected coverage to.
+	coberturaPath string
+	// Set by --lcov; if non-empty, the path to write an LCOV tracefile of the
+	// collected coverage to.
+	lcovPath string
+	// Set by --badge; if non-empty, the path to write an SVG badge showing
+	// total coverage to.
+	badgePath string
+	// Set by --report, which can be repeated; the reports to write to files in
+	// addition to the report written to stdout.
+	reports reportOutputs
+	// Set by --history; if non-empty, the path to append coverage history to,
+	// and to read history from for the history command.
+	historyPath string
+	// Set by --baseline; if non-empty, the baseline that coverage must not
+	// decrease from.
+	baselinePath string
+	// Set by --write_baseline; write the baseline to baselinePath instead of
+	// checking coverage.
+	writeBaseline bool
+	// Set by --attribute_tests; find which tests execute each function.
+	attributeTests bool
+	// Set by --cross_package_tests; include coverage from the tests in every
+	// package in the module.
+	crossPackageTests bool
+	// Set by --jobs; if positive, the maximum number of packages tested at once
+	// by --cross_package_tests.
+	jobs int
+	// Set by --fail_fast; stop testing packages after the first failure.
+	failFast bool
 
 	// Other configuration/data that needs to be passed around.
 	// Module path extracted from go.mod.
@@ -98,6 +155,9 @@ type Options struct {
 	// Where to write output and error messages.
 	stdout io.Writer
 	stderr io.Writer
+	// The number of times --watch checks for changes before returning; zero
+	// means forever, and other values are only used when testing.
+	maxWatchIterations int
 }
 
 // newOptions returns an Options struct with fields set to standard values.
@@ -109,474 +169,76 @@ func newOptions() Options {
 		}
 	}
 	return Options{
-		captureOutput:     captureOutput,
-		chmod:             chmod,
-		createTemp:        os.CreateTemp,
-		exit:              os.Exit,
-		readLineWithRetry: readLineWithRetry,
-		setenv:            os.Setenv,
-		configFile:        ".golang-coverage-check.yaml",
-		goMod:             "go.mod",
-		dirToParse:        ".",
-		programName:       os.Args[0],
-		rawArgs:           args,
-		stdout:            os.Stdout,
-		stderr:            os.Stderr,
+		appendFile:           appendFile,
+		captureOutput:        coveragecheck.CaptureOutput,
+		captureOutputContext: coveragecheck.CaptureOutputContext,
+		captureOutputInDir:   coveragecheck.CaptureOutputInDir,
+		createTemp:           os.CreateTemp,
+		exit:                 os.Exit,
+		getenv:               os.Getenv,
+		listenAndServe:       http.ListenAndServe,
+		sleep:                time.Sleep,
+		now:                  time.Now,
+		mkdirTemp:            os.MkdirTemp,
+		writeFile:            os.WriteFile,
+		configFile:           ".golang-coverage-check.yaml",
+		format:               formatText,
+		goMod:                "go.mod",
+		dirToParse:           ".",
+		programName:          os.Args[0],
+		rawArgs:              args,
+		stdout:               os.Stdout,
+		stderr:               os.Stderr,
 	}
 }
 
-// chmod is a wrapper around os.File.Chmod for easy testing.
-func chmod(file *os.File, mode os.FileMode) error {
-	return file.Chmod(mode)
-}
-
-// CoverageLine represents a single line of coverage output.
-type CoverageLine struct {
-	// Filename is the name of the source file, with the module path removed.
-	Filename string
-	// LineNumber is the line number the function can be found at.
-	LineNumber string
-	// Function is the name of the function.
-	Function string
-	// Coverage is the coverage percentage.
-	Coverage float64
-}
-
-func (coverage CoverageLine) String() string {
-	return fmt.Sprintf("%s:%s:\t%s\t%.1f%%",
-		coverage.Filename, coverage.LineNumber, coverage.Function, coverage.Coverage)
-}
-
-// Rule represents a coverage rule.
-type Rule struct {
-	// Comment is not interpreted or used; it is provided as a structured way of
-	// adding comments to an entry, so that automated editing is easier.
-	Comment string
-	// Regex used when matching against a filename.
-	FilenameRegex string `yaml:"filename_regex"`
-	// Regex used when matching against a function.
-	FunctionRegex string `yaml:"function_regex"`
-	// Regex used when matching against a method receiver.
-	ReceiverRegex string `yaml:"receiver_regex"`
-	// Coverage level required for this function or filename; this is a floating
-	// point percentage, so it should be >= 0 and <= 100.
-	Coverage float64
-	// compiledFilenameRegex is the result of regexp.MustCompile(FilenameRegex).
-	compiledFilenameRegex *regexp.Regexp
-	// compiledFunctionRegex is the result of regexp.MustCompile(FunctionRegex).
-	compiledFunctionRegex *regexp.Regexp
-	// compiledFunctionRegex is the result of regexp.MustCompile(ReceiverRegex).
-	compiledReceiverRegex *regexp.Regexp
-}
-
-func (rule Rule) String() string {
-	return fmt.Sprintf("FilenameRegex: %v FunctionRegex: %v ReceiverRegex: %v Coverage: %v Comment: %v",
-		rule.FilenameRegex, rule.FunctionRegex, rule.ReceiverRegex, rule.Coverage, rule.Comment)
-}
-
-// Config represents an entire user config loaded from .golang-coverage-check.yaml.
-type Config struct {
-	// Comment is not interpreted or used; it is provided as a structured way of
-	// adding comments to a config, so that automated editing is easier.
-	Comment string
-	// DefaultCoverage is the default coverage required if none of the function or
-	// filename rules match; this is a floating point percentage, so it should be
-	// >= 0 and <= 100.
-	DefaultCoverage float64 `yaml:"default_coverage"`
-	// Rules is a list of rules that will be checked in-order, and the first match wins.
-	Rules []Rule
-}
-
-func (config Config) String() string {
-	bytes, _ := yaml.Marshal(&config)
-	return string(bytes)
-}
-
-// makeExampleConfig creates an example Config and returns the string
-// representation in YAML format.
-func makeExampleConfig() []string {
-	config := Config{
-		Comment: "Comment is not interpreted or used; it is provided as a " +
-			"structured way of adding comments to a config, so that automated " +
-			"editing is easier.",
-		DefaultCoverage: 80.0,
-		Rules: []Rule{
-			{
-				Comment:       "Low coverage is acceptable for main()",
-				FunctionRegex: "^main$",
-				Coverage:      50,
-			},
-			{
-				Comment: "All the fooOrDie() functions should be fully tested because" +
-					" they panic() on failure",
-				FunctionRegex: "OrDie$",
-				Coverage:      100,
-			},
-			{
-				Comment:       "Improve test coverage for parse_json.go?",
-				FilenameRegex: "^parse_json.go$",
-				Coverage:      73,
-			},
-			{
-				Comment:       "Full coverage for other parsers",
-				FilenameRegex: "^parse.*.go$",
-				Coverage:      100,
-			},
-			{
-				Comment:       "Url.String() has low coverage",
-				FilenameRegex: "^urls.go$",
-				FunctionRegex: "^String$",
-				ReceiverRegex: "^Url$",
-				Coverage:      56,
-			},
-			{
-				Comment:       "String() everywhere else should have high coverage",
-				FunctionRegex: "^String$",
-				Coverage:      100,
-			},
-		},
-	}
-	return []string{config.String()}
-}
-
-// Generate a Config from coverage information; used by --generate_config.
-func generateConfig(coverage []CoverageLine, fInfoMap FunctionInfoMap) Config {
-	config := Config{
-		DefaultCoverage: 100,
-	}
-	for _, cov := range coverage {
-		key := functionLocationKey(cov.Filename, cov.LineNumber)
-		receiver := fInfoMap[key].Receiver
-		config.Rules = append(config.Rules,
-			Rule{
-				Comment:       "Generated rule for " + cov.Function + ", found at " + cov.Filename + ":" + cov.LineNumber,
-				Coverage:      cov.Coverage,
-				FunctionRegex: "^" + cov.Function + "$",
-				FilenameRegex: "^" + cov.Filename + "$",
-				ReceiverRegex: "^" + receiver + "$",
-			})
-	}
-	return config
+// multipleBooleanFlagsMessage returns the message about accepting only one
+// boolean flag, because it's used in multiple places.
+func multipleBooleanFlagsMessage() string {
+	return fmt.Sprintf(
+		`only one of --example_config, --generate_config, --debug_matching,
+--coverage_html=%s, --serve, --watch, or --format other than %s can be used
+because they all output to stdout and their output would be mixed up if more
+than one is used`,
+		htmlShowPath, formatText)
 }
 
-// validateConfig checks a config for correctness, including compiling every
-// regex and caching the result.  Returns an updated config and an error.
-func validateConfig(config Config) (Config, error) {
-	if config.DefaultCoverage < 0 || config.DefaultCoverage > 100 {
-		return config, fmt.Errorf("default coverage (%.1f) is outside the range 0-100", config.DefaultCoverage)
-	}
-	for i := range config.Rules {
-		if config.Rules[i].FilenameRegex == "" && config.Rules[i].FunctionRegex == "" && config.Rules[i].ReceiverRegex == "" {
-			return config, fmt.Errorf("every regex is an empty string in rule %v", config.Rules[i])
-		}
-		config.Rules[i].compiledFilenameRegex = regexp.MustCompile(config.Rules[i].FilenameRegex)
-		config.Rules[i].compiledFunctionRegex = regexp.MustCompile(config.Rules[i].FunctionRegex)
-		config.Rules[i].compiledReceiverRegex = regexp.MustCompile(config.Rules[i].ReceiverRegex)
-		if config.Rules[i].Coverage < 0 || config.Rules[i].Coverage > 100 {
-			return config, fmt.Errorf("coverage (%.1f) is outside the range 0-100 in %v", config.Rules[i].Coverage, config.Rules[i])
+// isOutputFormat returns true if format is one of outputFormats.
+func isOutputFormat(format string) bool {
+	for _, outputFormat := range outputFormats {
+		if format == outputFormat {
+			return true
 		}
 	}
-	return config, nil
-}
-
-// parseYAMLConfig parses raw YAML into a Config, checks it for correctness, and
-// compiles every regex for speed.  Returns a config and an error.
-func parseYAMLConfig(yamlConf []byte) (Config, error) {
-	var config Config
-	if err := yaml.UnmarshalStrict(yamlConf, &config); err != nil {
-		return config, fmt.Errorf("failed parsing YAML: %w", err)
-	}
-	return validateConfig(config)
-}
-
-type FunctionInfo struct {
-	// The filename the function is defined in.
-	Filename string
-	// The line number of the function definition.
-	LineNumber string
-	// The function name.
-	Function string
-	// For functions: empty string.  For methods: the receiver class.
-	Receiver string
-}
-
-type FunctionInfoMap map[string]FunctionInfo
-
-// functionLocationKey turns a filename and line number into a string key for
-// a FunctionInfoMap; it must return the same key as FunctionInfo.key().
-func functionLocationKey(filename, lineNumber string) string {
-	return filename + ":" + lineNumber
-}
-
-// key generates a string key for a FunctionInfoMap; it must return the same
-// key as functionLocationKey.
-func (fl FunctionInfo) key() string {
-	return fl.Filename + ":" + fl.LineNumber
+	return false
 }
 
-// makeFunctionInfoMap parses the code in the current directory and constructs
-// a map from filename:linenumber to FunctionInfo, returning a FunctionInfoMap
-// and an error.
-func makeFunctionInfoMap(opts Options) (FunctionInfoMap, error) {
-	fmap := make(FunctionInfoMap)
-	fset := token.NewFileSet()
-	packageMap, err := parser.ParseDir(fset, opts.dirToParse, nil, 0)
-	if err != nil {
-		return nil, err
-	}
-	for _, pkg := range packageMap {
-		for _, file := range pkg.Files {
-			for _, decl := range file.Decls {
-				if function, ok := decl.(*ast.FuncDecl); ok {
-					pos := fset.Position(function.Pos())
-					fl := FunctionInfo{
-						Filename:   pos.Filename,
-						LineNumber: fmt.Sprintf("%d", pos.Line),
-						Function:   function.Name.Name,
-						Receiver:   "",
-					}
-					if function.Recv != nil {
-						// This is ugly, but I haven't found a better way to get the string
-						// out of the data structure.
-						fl.Receiver = fmt.Sprintf("%v", function.Recv.List[0].Type)
-					}
-					fmap[fl.key()] = fl
-				}
+// validateFlags checks for conflicting flags and returns an error.
+func validateFlags(options Options) error {
+	if len(options.parsedArgs) > 0 {
+		command, numArgs := options.parsedArgs[0], len(options.parsedArgs)-1
+		switch {
+		case command == historyCommand && (numArgs == 0 || numArgs == 2):
+			if options.historyPath == "" {
+				return fmt.Errorf("the %q command requires --history", historyCommand)
 			}
+		case command == diffCommand && (numArgs == 1 || numArgs == 2):
+		default:
+			return fmt.Errorf("unexpected arguments: %v; the commands are %q, optionally followed by two history entries to compare, and %q followed by one or two git revisions",
+				options.parsedArgs, historyCommand, diffCommand)
 		}
 	}
-
-	return fmap, nil
-}
-
-// captureOutput runs a command and returns the output on success (a slice of
-// strings) and an error on failure.
-func captureOutput(command string, args ...string) ([]string, error) {
-	cmd := exec.Command(command, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("failed running `%s`: %w\n%s", cmd, err, output)
-	}
-	return strings.Split(string(output), "\n"), nil
-}
-
-// readLineWithRetry reads a single line from file, retrying on EOF and
-// returning all other errors.
-func readLineWithRetry(file *os.File) (string, error) {
-	reader := bufio.NewReader(file)
-	var line string
-	var err error
-	for {
-		line, err = reader.ReadString('\n')
-		if err == nil {
-			break
-		}
-		if err == io.EOF {
-			time.Sleep(sleepTime)
-		} else {
-			return "", err
-		}
-	}
-	return line, nil
-}
-
-// goCoverCapturePath sets $BROWSER so the path to the generated coverage is
-// captured and printed to the user.  Returns an array of strings (only one
-// element) and an error on failure.
-func goCoverCapturePath(options Options, coverageFile string) ([]string, error) {
-	outputFile, err := options.createTemp("", "golang-coverage-check.*.html-path")
-	if err != nil {
-		return nil, err
+	if options.writeBaseline && options.baselinePath == "" {
+		return fmt.Errorf("--write_baseline requires --baseline")
 	}
-	defer os.Remove(outputFile.Name())
-	shellScript, err := options.createTemp("", "golang-coverage-check.*.sh")
-	if err != nil {
-		return nil, err
-	}
-	defer os.Remove(shellScript.Name())
-
-	shellScriptContents := `#!/bin/sh
-
-echo "$@" > "%s"
-`
-	fmt.Fprintf(shellScript, shellScriptContents, outputFile.Name())
-	if err = options.chmod(shellScript, 0755); err != nil {
-		return nil, err
-	}
-	if err = options.setenv("BROWSER", shellScript.Name()); err != nil {
-		return nil, err
+	if options.jobs < 0 {
+		return fmt.Errorf("--jobs (%d) must not be negative", options.jobs)
 	}
-	_, err = options.captureOutput("go", "tool", "cover", "--html", coverageFile)
-	if err != nil {
-		return nil, err
+	if options.jobs > 0 && !options.crossPackageTests {
+		return fmt.Errorf("--jobs requires --cross_package_tests; without it only one package is tested, by a single go test")
 	}
-	htmlFile, err := options.readLineWithRetry(outputFile)
-	if err != nil {
-		return nil, err
-	}
-	return []string{htmlFile}, nil
-}
-
-// goCover runs the commands to generate coverage.  It returns
-//   - a slice of strings containing the command's output
-//   - a slice of strings containing the path to the generated HTML if
-//     --coverage_html == htmlShowPath
-//   - an error if running any command failed.
-func goCover(options Options) ([]string, []string, error) {
-	file, err := options.createTemp("", "golang-coverage-check")
-	if err != nil {
-		return nil, nil, err
-	}
-	defer os.Remove(file.Name())
-
-	_, err = options.captureOutput("go", "test", "--covermode", "set", "--coverprofile", file.Name())
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if options.coverageHTML == htmlOpenInBrowser {
-		_, err = options.captureOutput("go", "tool", "cover", "--html", file.Name())
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	var htmlPath []string
-	if options.coverageHTML == htmlShowPath {
-		htmlPath, err = goCoverCapturePath(options, file.Name())
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	lines, err := options.captureOutput("go", "tool", "cover", "--func", file.Name())
-	return lines, htmlPath, err
-}
-
-// parseCoverageOutput parses all the coverage lines and turns each into a
-// CoverageLine, returning a slice of CoverageLine and an error.
-func parseCoverageOutput(options Options, output []string) ([]CoverageLine, error) {
-	results := []CoverageLine{}
-	lineSplitter := regexp.MustCompile(`\t+`)
-	percentageExtractor := regexp.MustCompile(`^(.*)%$`)
-
-	for i := range output {
-		if len(output[i]) == 0 {
-			// Skip blank lines.
-			continue
-		}
-		parts := lineSplitter.Split(output[i], -1)
-		if len(parts) != 3 {
-			return nil, fmt.Errorf("expected 3 parts, found %v, in \"%v\" => %v", len(parts), output[i], parts)
-		}
-		if parts[0] == "total:" {
-			continue
-		}
-		rawFilename, rawFunction, rawPercentage := parts[0], parts[1], parts[2]
-
-		matches := percentageExtractor.FindStringSubmatch(rawPercentage)
-		if len(matches) == 0 {
-			return nil, fmt.Errorf("could not extract percentage from \"%v\"", rawPercentage)
-		}
-		percentage, err := strconv.ParseFloat(matches[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("failed parsing \"%v\" as a float: %w", rawPercentage, err)
-		}
-		if percentage > 100 {
-			return nil, fmt.Errorf("percentage (%v) > 100 in \"%v\"", percentage, rawPercentage)
-		}
-		if percentage < 0 {
-			return nil, fmt.Errorf("percentage (%v) < 0 in \"%v\"", percentage, rawPercentage)
-		}
-
-		fileLineParts := strings.Split(rawFilename, ":")
-		if len(fileLineParts) != 3 {
-			return nil, fmt.Errorf("expected `filename:linenumber:` in \"%v\"", rawFilename)
-		}
-
-		results = append(results, CoverageLine{
-			Filename:   strings.TrimPrefix(fileLineParts[0], options.modulePath),
-			LineNumber: fileLineParts[1],
-			Function:   rawFunction,
-			Coverage:   percentage,
-		})
-	}
-	return results, nil
-}
-
-// checkCoverage checks that each function meets the required level of coverage,
-// returning a string containing debugging information and an error if
-// appropriate.
-func checkCoverage(config Config, coverage []CoverageLine, fInfoMap FunctionInfoMap) ([]string, error) {
-	errors := []string{}
-	debugInfo := []string{"Debug info for coverage matching"}
-
-Coverage:
-	for _, cov := range coverage {
-		debugInfo = append(debugInfo, fmt.Sprintf("- Line %v", cov))
-		for _, rule := range config.Rules {
-			if rule.FilenameRegex != "" && !rule.compiledFilenameRegex.MatchString(cov.Filename) {
-				continue
-			}
-			if rule.FunctionRegex != "" && !rule.compiledFunctionRegex.MatchString(cov.Function) {
-				continue
-			}
-			if rule.ReceiverRegex != "" {
-				key := functionLocationKey(cov.Filename, cov.LineNumber)
-				receiver := fInfoMap[key].Receiver
-				if !rule.compiledReceiverRegex.MatchString(receiver) {
-					continue
-				}
-			}
-			debugInfo = append(debugInfo, fmt.Sprintf("  - Matching rule: %v", rule))
-			if cov.Coverage < rule.Coverage {
-				debugInfo = append(debugInfo,
-					fmt.Sprintf("  - actual coverage %.1f%% < required coverage %.1f%%",
-						cov.Coverage, rule.Coverage))
-				errors = append(errors,
-					fmt.Sprintf("%v: actual coverage %.1f%% < required coverage %.1f%%: matching rule is `%v`",
-						cov, cov.Coverage, rule.Coverage, rule))
-			} else {
-				debugInfo = append(debugInfo,
-					fmt.Sprintf("  - actual coverage %.1f%% >= required coverage %.1f%%",
-						cov.Coverage, rule.Coverage))
-			}
-			continue Coverage
-		}
-
-		if cov.Coverage < config.DefaultCoverage {
-			errors = append(errors,
-				fmt.Sprintf("%v: actual coverage %.1f%% < default coverage %.1f%%",
-					cov, cov.Coverage, config.DefaultCoverage))
-			debugInfo = append(debugInfo,
-				fmt.Sprintf("  - Default coverage %.1f%% not satisfied",
-					config.DefaultCoverage))
-		} else {
-			debugInfo = append(debugInfo,
-				fmt.Sprintf("  - Default coverage %.1f%% satisfied",
-					config.DefaultCoverage))
-		}
-	}
-
-	if len(errors) > 0 {
-		return debugInfo, fmt.Errorf("%s", strings.Join(errors, "\n"))
-	}
-	return debugInfo, nil
-}
-
-// multipleBooleanFlagsMessage returns the message about accepting only one
-// boolean flag, because it's used in multiple places.
-func multipleBooleanFlagsMessage() string {
-	return fmt.Sprintf(
-		`only one of --example_config, --generate_config, --debug_matching, or
---coverage_html=%s can be used because they all output to stdout and their
-output would be mixed up if more than one is used`, htmlShowPath)
-}
-
-// validateFlags checks for conflicting flags and returns an error.
-func validateFlags(options Options) error {
-	if len(options.parsedArgs) > 0 {
-		return fmt.Errorf("unexpected arguments: %v", options.parsedArgs)
+	if options.failFast && options.jobs == 0 {
+		return fmt.Errorf("--fail_fast requires --jobs")
 	}
 	if options.coverageHTML != "" &&
 		options.coverageHTML != htmlOpenInBrowser &&
@@ -585,8 +247,14 @@ func validateFlags(options Options) error {
 			options.coverageHTML, htmlOpenInBrowser, htmlShowPath)
 	}
 
+	if !isOutputFormat(options.format) {
+		return fmt.Errorf("unrecognised option for flag --format: %q; valid options are %q",
+			options.format, outputFormats)
+	}
+
 	enabled := []bool{options.outputExampleConfig, options.generateConfig, options.debugMatching}
-	enabled = append(enabled, options.coverageHTML == htmlShowPath)
+	enabled = append(enabled, options.coverageHTML == htmlShowPath, options.serveAddr != "", options.watch,
+		options.format != formatText)
 	count := 0
 	for _, e := range enabled {
 		if e {
@@ -605,6 +273,9 @@ func setupFlagsAndUsage(options *Options) *flag.FlagSet {
 	flags.SetOutput(options.flagOutput)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage of %s:\n", options.programName)
+		fmt.Fprintf(flags.Output(), "  %s [flags]\n", options.programName)
+		fmt.Fprintf(flags.Output(), "  %s --history=PATH %s [FROM TO]\n", options.programName, historyCommand)
+		fmt.Fprintf(flags.Output(), "  %s %s FROM [TO]\n", options.programName, diffCommand)
 		flags.PrintDefaults()
 		message := []rune(multipleBooleanFlagsMessage())
 		message[0] = unicode.ToUpper(message[0])
@@ -621,17 +292,115 @@ without checking coverage`)
 	flags.StringVar(&options.coverageHTML, "coverage_html", "",
 		fmt.Sprintf(
 			`If non-empty will generate HTML coverage:
-- set to %q to open in a browser
-- set to %q to output the path to the HTML
+- set to %q to write the HTML report to a new temporary directory
+  and open it in a browser, using $BROWSER if it's set
+- set to %q to write the HTML report to a new temporary directory
+  and output the path to it
 
 In both cases coverage will still be checked against the rules
-you've defined.  Note that %q has only been tested on MacOS,
-and requires /bin/sh, so it definitely won't work on Windows.
+you've defined.
+`,
+			htmlOpenInBrowser, htmlShowPath))
+	flags.StringVar(&options.htmlReportDir, "html_report", "",
+		`If non-empty, write the HTML report to this directory`)
+	flags.BoolVar(&options.watch, "watch", false,
+		`Check coverage every time a .go file or the config changes, and output
+which functions newly pass or fail`)
+	flags.StringVar(&options.serveAddr, "serve", "",
+		`If non-empty, serve the HTML report on this address, e.g. localhost:8080;
+the address must be on localhost`)
+	flags.StringVar(&options.coberturaPath, "cobertura", "",
+		`If non-empty, write a Cobertura XML report of the collected coverage
+to this path`)
+	flags.StringVar(&options.lcovPath, "lcov", "",
+		`If non-empty, write an LCOV tracefile of the collected coverage to
+this path`)
+	flags.StringVar(&options.badgePath, "badge", "",
+		`If non-empty, write an SVG badge showing total coverage to this path`)
+	flags.StringVar(&options.format, "format", formatText,
+		fmt.Sprintf(
+			`The format of the report written to stdout:
+- %q outputs nothing when coverage is sufficient, and errors
+  otherwise
+- %q outputs a JSON report of every function checked
+- %q outputs a SARIF 2.1.0 log of every violation
+- %q outputs a JUnit XML report with a testcase per function
+- %q outputs GitHub Actions annotations for every violation, and appends a
+  summary to $%s if it is set
+- %q outputs a Markdown report suitable for pull request comments
 `,
-			htmlOpenInBrowser, htmlShowPath, htmlShowPath))
+			formatText, formatJSON, formatSARIF, formatJUnit, formatGitHub, githubSummaryEnvVar, formatMarkdown))
+	flags.Var(&options.reports, "report",
+		fmt.Sprintf(
+			`Write a report to a file as well as the report written to stdout,
+as FORMAT:PATH, where FORMAT is one of the formats accepted by
+--format; can be repeated, e.g.
+--report=json:coverage.json --report=junit:coverage.xml.  %q writes
+every violation rather than just warnings`,
+			formatText))
+	flags.StringVar(&options.baselinePath, "baseline", "",
+		`If non-empty, the baseline written by --write_baseline; functions
+whose coverage is lower than in the baseline fail, even if they meet
+the rules in the config`)
+	flags.BoolVar(&options.writeBaseline, "write_baseline", false,
+		`Write the coverage of every function to the path given by --baseline
+and exit without checking coverage`)
+	flags.StringVar(&options.historyPath, "history", "",
+		fmt.Sprintf(
+			`If non-empty, append the commit, time, and package and function coverage
+to this file after checking coverage.  Running the %q command outputs the
+coverage trend and compares two entries, by default the last two; entries
+can be identified by number or commit hash prefix`,
+			historyCommand))
+	flags.BoolVar(&options.attributeTests, "attribute_tests", false,
+		`Run each test separately to find which tests execute each function,
+and include them in --debug_matching and --format=json output; this is
+done automatically if a rule sets min_covering_tests`)
+	flags.BoolVar(&options.crossPackageTests, "cross_package_tests", false,
+		`Include coverage from the tests in every package in the module, using
+go test --coverpkg; functions matching a rule that sets
+require_own_package_tests only use coverage from their own package's
+tests`)
+	flags.IntVar(&options.jobs, "jobs", 0,
+		`If positive, --cross_package_tests runs go test separately for each
+package, at most this many at once, merges the coverage, and writes the
+output of each package to stderr in package order; otherwise a single
+go test tests every package.  Requires --cross_package_tests, because
+without it only the package being checked is tested, by a single go test,
+so there is nothing to run in parallel`)
+	flags.BoolVar(&options.failFast, "fail_fast", false,
+		`With --jobs, cancel the packages being tested and skip the remaining
+packages after the first package whose tests fail`)
 	return flags
 }
 
+// runCheck checks coverage with coveragecheck.Check, configured from options.
+// Errors that prevent checking coverage are returned; insufficient coverage is
+// reported in coveragecheck.Report.Results.
+func runCheck(options Options) (coveragecheck.Report, error) {
+	checkOptions := coveragecheck.NewOptions()
+	checkOptions.CaptureOutput = options.captureOutput
+	checkOptions.CreateTemp = options.createTemp
+	checkOptions.ConfigFile = options.configFile
+	checkOptions.Dir = options.dirToParse
+	checkOptions.ModulePath = options.modulePath
+	checkOptions.AttributeTests = options.attributeTests
+	checkOptions.CrossPackageTests = options.crossPackageTests
+	checkOptions.Jobs = options.jobs
+	checkOptions.FailFast = options.failFast
+	checkOptions.CaptureOutputContext = options.captureOutputContext
+	// stdout is reserved for the report.
+	checkOptions.TestOutput = options.stderr
+	if !options.writeBaseline {
+		checkOptions.BaselineFile = options.baselinePath
+	}
+	// Exported reports include hit counts, which need --covermode=count.
+	if options.coberturaPath != "" || options.lcovPath != "" {
+		checkOptions.CoverMode = "count"
+	}
+	return coveragecheck.Check(checkOptions)
+}
+
 // realMain contains all the high level logic for the application, but in a
 // testable function.  It takes Options created by newOptions(), returns a
 // slice of strings to be output to stdout, a slice of strings to be output to
@@ -648,7 +417,22 @@ func realMain(options Options) ([]string, []string, error) {
 	}
 
 	if options.outputExampleConfig {
-		return makeExampleConfig(), nil, nil
+		return []string{coveragecheck.ExampleConfig().String()}, nil, nil
+	}
+	if len(options.parsedArgs) > 0 {
+		command := showHistory
+		if options.parsedArgs[0] == diffCommand {
+			command = diffRevisions
+		}
+		stdout, err := command(options)
+		return stdout, nil, err
+	}
+	if options.serveAddr != "" {
+		addr, err := localServeAddr(options.serveAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		options.serveAddr = addr
 	}
 
 	modBytes, err := os.ReadFile(options.goMod)
@@ -661,39 +445,75 @@ func realMain(options Options) ([]string, []string, error) {
 		// Don't require an existing config when generating one.
 		options.configFile = os.DevNull
 	}
-	configBytes, err := os.ReadFile(options.configFile)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed reading config %v: %w", options.configFile, err)
-	}
-	config, err := parseYAMLConfig(configBytes)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed parsing config %v: %w", options.configFile, err)
+	if options.watch {
+		return nil, nil, watch(options)
 	}
-
-	fInfoMap, err := makeFunctionInfoMap(options)
+	run, err := runCheck(options)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed parsing code: %w", err)
+		return nil, nil, err
 	}
-
-	rawCoverage, htmlPath, err := goCover(options)
-	if err != nil {
+	if err := exportCoverage(options, run.Coverage, run.Functions, run.Blocks); err != nil {
 		return nil, nil, err
 	}
-	parsedCoverage, err := parseCoverageOutput(options, rawCoverage)
-	if err != nil {
+
+	if err := writeBadge(options, run.Config, run.Blocks); err != nil {
 		return nil, nil, err
 	}
 
 	if options.generateConfig {
-		newConfig := generateConfig(parsedCoverage, fInfoMap)
+		newConfig := coveragecheck.GenerateConfig(run.Coverage, run.Functions)
 		return []string{newConfig.String()}, nil, nil
 	}
+	if options.writeBaseline {
+		baseline := coveragecheck.MakeBaseline(run.Results)
+		if err := options.writeFile(options.baselinePath, []byte(baseline.String()), 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed writing baseline to %v: %w", options.baselinePath, err)
+		}
+		return nil, nil, nil
+	}
 
-	debugInfo, err := checkCoverage(config, parsedCoverage, fInfoMap)
+	results, blocks := run.Results, run.Blocks
+	err = coveragecheck.ViolationsError(results)
+	htmlPath, htmlErr := htmlReport(options, results, blocks)
+	if htmlErr != nil {
+		return nil, nil, htmlErr
+	}
+	if err := writeReports(options, run); err != nil {
+		return nil, nil, err
+	}
+	if err := recordHistory(options, run); err != nil {
+		return nil, nil, err
+	}
+	if options.serveAddr != "" {
+		return nil, nil, serveReport(options, run)
+	}
 	if options.debugMatching {
-		return debugInfo, nil, err
+		return coveragecheck.DebugInfo(results), nil, err
+	}
+	if options.format == formatGitHub {
+		if summaryPath := options.getenv(githubSummaryEnvVar); summaryPath != "" {
+			summary := makeGitHubSummary(results, blocks)
+			if writeErr := options.appendFile(summaryPath, []byte(summary)); writeErr != nil {
+				return nil, nil, fmt.Errorf("failed writing job summary to %v: %w", summaryPath, writeErr)
+			}
+		}
+	}
+	if options.format != formatText {
+		return []string{newReporter(options, options.format).Report(run)}, nil, err
+	}
+	// Warnings don't cause failure, so they are output to stdout.
+	stdout := htmlPath
+	for _, result := range results {
+		if result.Severity == coveragecheck.SeverityWarning {
+			for _, message := range result.Messages() {
+				stdout = append(stdout, "warning: "+message)
+			}
+		}
+		for _, warning := range result.Warnings {
+			stdout = append(stdout, "warning: "+warning)
+		}
 	}
-	return htmlPath, nil, err
+	return stdout, nil, err
 }
 
 // runAndPrint takes Options and a function to run, runs the function, prints
@@ -16,12 +16,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -29,972 +31,289 @@ import (
 func newTestOptions() Options {
 	options := newOptions()
 	options.rawArgs = []string{}
+	// validCoverageOutput() reports coverage for the functions in this
+	// directory rather than the real code, which changes too often.
+	options.dirToParse = "testdata/parse"
 	options.captureOutput = func(string, ...string) ([]string, error) {
 		panic("captureOutput was called without being set by the test")
 	}
-	return options
-}
-
-func TestMakeExampleConfig(t *testing.T) {
-	expected := strings.Split(strings.TrimLeft(strings.ReplaceAll(`
-comment: Comment is not interpreted or used; it is provided as a structured way of
-	adding comments to a config, so that automated editing is easier.
-default_coverage: 80
-rules:
-- comment: Low coverage is acceptable for main()
-	filename_regex: ""
-	function_regex: ^main$
-	receiver_regex: ""
-	coverage: 50
-- comment: All the fooOrDie() functions should be fully tested because they panic()
-		on failure
-	filename_regex: ""
-	function_regex: OrDie$
-	receiver_regex: ""
-	coverage: 100
-- comment: Improve test coverage for parse_json.go?
-	filename_regex: ^parse_json.go$
-	function_regex: ""
-	receiver_regex: ""
-	coverage: 73
-- comment: Full coverage for other parsers
-	filename_regex: ^parse.*.go$
-	function_regex: ""
-	receiver_regex: ""
-	coverage: 100
-- comment: Url.String() has low coverage
-	filename_regex: ^urls.go$
-	function_regex: ^String$
-	receiver_regex: ^Url$
-	coverage: 56
-- comment: String() everywhere else should have high coverage
-	filename_regex: ""
-	function_regex: ^String$
-	receiver_regex: ""
-	coverage: 100
-`, "\t", "  "), "\n"), "\n")
-	actual := strings.Split(makeExampleConfig()[0], "\n")
-	assert.Equal(t, expected, actual)
-}
-
-func TestGenerateConfig(t *testing.T) {
-	coverage := []CoverageLine{
-		{
-			Filename:   "test.go",
-			LineNumber: "1",
-			Function:   "func1",
-			Coverage:   20.0,
-		},
-		{
-			Filename:   "test.go",
-			LineNumber: "2",
-			Function:   "func5",
-			Coverage:   34.0,
-		},
-		{
-			Filename:   "test.go",
-			LineNumber: "9",
-			Function:   "func17",
-			Coverage:   12.3,
-		},
-	}
-
-	fim := FunctionInfoMap{
-		"test.go:9": {
-			Filename:   "test.go",
-			LineNumber: "9",
-			Function:   "func17",
-			Receiver:   "receiver-receiver-receiver",
-		},
-	}
-
-	expected := Config{
-		DefaultCoverage: 100.0,
-		Rules: []Rule{
-			{
-				FilenameRegex: "^test.go$",
-				FunctionRegex: "^func1$",
-				ReceiverRegex: "^$",
-				Comment:       "Generated rule for func1, found at test.go:1",
-				Coverage:      20.0,
-			},
-			{
-				FilenameRegex: "^test.go$",
-				FunctionRegex: "^func5$",
-				ReceiverRegex: "^$",
-				Comment:       "Generated rule for func5, found at test.go:2",
-				Coverage:      34.0,
-			},
-			{
-				FilenameRegex: "^test.go$",
-				FunctionRegex: "^func17$",
-				ReceiverRegex: "^receiver-receiver-receiver$",
-				Comment:       "Generated rule for func17, found at test.go:9",
-				Coverage:      12.3,
-			},
-		},
-	}
-
-	generated := generateConfig(coverage, fim)
-	assert.Equal(t, expected, generated)
-}
-
-func TestValidateConfigErrors(t *testing.T) {
-	table := []struct {
-		config Config
-		err    string
-	}{
-		{
-			err: "default coverage (101.0) is outside the range 0-100",
-			config: Config{
-				DefaultCoverage: 101,
-			},
-		},
-		{
-			err: "default coverage (-1.0) is outside the range 0-100",
-			config: Config{
-				DefaultCoverage: -1,
-			},
-		},
-		{
-			err: "coverage (1234.0) is outside the range 0-100 in",
-			config: Config{
-				DefaultCoverage: 99,
-				Rules: []Rule{
-					{
-						FilenameRegex: "asdf",
-						Coverage:      1234,
-					},
-				},
-			},
-		},
-		{
-			err: "coverage (-1.0) is outside the range 0-100 in",
-			config: Config{
-				DefaultCoverage: 99,
-				Rules: []Rule{
-					{
-						FilenameRegex: "asdf",
-						Coverage:      -1,
-					},
-				},
-			},
-		},
-		{
-			err: "every regex is an empty string in rule",
-			config: Config{
-				DefaultCoverage: 99,
-				Rules: []Rule{
-					{
-						Coverage: 1,
-					},
-				},
-			},
-		},
-	}
-	for _, test := range table {
-		_, err := validateConfig(test.config)
-		// Note: the error message seems mangled when it's printed here, but it's
-		// fine when printed for real.  I don't understand why and an hour of
-		// debugging has gotten me nowhere :(
-		assert.ErrorContains(t, err, test.err, test.config)
-	}
-}
-
-func TestValidateConfigSuccess(t *testing.T) {
-	config := Config{
-		Comment:         "successful test",
-		DefaultCoverage: 75.0,
-		Rules: []Rule{
-			{
-				Comment:       "successful rule",
-				FilenameRegex: "foo",
-				FunctionRegex: "bar",
-				ReceiverRegex: "baz",
-				Coverage:      7.0,
-			},
-		},
-	}
-	config, err := validateConfig(config)
-	assert.Nil(t, err)
-	assert.Equal(t, 75.0, config.DefaultCoverage)
-	assert.Equal(t, 1, len(config.Rules))
-	rule := config.Rules[0]
-	assert.Equal(t, "foo", rule.FilenameRegex)
-	assert.Equal(t, "bar", rule.FunctionRegex)
-	assert.Equal(t, "baz", rule.ReceiverRegex)
-	assert.NotNil(t, rule.compiledFilenameRegex)
-	assert.NotNil(t, rule.compiledFunctionRegex)
-	assert.NotNil(t, rule.compiledReceiverRegex)
-}
-
-func TestParseYAMLConfig_UnmarshalError(t *testing.T) {
-	_, err := parseYAMLConfig([]byte("asdf"))
-	assert.ErrorContains(t, err, "failed parsing YAML: yaml: unmarshal errors")
-}
-
-func TestParseYAMLConfigSuccess(t *testing.T) {
-	config, err := parseYAMLConfig([]byte(""))
-	assert.Nil(t, err)
-	assert.Equal(t, 0.0, config.DefaultCoverage)
-
-	yml := `
-default_coverage: 75
-rules:
-	- function_regex: pinky
-		coverage: 20
-		comment: nobody understands pinky
-	- function_regex: the brain
-		coverage: 90
-		comment: the brain thinks he's understood
-	- filename_regex: main.go
-		coverage: 50
-	- filename_regex: utils.go
-		coverage: 95
-`
-	yml = strings.ReplaceAll(yml, "\t", "  ")
-	config, err = parseYAMLConfig([]byte(yml))
-	assert.Nil(t, err)
-	assert.Equal(t, 75.0, config.DefaultCoverage)
-	assert.Equal(t, 4, len(config.Rules))
-	assert.Equal(t, "pinky", config.Rules[0].FunctionRegex)
-}
-
-func TestMakeFunctionInfoMapFailure(t *testing.T) {
-	options := newTestOptions()
-	options.dirToParse = "does-not-exist"
-	_, err := makeFunctionInfoMap(options)
-	assert.Error(t, err)
-}
-
-func TestMakeFunctionInfoMapSuccess(t *testing.T) {
-	fmap, err := makeFunctionInfoMap(newTestOptions())
-	assert.Nil(t, err)
-	fis := []FunctionInfo{
-		{
-			Filename:   "functions-for-testing-makeFunctionInfoMap.go",
-			LineNumber: "20",
-			Function:   "functionAtLine20",
-			Receiver:   "",
-		},
-		{
-			Filename:   "functions-for-testing-makeFunctionInfoMap.go",
-			LineNumber: "26",
-			Function:   "String",
-			Receiver:   "methodReceiver",
-		},
-	}
-	for _, fi := range fis {
-		key := fi.key()
-		if assert.Contains(t, fmap, key) {
-			assert.Equal(t, fi, fmap[key])
-		}
-	}
-}
-
-func TestMakeFunctionInfoMapSupport(t *testing.T) {
-	// Test that the functions in functions-for-testing-makeFunctionInfoMap.go
-	// are correct so that other tests have known good data to work with.
-	assert.Equal(t, "This function is at line 20 to test makeFunctionInfoMap()", functionAtLine20())
-	mr := methodReceiver{}
-	assert.Equal(t, "This method has a methodReceiver receiver to test makeFunctionInfoMap()", mr.String())
-}
-
-func TestCaptureOutput(t *testing.T) {
-	output, err := captureOutput("cat", "/non-existent")
-	assert.Nil(t, output)
-	assert.ErrorContains(t, err, "cat: /non-existent: No such file or directory")
-
-	output, err = captureOutput("cat", "/etc/passwd")
-	assert.Nil(t, err)
-	rootLines := []string{}
-	for _, line := range output {
-		if strings.HasPrefix(line, "root:") {
-			rootLines = append(rootLines, line)
-		}
+	// Don't write to the job summary when tests run in GitHub Actions.
+	options.getenv = func(string) string {
+		return ""
 	}
-	assert.Len(t, rootLines, 1)
-}
-
-func TestReadLineWithRetry_Success(t *testing.T) {
-	file, err := os.Open("go.mod")
-	assert.Nil(t, err)
-	line, err := readLineWithRetry(file)
-	assert.Nil(t, err)
-	assert.Equal(t, "module github.com/tobinjt/golang-coverage-check\n", line)
-}
-
-func TestReadLineWithRetry_EOF(t *testing.T) {
-	file, err := os.CreateTemp("", "TestReadLineWithRetry_EOF.*")
-	assert.Nil(t, err)
-	strCh := make(chan string)
-	errCh := make(chan error)
-	runMe := func() {
-		line, err := readLineWithRetry(file)
-		strCh <- line
-		errCh <- err
+	options.appendFile = func(string, []byte) error {
+		panic("appendFile was called without being set by the test")
 	}
-	go runMe()
-
-	time.Sleep(3 * sleepTime)
-	length, err := file.WriteString("written to temp file\n")
-	assert.Nil(t, err)
-	assert.Greater(t, length, 10)
-	// Sync and seek back to the start so that the read in the other goroutine
-	// can complete.
-	err = file.Sync()
-	assert.Nil(t, err)
-	offset, err := file.Seek(0, 0)
-	assert.Nil(t, err)
-	assert.Equal(t, int64(0), offset)
-
-	line, err := <-strCh, <-errCh
-	assert.Nil(t, err)
-	assert.Equal(t, "written to temp file\n", line)
-}
-
-func TestReadLineWithRetry_Error(t *testing.T) {
-	file, err := os.CreateTemp("", "TestReadLineWithRetry_Error.*")
-	assert.Nil(t, err)
-	strCh := make(chan string)
-	errCh := make(chan error)
-	runMe := func() {
-		line, err := readLineWithRetry(file)
-		strCh <- line
-		errCh <- err
+	options.writeFile = func(string, []byte, os.FileMode) error {
+		panic("writeFile was called without being set by the test")
 	}
-	go runMe()
-	time.Sleep(3 * sleepTime)
-	file.Close()
-	line, err := <-strCh, <-errCh
-	assert.ErrorContains(t, err, "file already closed")
-	assert.Equal(t, "", line)
-}
-
-func TestGoCoverCapturePathSuccess(t *testing.T) {
-	options := newTestOptions()
-	options.captureOutput = captureOutput
-	coverageFile, err := options.createTemp("", "golang-coverage-check.*.coverage-data")
-	assert.Nil(t, err)
-	path, err := goCoverCapturePath(options, coverageFile.Name())
-	assert.Nil(t, err)
-	assert.Equal(t, 1, len(path))
-}
-
-func TestGoCoverCapturePath_CreateTempFailed(t *testing.T) {
-	options := newTestOptions()
-	options.createTemp = func(string, string) (*os.File, error) {
-		return nil, errors.New("createTemp failed")
+	options.mkdirTemp = func(string, string) (string, error) {
+		panic("mkdirTemp was called without being set by the test")
 	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Error(t, err)
-	assert.Nil(t, path)
-}
-
-func TestGoCoverCapturePath_CreateTempFailedSecondTime(t *testing.T) {
-	options := newTestOptions()
-	calledBefore := false
-	options.createTemp = func(dir, pattern string) (*os.File, error) {
-		if calledBefore {
-			return nil, errors.New("createTemp failed on second call")
-		}
-		calledBefore = true
-		return os.CreateTemp(dir, pattern)
+	options.listenAndServe = func(string, http.Handler) error {
+		panic("listenAndServe was called without being set by the test")
 	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Nil(t, path)
-	assert.ErrorContains(t, err, "createTemp failed on second call")
-}
-
-func TestGoCoverCapturePath_ReadingFileFailed(t *testing.T) {
-	options := newTestOptions()
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		return nil, nil
+	options.captureOutputInDir = func(string) func(string, ...string) ([]string, error) {
+		panic("captureOutputInDir was called without being set by the test")
 	}
-	options.readLineWithRetry = func(*os.File) (string, error) {
-		return "", errors.New("readLineWithRetry failed")
+	options.captureOutputContext = func(context.Context, string, ...string) ([]string, error) {
+		panic("captureOutputContext was called without being set by the test")
 	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Nil(t, path)
-	assert.ErrorContains(t, err, "readLineWithRetry failed")
-}
-
-func TestGoCoverCapturePath_ChmodFailed(t *testing.T) {
-	options := newTestOptions()
-	options.chmod = func(*os.File, os.FileMode) error {
-		return errors.New("chmod failed")
-	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Nil(t, path)
-	assert.ErrorContains(t, err, "chmod failed")
-}
-
-func TestGoCoverCapturePath_SetenvFailed(t *testing.T) {
-	options := newTestOptions()
-	options.setenv = func(string, string) error {
-		return errors.New("setenv failed")
-	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Nil(t, path)
-	assert.ErrorContains(t, err, "setenv failed")
-}
-
-func TestGoCoverCapturePath_CaptureOutputFailed(t *testing.T) {
-	options := newTestOptions()
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		return nil, errors.New("captureOutput failed")
-	}
-	path, err := goCoverCapturePath(options, "")
-	assert.Nil(t, path)
-	assert.ErrorContains(t, err, "captureOutput failed")
-}
-
-func TestGoCoverSuccess(t *testing.T) {
-	fakeOutput := map[string][]string{
-		"test --covermode set --coverprofile": {"ignored"},
-		"tool cover --func":                   {"expected return value"},
-	}
-	commandRun := map[string]bool{}
-	options := newTestOptions()
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		// The random filename is always the last arg, so drop it.
-		parts := args[0 : len(args)-1]
-		key := strings.Join(parts, " ")
-		commandRun[key] = true
-		return fakeOutput[key], nil
-	}
-	actual, _, err := goCover(options)
-	assert.Nil(t, err)
-	assert.Equal(t, []string{"expected return value"}, actual)
-	assert.Equal(t, len(commandRun), 2)
-	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
-	assert.True(t, commandRun["tool cover --func"], commandRun)
-}
-
-func TestGoCoverBrowserFailure(t *testing.T) {
-	fakeOutput := map[string][]string{
-		"test --covermode set --coverprofile": {"ignored"},
-	}
-	fakeErrors := map[string]error{
-		"tool cover --html": fmt.Errorf("browser error"),
-	}
-	commandRun := map[string]bool{}
-	options := newTestOptions()
-	options.coverageHTML = htmlOpenInBrowser
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		// The random filename is always the last arg, so drop it.
-		parts := args[0 : len(args)-1]
-		key := strings.Join(parts, " ")
-		commandRun[key] = true
-		return fakeOutput[key], fakeErrors[key]
-	}
-
-	actual, _, err := goCover(options)
-	assert.Error(t, err)
-	assert.Nil(t, actual)
-	assert.Equal(t, 2, len(commandRun), commandRun)
-	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
-	assert.True(t, commandRun["tool cover --html"], commandRun)
-}
-
-func TestGoCoverBrowser(t *testing.T) {
-	fakeOutput := map[string][]string{
-		"test --covermode set --coverprofile": {"ignored"},
-		"tool cover --func":                   {"expected return value"},
-		"tool cover --html":                   {"ignored"},
-	}
-	commandRun := map[string]bool{}
-	options := newTestOptions()
-	options.coverageHTML = htmlOpenInBrowser
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		// The random filename is always the last arg, so drop it.
-		parts := args[0 : len(args)-1]
-		key := strings.Join(parts, " ")
-		commandRun[key] = true
-		return fakeOutput[key], nil
-	}
-
-	actual, _, err := goCover(options)
-	assert.Nil(t, err)
-	assert.Equal(t, []string{"expected return value"}, actual)
-	assert.Equal(t, 3, len(commandRun), commandRun)
-	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
-	assert.True(t, commandRun["tool cover --func"], commandRun)
-	assert.True(t, commandRun["tool cover --html"], commandRun)
-}
-
-func TestGoCoverPathFailure(t *testing.T) {
-	options := newTestOptions()
-	options.coverageHTML = htmlShowPath
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		return nil, nil
-	}
-	options.readLineWithRetry = func(*os.File) (string, error) {
-		return "", errors.New("readLineWithRetry failed")
-	}
-
-	_, path, err := goCover(options)
-	assert.ErrorContains(t, err, "readLineWithRetry failed")
-	assert.Nil(t, path)
-}
-
-func TestGoCoverPath(t *testing.T) {
-	fakeOutput := map[string][]string{
-		"test --covermode set --coverprofile": {"ignored"},
-		"tool cover --func":                   {"expected return value"},
-		"tool cover --html":                   {"ignored"},
-	}
-	commandRun := map[string]bool{}
-	options := newTestOptions()
-	options.coverageHTML = htmlShowPath
-	options.captureOutput = func(command string, args ...string) ([]string, error) {
-		// The random filename is always the last arg, so drop it.
-		parts := args[0 : len(args)-1]
-		key := strings.Join(parts, " ")
-		commandRun[key] = true
-		return fakeOutput[key], nil
-	}
-	options.readLineWithRetry = func(*os.File) (string, error) {
-		return "this is the fake path", nil
-	}
-
-	_, path, err := goCover(options)
-	assert.Nil(t, err)
-	assert.Equal(t, []string{"this is the fake path"}, path)
-	assert.Equal(t, 3, len(commandRun), commandRun)
-	assert.True(t, commandRun["test --covermode set --coverprofile"], commandRun)
-	assert.True(t, commandRun["tool cover --func"], commandRun)
-	assert.True(t, commandRun["tool cover --html"], commandRun)
+	return options
 }
 
-func TestGoCoverCaptureFailure(t *testing.T) {
-	options := newTestOptions()
-	options.captureOutput = func(string, ...string) ([]string, error) {
-		return []string{"this should not be seen"}, errors.New("error for testing")
-	}
-	actual, _, err := goCover(options)
-	assert.Nil(t, actual)
-	assert.ErrorContains(t, err, "error for testing")
+func boolPointer(b bool) *bool {
+	return &b
 }
 
-func TestGoCoverCreateTempFailure(t *testing.T) {
-	options := newTestOptions()
-	options.createTemp = func(string, string) (*os.File, error) {
-		return nil, errors.New("error for testing")
-	}
-	actual, _, err := goCover(options)
-	assert.Nil(t, actual)
-	assert.ErrorContains(t, err, "error for testing")
+func floatPointer(f float64) *float64 {
+	return &f
 }
 
+// validCoverageOutput returns coverage for the functions in
+// testdata/parse/golang-coverage-check.go.
 func validCoverageOutput() []string {
 	coverage := `
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:26:		String			100.0%
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:48:		String			31.0%
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:53:		makeExampleConfig	50.0%
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:95:		parseYAMLConfig		100.0%
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:118:	realMain		17.3%
-github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:140:	main			0.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:22:		String			100.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:26:		String			31.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:30:		makeExampleConfig	50.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:34:		parseYAMLConfig		100.0%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:38:	realMain		17.3%
+github.com/tobinjt/golang-coverage-check/golang-coverage-check.go:42:	main			0.0%
 total:											(statements)		38.1%
 `
 	return strings.Split(coverage, "\n")
 }
 
-func TestParseCoverageOutputSuccess(t *testing.T) {
+func TestRunCheckJobs(t *testing.T) {
+	stderr := new(bytes.Buffer)
 	options := newTestOptions()
 	options.modulePath = "github.com/tobinjt/golang-coverage-check/"
-	results, err := parseCoverageOutput(options, validCoverageOutput())
-	assert.Nil(t, err)
-	assert.Equal(t, 6, len(results))
-
-	expected := []CoverageLine{
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "26",
-			Function:   "String",
-			Coverage:   100.0,
-		},
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "48",
-			Function:   "String",
-			Coverage:   31.0,
-		},
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "53",
-			Function:   "makeExampleConfig",
-			Coverage:   50.0,
-		},
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "95",
-			Function:   "parseYAMLConfig",
-			Coverage:   100.0,
-		},
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "118",
-			Function:   "realMain",
-			Coverage:   17.3,
-		},
-		{
-			Filename:   "golang-coverage-check.go",
-			LineNumber: "140",
-			Function:   "main",
-			Coverage:   0.0,
-		},
+	options.crossPackageTests = true
+	options.jobs = 2
+	options.stderr = stderr
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		if args[0] == "list" {
+			return []string{"github.com/tobinjt/golang-coverage-check", "github.com/tobinjt/golang-coverage-check/sub", ""}, nil
+		}
+		return validCoverageOutput(), nil
+	}
+	options.captureOutputContext = func(ctx context.Context, command string, args ...string) ([]string, error) {
+		// The package is before --coverprofile.
+		return []string{"ok  \t" + args[len(args)-3], ""}, nil
 	}
-	assert.Equal(t, expected, results)
+	_, err := runCheck(options)
+	assert.Nil(t, err)
+	// The output of each package is written to stderr, in package order.
+	assert.Equal(t, "ok  \tgithub.com/tobinjt/golang-coverage-check\nok  \tgithub.com/tobinjt/golang-coverage-check/sub\n", stderr.String())
 }
 
-func TestParseCoverageOutputFailure(t *testing.T) {
-	options := newTestOptions()
-
-	badInputLine := `
-github.com/.../golang-coverage-check.go:26:		String			100.0%
-asdf
-github.com/.../golang-coverage-check.go:140:	main			0.0%
-total:											(statements)		38.1%
-`
-	_, err := parseCoverageOutput(options, strings.Split(badInputLine, "\n"))
-	assert.ErrorContains(t, err, "expected 3 parts, found 1")
-
-	badInputLine = `missing-line-number:		String			100.0%`
-	_, err = parseCoverageOutput(options, []string{badInputLine})
-	assert.ErrorContains(t, err, "expected `filename:linenumber:` in \"missing-line-number:\"")
-
+func TestRunCheck(t *testing.T) {
 	table := []struct {
-		input string
-		err   string
+		desc     string
+		mod      func(Options) Options
+		err      string
+		commands []string
 	}{
 		{
-			err:   "could not extract percentage from",
-			input: "1.2",
+			desc:     "defaults",
+			mod:      func(opts Options) Options { return opts },
+			commands: []string{"test --covermode set --coverprofile", "tool cover --func"},
 		},
 		{
-			err:   "could not extract percentage from",
-			input: "qwerty",
+			desc: "cobertura needs counts",
+			mod: func(opts Options) Options {
+				opts.coberturaPath = "coverage.xml"
+				return opts
+			},
+			commands: []string{"test --covermode count --coverprofile", "tool cover --func"},
 		},
 		{
-			err:   "strconv.ParseFloat: parsing",
-			input: "asdf%",
+			desc: "lcov needs counts",
+			mod: func(opts Options) Options {
+				opts.lcovPath = "coverage.lcov"
+				return opts
+			},
+			commands: []string{"test --covermode count --coverprofile", "tool cover --func"},
 		},
 		{
-			err:   "percentage (-12.2) < 0",
-			input: "-12.2%",
+			desc: "cross package tests",
+			mod: func(opts Options) Options {
+				opts.crossPackageTests = true
+				return opts
+			},
+			commands: []string{"test --covermode set --coverpkg . github.com/tobinjt/golang-coverage-check/... --coverprofile", "tool cover --func"},
 		},
 		{
-			err:   "percentage (105.3) > 100",
-			input: "105.3%",
+			desc: "cross package tests in parallel",
+			mod: func(opts Options) Options {
+				opts.crossPackageTests = true
+				opts.jobs = 2
+				opts.failFast = true
+				return opts
+			},
+			commands: []string{"list", "tool cover --func"},
+		},
+		{
+			desc: "parsing code fails",
+			mod: func(opts Options) Options {
+				opts.dirToParse = "does-not-exist"
+				return opts
+			},
+			err: "failed parsing code: ",
 		},
 	}
 	for _, test := range table {
-		input := fmt.Sprintf("foo.go:26:		String			%s", test.input)
-		_, err := parseCoverageOutput(options, []string{input})
-		assert.ErrorContains(t, err, test.err)
-	}
-}
-
-func stripComments(input []string) []string {
-	output := []string{}
-	for _, line := range input {
-		if !strings.HasPrefix(line, "//") {
-			output = append(output, line)
+		commands := []string{}
+		options := newTestOptions()
+		options.modulePath = "github.com/tobinjt/golang-coverage-check/"
+		options.captureOutput = func(command string, args ...string) ([]string, error) {
+			// The random filename is always the last arg, so drop it.
+			commands = append(commands, strings.Join(args[0:len(args)-1], " "))
+			if args[len(args)-2] == "--func" {
+				return validCoverageOutput(), nil
+			}
+			return nil, nil
 		}
+		run, err := runCheck(test.mod(options))
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.desc)
+			continue
+		}
+		assert.Nil(t, err, test.desc)
+		assert.Equal(t, test.commands, commands, test.desc)
+		assert.Equal(t, len(validCoverageOutput())-3, len(run.Results), test.desc)
 	}
-	return output
 }
 
-func TestCheckCoverage(t *testing.T) {
-	tests := []struct {
-		desc     string
-		config   Config
-		fInfoMap FunctionInfoMap
-		input    []string
-		errors   []string
-		debug    []string
+func TestValidateFlags(t *testing.T) {
+	table := []struct {
+		desc string
+		err  string
+		mod  func(opts Options) Options
 	}{
-
 		{
-			desc: "Filename matching",
-			config: Config{
-				Rules: []Rule{
-					{
-						FilenameRegex: "^utils.go$",
-						Coverage:      100,
-					},
-				},
-			},
-			input: []string{
-				"// Matches, insufficient coverage.",
-				"utils.go:1:	ReadFileOrDie	57.0%",
-				"// Matches, sufficient coverage.",
-				"utils.go:2:	ParseIntOrDie	100.0%",
-				"// Doesn't match, falls through to default.",
-				"main.go:1:	main	22.0%",
-			},
-			errors: []string{
-				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
-				"matching rule is `FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100 Comment: `",
-			},
-			debug: []string{
-				// First coverage line.
-				"Debug info for coverage matching",
-				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
-				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100",
-				"actual coverage 57.0% < required coverage 100.0%",
-				// Second coverage line.
-				"Line utils.go:2:\tParseIntOrDie\t100.0%",
-				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex:  ReceiverRegex:  Coverage: 100 Comment:",
-				"actual coverage 100.0% >= required coverage 100.0%",
-				// Third coverage line.
-				"Line main.go:1:\tmain\t22.0%",
-				"Default coverage 0.0% satisfied",
+			desc: "good arguments",
+			err:  "",
+			mod: func(opts Options) Options {
+				return opts
 			},
 		},
-
 		{
-			desc: "Function matching",
-			config: Config{
-				Rules: []Rule{
-					{
-						FunctionRegex: "OrDie$",
-						Coverage:      100,
-					},
-				},
-			},
-			input: []string{
-				"// Matches, insufficient coverage.",
-				"utils.go:1:	ReadFileOrDie	57.0%",
-				"// Matches, sufficient coverage.",
-				"utils.go:2:	ParseIntOrDie	100.0%",
-				"// Doesn't match, falls through to default.",
-				"main.go:1:	main	100.0%",
-			},
-			errors: []string{
-				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
-				"matching rule is `FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100 Comment: `",
-			},
-			debug: []string{
-				// First coverage line.
-				"Debug info for coverage matching",
-				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
-				"Matching rule: FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100",
-				"actual coverage 57.0% < required coverage 100.0%",
-				// Second coverage line.
-				"Line utils.go:2:\tParseIntOrDie\t100.0%",
-				"Matching rule: FilenameRegex:  FunctionRegex: OrDie$ ReceiverRegex:  Coverage: 100 Comment:",
-				"actual coverage 100.0% >= required coverage 100.0%",
-				// Third coverage line.
-				"Line main.go:1:\tmain\t100.0%",
-				"Default coverage 0.0% satisfied",
+			desc: "bad argument to --coverage_html",
+			err:  "unrecognised option for flag --coverage_html: \"rejected\"; valid options are an empty string, \"browser\", or \"path\"",
+			mod: func(opts Options) Options {
+				opts.coverageHTML = "rejected"
+				return opts
 			},
 		},
-
 		{
-			desc: "Receiver matching",
-			config: Config{
-				Rules: []Rule{
-					{
-						ReceiverRegex: "^testReceiver$",
-						Coverage:      100,
-					},
-				},
-			},
-			input: []string{
-				"// Matches, insufficient coverage.",
-				"utils.go:1:	Commit	57.0%",
-				"// Matches, sufficient coverage.",
-				"utils.go:2:	String	100.0%",
-				"// Doesn't match, falls through to default.",
-				"main.go:1:	main	100.0%",
-			},
-			fInfoMap: FunctionInfoMap{
-				"utils.go:1": {
-					Filename:   "utils.go",
-					LineNumber: "1",
-					Function:   "Commit",
-					Receiver:   "testReceiver",
-				},
-				"utils.go:2": {
-					Filename:   "utils.go",
-					LineNumber: "2",
-					Function:   "String",
-					Receiver:   "testReceiver",
-				},
-			},
-			errors: []string{
-				"utils.go:1:\tCommit\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
-				"matching rule is `FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100 Comment: `",
-			},
-			debug: []string{
-				// First coverage line.
-				"Debug info for coverage matching",
-				"Line utils.go:1:\tCommit\t57.0%\n",
-				"Matching rule: FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100",
-				"actual coverage 57.0% < required coverage 100.0%",
-				// Second coverage line.
-				"Line utils.go:2:\tString\t100.0%",
-				"Matching rule: FilenameRegex:  FunctionRegex:  ReceiverRegex: ^testReceiver$ Coverage: 100",
-				"actual coverage 100.0% >= required coverage 100.0%",
-				// Third coverage line.
-				"Line main.go:1:\tmain\t100.0%",
-				"Default coverage 0.0% satisfied",
+			desc: "unexpected arguments",
+			err:  "unexpected arguments",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{"asdf", "1234"}
+				return opts
 			},
 		},
-
 		{
-			desc: "Filename, Function, and Receiver matching",
-			config: Config{
-				Rules: []Rule{
-					{
-						FilenameRegex: "^utils.go$",
-						FunctionRegex: "^Commit$",
-						ReceiverRegex: "^testReceiver$",
-						Coverage:      100,
-					},
-					{
-						FilenameRegex: "^utils.go$",
-						FunctionRegex: "^String$",
-						ReceiverRegex: "^testReceiver$",
-						Coverage:      100,
-					},
-				},
-			},
-			input: []string{
-				"// Matches, insufficient coverage.",
-				"utils.go:1:	Commit	57.0%",
-				"// Matches, sufficient coverage.",
-				"utils.go:2:	String	100.0%",
-				"// Doesn't match, falls through to default.",
-				"main.go:1:	main	100.0%",
-			},
-			fInfoMap: FunctionInfoMap{
-				"utils.go:1": {
-					Filename:   "utils.go",
-					LineNumber: "1",
-					Function:   "Commit",
-					Receiver:   "testReceiver",
-				},
-				"utils.go:2": {
-					Filename:   "utils.go",
-					LineNumber: "2",
-					Function:   "String",
-					Receiver:   "testReceiver",
-				},
-			},
-			errors: []string{
-				"utils.go:1:\tCommit\t57.0%: actual coverage 57.0% < required coverage 100.0%: matching rule",
-				"matching rule is `FilenameRegex: ^utils.go$ FunctionRegex: ^Commit$ ReceiverRegex: ^testReceiver$ Coverage: 100",
-			},
-			debug: []string{
-				// First coverage line.
-				"Debug info for coverage matching",
-				"Line utils.go:1:\tCommit\t57.0%\n",
-				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex: ^Commit$ ReceiverRegex: ^testReceiver$ Coverage: 100",
-				"actual coverage 57.0% < required coverage 100.0%",
-				// Second coverage line.
-				"Line utils.go:2:\tString\t100.0%",
-				"Matching rule: FilenameRegex: ^utils.go$ FunctionRegex: ^String$ ReceiverRegex: ^testReceiver$ Coverage: 100",
-				"actual coverage 100.0% >= required coverage 100.0%",
-				// Third coverage line.
-				"Line main.go:1:\tmain\t100.0%",
-				"Default coverage 0.0% satisfied",
+			desc: "history command",
+			err:  "",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{historyCommand, "1", "2"}
+				opts.historyPath = "history.jsonl"
+				return opts
 			},
 		},
-
 		{
-			desc: "Default coverage",
-			config: Config{
-				DefaultCoverage: 90,
-			},
-			input: []string{
-				"// Insufficient coverage.",
-				"utils.go:1:	ReadFileOrDie	57.0%",
-				"// Sufficient coverage.",
-				"utils.go:2:	ParseIntOrDie	100.0%",
-			},
-			errors: []string{
-				"utils.go:1:\tReadFileOrDie\t57.0%: actual coverage 57.0% < default coverage 90.0%",
+			desc: "history command with one entry",
+			err:  "unexpected arguments: [history 1]; the commands are \"history\"",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{historyCommand, "1"}
+				opts.historyPath = "history.jsonl"
+				return opts
 			},
-			debug: []string{
-				// First coverage line.
-				"Debug info for coverage matching",
-				"Line utils.go:1:\tReadFileOrDie\t57.0%\n",
-				"Default coverage 90.0% not satisfied",
-				// Second coverage line.
-				"Line utils.go:2:\tParseIntOrDie\t100.0%",
-				"Default coverage 90.0% satisfied",
+		},
+		{
+			desc: "diff command",
+			err:  "",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{diffCommand, "main"}
+				return opts
 			},
 		},
-
 		{
-			desc: "No errors",
-			config: Config{
-				DefaultCoverage: 90,
+			desc: "diff command without revisions",
+			err:  "unexpected arguments: [diff]; the commands are",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{diffCommand}
+				return opts
 			},
-			input: []string{
-				"// Sufficient coverage.",
-				"utils.go:2:	ParseIntOrDie	100.0%",
+		},
+		{
+			desc: "history command without --history",
+			err:  "the \"history\" command requires --history",
+			mod: func(opts Options) Options {
+				opts.parsedArgs = []string{historyCommand}
+				return opts
 			},
-			errors: []string{},
-			debug: []string{
-				// First coverage line.
-				"Line utils.go:2:\tParseIntOrDie\t100.0%",
-				"Default coverage 90.0% satisfied",
+		},
+		{
+			desc: "--write_baseline without --baseline",
+			err:  "--write_baseline requires --baseline",
+			mod: func(opts Options) Options {
+				opts.writeBaseline = true
+				return opts
+			},
+		},
+		{
+			desc: "negative --jobs",
+			err:  "--jobs (-1) must not be negative",
+			mod: func(opts Options) Options {
+				opts.jobs = -1
+				opts.crossPackageTests = true
+				return opts
 			},
 		},
-	}
-
-	options := newTestOptions()
-	for _, test := range tests {
-		coverage, err := parseCoverageOutput(options, stripComments(test.input))
-		assert.Nil(t, err)
-		config, err := validateConfig(test.config)
-		assert.Nil(t, err)
-
-		debug, err := checkCoverage(config, coverage, test.fInfoMap)
-		if len(test.errors) == 0 {
-			assert.Nil(t, err)
-		} else {
-			for i := range test.errors {
-				assert.ErrorContains(t, err, test.errors[i], "err: "+test.desc)
-			}
-		}
-		debugStr := strings.Join(debug, "\n")
-		for i := range test.debug {
-			assert.Contains(t, debugStr, test.debug[i], "debug: "+test.desc)
-		}
-	}
-}
-
-func TestValidateFlags(t *testing.T) {
-	table := []struct {
-		desc string
-		err  string
-		mod  func(opts Options) Options
-	}{
 		{
-			desc: "good arguments",
-			err:  "",
+			desc: "--jobs without --cross_package_tests",
+			err:  "--jobs requires --cross_package_tests",
 			mod: func(opts Options) Options {
+				opts.jobs = 4
 				return opts
 			},
 		},
 		{
-			desc: "bad argument to --coverage_html",
-			err:  "unrecognised option for flag --coverage_html: \"rejected\"; valid options are an empty string, \"browser\", or \"path\"",
+			desc: "--fail_fast without --jobs",
+			err:  "--fail_fast requires --jobs",
 			mod: func(opts Options) Options {
-				opts.coverageHTML = "rejected"
+				opts.failFast = true
+				opts.crossPackageTests = true
 				return opts
 			},
 		},
 		{
-			desc: "unexpected arguments",
-			err:  "unexpected arguments",
+			desc: "bad argument to --format",
+			err:  "unrecognised option for flag --format: \"xml\"; valid options are [\"text\" \"json\" \"sarif\" \"junit\" \"github\" \"markdown\"]",
 			mod: func(opts Options) Options {
-				opts.parsedArgs = []string{"asdf", "1234"}
+				opts.format = "xml"
+				return opts
+			},
+		},
+		{
+			desc: "--format and --debug_matching",
+			err:  "only one of --example_config, --generate_config",
+			mod: func(opts Options) Options {
+				opts.format = formatJSON
+				opts.debugMatching = true
+				return opts
+			},
+		},
+		{
+			desc: "--watch and --serve",
+			err:  "only one of --example_config, --generate_config",
+			mod: func(opts Options) Options {
+				opts.watch = true
+				opts.serveAddr = ":8080"
 				return opts
 			},
 		},
@@ -1030,7 +349,7 @@ func TestSetupFlagsAndUsage(t *testing.T) {
 	message := buffer.String()
 	assert.Contains(t, message,
 		"Only one of --example_config, --generate_config, --debug_matching")
-	assert.Contains(t, message, "set to \"path\" to output the path to the HTML")
+	assert.Contains(t, message, "set to \"path\" to write the HTML report to a new temporary directory")
 }
 
 func TestRealMain(t *testing.T) {
@@ -1052,7 +371,7 @@ func TestRealMain(t *testing.T) {
 		{
 			desc:   "generateConfig",
 			err:    "",
-			output: "Generated rule for parseYAMLConfig",
+			output: "Generated rule for github.com/tobinjt/golang-coverage-check.parseYAMLConfig",
 			mod: func(opts Options) Options {
 				opts.rawArgs = []string{"--generate_config"}
 				opts.captureOutput = func(string, ...string) ([]string, error) {
@@ -1128,7 +447,7 @@ func TestRealMain(t *testing.T) {
 			},
 		},
 		{
-			desc:   "parseCoverageOutput fails",
+			desc:   "ParseCoverageOutput fails",
 			err:    "expected 3 parts, found 1, in \"qwerty\"",
 			output: "",
 			mod: func(opts Options) Options {
@@ -1138,12 +457,375 @@ func TestRealMain(t *testing.T) {
 				return opts
 			},
 		},
+		{
+			desc:   "ParseProfile fails",
+			err:    "could not parse coverage profile line \"qwerty\"",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.captureOutput = func(command string, args ...string) ([]string, error) {
+					if args[0] == "test" {
+						// The coverage profile is always the last arg.
+						return nil, os.WriteFile(args[len(args)-1], []byte("qwerty\n"), 0600)
+					}
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
 		// Note that from here on the failures are that coverage isn't high enough.
 		{
-			desc:   "checkCoverage",
-			err:    "golang-coverage-check.go:48:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0%",
+			desc:   "CheckCoverage",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0%",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with JSON output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "\"schema_version\": 1,",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=json")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with SARIF output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "\"version\": \"2.1.0\",",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=sarif")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with JUnit output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "<testcase name=\"realMain\" classname=\"github.com/tobinjt/golang-coverage-check\" file=\"golang-coverage-check.go\" line=\"38\">",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=junit")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with Markdown output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "| `String` | golang-coverage-check.go:26 | 31.0% | 100.0% | default | **fail** |",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=markdown")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with GitHub output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "::error file=golang-coverage-check.go,line=26,title=Coverage check failed for String::golang-coverage-check.go:26:\tString\t31.0%25",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=github")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with GitHub output and job summary",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "::error file=golang-coverage-check.go,line=26,",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=github")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.getenv = func(name string) string {
+					return "/summary/for/" + name
+				}
+				opts.appendFile = func(path string, data []byte) error {
+					if path != "/summary/for/GITHUB_STEP_SUMMARY" || !strings.HasPrefix(string(data), "## Coverage check") {
+						return fmt.Errorf("unexpected job summary %v: %s", path, data)
+					}
+					return nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with GitHub output and failure writing job summary",
+			err:    "failed writing job summary to /summary: appendFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=github")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.getenv = func(string) string {
+					return "/summary"
+				}
+				opts.appendFile = func(string, []byte) error {
+					return errors.New("appendFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "exportCoverage fails",
+			err:    "failed writing LCOV tracefile to coverage.lcov: writeFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--lcov=coverage.lcov")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return errors.New("writeFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "writeBadge fails",
+			err:    "failed writing badge to coverage.svg: writeFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--badge=coverage.svg")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return errors.New("writeFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "HTML report path",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: filepath.Join(os.TempDir(), htmlIndexFile),
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--coverage_html=path")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.mkdirTemp = func(string, string) (string, error) {
+					return os.TempDir(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "HTML report fails",
+			err:    "failed writing HTML report: writeFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--html_report="+os.TempDir())
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return errors.New("writeFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "bad --report",
+			err:    "invalid value \"json\" for flag -report: expected FORMAT:PATH",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--report=json")
+				return opts
+			},
+		},
+		{
+			desc:   "--report with another format on stdout",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "| `String` | golang-coverage-check.go:26 | 31.0% | 100.0% | default | **fail** |",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--format=markdown", "--report=json:coverage.json", "--report=text:coverage.txt")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				written := []string{}
+				opts.writeFile = func(path string, data []byte, _ os.FileMode) error {
+					written = append(written, path)
+					if path == "coverage.json" && !strings.Contains(string(data), "\"schema_version\": 1,") {
+						return fmt.Errorf("unexpected JSON report: %s", data)
+					}
+					if path == "coverage.txt" && !strings.HasPrefix(string(data), "error: ") {
+						return fmt.Errorf("unexpected text report: %s", data)
+					}
+					if len(written) == 2 && (written[0] != "coverage.json" || written[1] != "coverage.txt") {
+						return fmt.Errorf("unexpected reports written: %v", written)
+					}
+					return nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--report fails",
+			err:    "failed writing junit report to coverage.xml: writeFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--report=junit:coverage.xml")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return errors.New("writeFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--history",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--history=history.jsonl")
+				opts.captureOutput = func(command string, _ ...string) ([]string, error) {
+					if command == "git" {
+						return []string{"abc123"}, nil
+					}
+					return validCoverageOutput(), nil
+				}
+				opts.appendFile = func(path string, data []byte) error {
+					if path != "history.jsonl" || !strings.Contains(string(data), "\"commit\":\"abc123\"") {
+						return fmt.Errorf("unexpected history %v: %s", path, data)
+					}
+					return nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--history fails",
+			err:    "failed finding the current commit for --history: not a git repository",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--history=history.jsonl")
+				opts.captureOutput = func(command string, _ ...string) ([]string, error) {
+					if command == "git" {
+						return nil, errors.New("not a git repository")
+					}
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--baseline",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < baseline coverage 40.0%",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--baseline=baseline-for-testing.json")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--write_baseline",
+			err:    "",
+			output: "",
+			mod: func(opts Options) Options {
+				// The baseline isn't read when writing it.
+				opts.rawArgs = append(opts.rawArgs, "--baseline=does-not-exist.json", "--write_baseline")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(path string, data []byte, _ os.FileMode) error {
+					if path != "does-not-exist.json" || !strings.Contains(string(data), "\"github.com/tobinjt/golang-coverage-check.String#2\": 31") {
+						return fmt.Errorf("unexpected baseline %v: %s", path, data)
+					}
+					return nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--write_baseline fails",
+			err:    "failed writing baseline to baseline.json: writeFile failed",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--baseline=baseline.json", "--write_baseline")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.writeFile = func(string, []byte, os.FileMode) error {
+					return errors.New("writeFile failed")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "serve on a bad address",
+			err:    "--serve only listens on localhost",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--serve=example.com:8080")
+				return opts
+			},
+		},
+		{
+			desc:   "serve",
+			err:    "server stopped",
+			output: "",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--serve=:8080")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.stdout = new(bytes.Buffer)
+				opts.listenAndServe = func(addr string, handler http.Handler) error {
+					if addr != "localhost:8080" {
+						return fmt.Errorf("unexpected address %q", addr)
+					}
+					return errors.New("server stopped")
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "watch",
+			err:    "",
 			output: "",
 			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--watch")
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				opts.stdout = new(bytes.Buffer)
+				opts.maxWatchIterations = 1
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with warnings",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "warning: golang-coverage-check.go:38:\trealMain\t17.3%: actual coverage 17.3% < required coverage 50.0%",
+			mod: func(opts Options) Options {
+				opts.configFile = "warning-config.yaml"
 				opts.captureOutput = func(string, ...string) ([]string, error) {
 					return validCoverageOutput(), nil
 				}
@@ -1151,8 +833,35 @@ func TestRealMain(t *testing.T) {
 			},
 		},
 		{
-			desc:   "checkCoverage, with debugging output",
-			err:    "golang-coverage-check.go:48:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			desc:   "CheckCoverage, with a generated rule matching several functions",
+			err:    "",
+			output: "warning: golang-coverage-check.go:22:\tString\t100.0%: generated rule `FilenameRegex: ^golang-coverage-check.go$ FunctionRegex: ^String$ ReceiverRegex: ^$ Coverage: 31 Comment: Generated rule for github.com/tobinjt/golang-coverage-check.String` matches 2 functions with different coverage",
+			mod: func(opts Options) Options {
+				opts.configFile = "generated-config.yaml"
+				opts.captureOutput = func(string, ...string) ([]string, error) {
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "--attribute_tests, with debugging output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
+			output: "  - Covering tests: none",
+			mod: func(opts Options) Options {
+				opts.rawArgs = append(opts.rawArgs, "--attribute_tests", "--debug_matching")
+				opts.captureOutput = func(command string, args ...string) ([]string, error) {
+					if args[1] == "--list" {
+						return []string{"TestRealMain"}, nil
+					}
+					return validCoverageOutput(), nil
+				}
+				return opts
+			},
+		},
+		{
+			desc:   "CheckCoverage, with debugging output",
+			err:    "golang-coverage-check.go:26:\tString\t31.0%: actual coverage 31.0% < default coverage 100.0",
 			output: "Debug info for coverage matching",
 			mod: func(opts Options) Options {
 				opts.rawArgs = append(opts.rawArgs, "--debug_matching")
@@ -0,0 +1,258 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// historyCommand is the argument that outputs the history recorded by
+// --history instead of checking coverage.
+const historyCommand = "history"
+
+// historySchemaVersion is the version of historyEntry; it will be incremented
+// when incompatible changes are made.
+const historySchemaVersion = 1
+
+// historyTopChanges is the number of functions included in the biggest
+// regressions and improvements.
+const historyTopChanges = 10
+
+// historyEntry is a line in the history file written by --history.
+type historyEntry struct {
+	SchemaVersion int    `json:"schema_version"`
+	Commit        string `json:"commit"`
+	// Timestamp is when coverage was checked.
+	Timestamp time.Time `json:"timestamp"`
+	// Coverage is the percentage of statements executed.
+	Coverage float64 `json:"coverage"`
+	// Packages maps from package name to the percentage of statements
+	// executed.
+	Packages map[string]float64 `json:"packages"`
+	// Functions maps from the keys returned by coveragecheck.FunctionKeys to
+	// function coverage, like coveragecheck.Baseline.
+	Functions map[string]float64 `json:"functions"`
+}
+
+// makeHistoryEntry summarises run as a historyEntry.
+func makeHistoryEntry(modulePath, commit string, timestamp time.Time, run coveragecheck.Report) historyEntry {
+	_, _, coverage := coveragecheck.StatementCoverage(run.Blocks)
+	entry := historyEntry{
+		SchemaVersion: historySchemaVersion,
+		Commit:        commit,
+		Timestamp:     timestamp.UTC(),
+		Coverage:      coverage,
+		Packages:      map[string]float64{},
+		Functions:     coveragecheck.MakeBaseline(run.Results).Functions,
+	}
+	for name, blocks := range packageBlocks(modulePath, run.Blocks) {
+		_, _, entry.Packages[name] = coveragecheck.StatementCoverage(blocks)
+	}
+	return entry
+}
+
+// recordHistory appends an entry for run to the history file if --history was
+// used.
+func recordHistory(options Options, run coveragecheck.Report) error {
+	if options.historyPath == "" {
+		return nil
+	}
+	output, err := options.captureOutput("git", "rev-parse", "HEAD")
+	if err != nil || len(output) == 0 {
+		return fmt.Errorf("failed finding the current commit for --history: %v", err)
+	}
+	entry := makeHistoryEntry(options.modulePath, strings.TrimSpace(output[0]), options.now(), run)
+	// historyEntry only contains types that can be marshalled.
+	bytes, _ := json.Marshal(entry)
+	if err := options.appendFile(options.historyPath, append(bytes, '\n')); err != nil {
+		return fmt.Errorf("failed writing history to %v: %w", options.historyPath, err)
+	}
+	return nil
+}
+
+// readHistory reads the entries in the history file written by --history.
+func readHistory(path string) ([]historyEntry, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading history %v: %w", path, err)
+	}
+	entries := []historyEntry{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := historyEntry{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed parsing history %v line %d: %w", path, i+1, err)
+		}
+		if entry.SchemaVersion != historySchemaVersion {
+			return nil, fmt.Errorf("failed parsing history %v line %d: unsupported schema_version %d",
+				path, i+1, entry.SchemaVersion)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no history recorded in %v", path)
+	}
+	return entries, nil
+}
+
+// findHistoryEntry returns the index of the entry identified by arg, which is
+// either the number of an entry as output by the history command, or a prefix
+// of a commit hash, in which case the most recent entry for that commit is
+// used.
+func findHistoryEntry(entries []historyEntry, arg string) (int, error) {
+	if number, err := strconv.Atoi(arg); err == nil {
+		if number < 1 || number > len(entries) {
+			return 0, fmt.Errorf("no history entry %d; entries are numbered 1 to %d", number, len(entries))
+		}
+		return number - 1, nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Commit, arg) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no history entry for commit %q", arg)
+}
+
+// shortCommit abbreviates a commit hash for output.
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// coverageChange is the change in coverage of a package or function between
+// two history entries.
+type coverageChange struct {
+	name     string
+	from, to float64
+}
+
+// String describes the change, e.g. api.go:Get: 100.0% -> 50.0% (-50.0%).
+func (change coverageChange) String() string {
+	return fmt.Sprintf("%s: %.1f%% -> %.1f%% (%+.1f%%)", change.name, change.from, change.to, change.to-change.from)
+}
+
+// coverageChanges returns the changes between from and to, ignoring names that
+// aren't in both, sorted by name.
+func coverageChanges(from, to map[string]float64) []coverageChange {
+	changes := []coverageChange{}
+	for name, after := range to {
+		if before, ok := from[name]; ok && before != after {
+			changes = append(changes, coverageChange{name: name, from: before, to: after})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].name < changes[j].name
+	})
+	return changes
+}
+
+// biggestChanges returns up to historyTopChanges descriptions of the changes
+// with the biggest decrease in coverage when sign is -1, or the biggest
+// increase when sign is 1.
+func biggestChanges(changes []coverageChange, sign float64) []string {
+	selected := []coverageChange{}
+	for _, change := range changes {
+		if sign*(change.to-change.from) > 0 {
+			selected = append(selected, change)
+		}
+	}
+	// SliceStable keeps changes of the same size sorted by name.
+	sort.SliceStable(selected, func(i, j int) bool {
+		return math.Abs(selected[i].to-selected[i].from) > math.Abs(selected[j].to-selected[j].from)
+	})
+	lines := []string{}
+	for i, change := range selected {
+		if i == historyTopChanges {
+			break
+		}
+		lines = append(lines, "  "+change.String())
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "  none")
+	}
+	return lines
+}
+
+// describeHistory outputs every entry in the history with its change in
+// coverage, then compares the entries identified by args, which are either
+// empty to compare the last two entries, or two arguments accepted by
+// findHistoryEntry.
+func describeHistory(entries []historyEntry, args []string) ([]string, error) {
+	lines := []string{"Coverage history:"}
+	for i, entry := range entries {
+		line := fmt.Sprintf("%3d  %s  %-12s  %5.1f%%", i+1,
+			entry.Timestamp.Format(time.RFC3339), shortCommit(entry.Commit), entry.Coverage)
+		if i > 0 {
+			line += fmt.Sprintf("  %+.1f%%", entry.Coverage-entries[i-1].Coverage)
+		}
+		lines = append(lines, line)
+	}
+
+	from, to := len(entries)-2, len(entries)-1
+	if len(args) == 2 {
+		var err error
+		if from, err = findHistoryEntry(entries, args[0]); err != nil {
+			return nil, err
+		}
+		if to, err = findHistoryEntry(entries, args[1]); err != nil {
+			return nil, err
+		}
+	}
+	if from < 0 {
+		return append(lines, "", "At least two entries are needed to compare coverage."), nil
+	}
+	before, after := entries[from], entries[to]
+	functionChanges := coverageChanges(before.Functions, after.Functions)
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Comparing %d (%s) with %d (%s):", from+1, shortCommit(before.Commit), to+1, shortCommit(after.Commit)),
+		coverageChange{name: "Total coverage", from: before.Coverage, to: after.Coverage}.String(),
+		"Packages:")
+	packageChanges := coverageChanges(before.Packages, after.Packages)
+	for _, change := range packageChanges {
+		lines = append(lines, "  "+change.String())
+	}
+	if len(packageChanges) == 0 {
+		lines = append(lines, "  none")
+	}
+	lines = append(lines, "Biggest regressions:")
+	lines = append(lines, biggestChanges(functionChanges, -1)...)
+	lines = append(lines, "Biggest improvements:")
+	lines = append(lines, biggestChanges(functionChanges, 1)...)
+	return lines, nil
+}
+
+// showHistory implements the history command.
+func showHistory(options Options) ([]string, error) {
+	entries, err := readHistory(options.historyPath)
+	if err != nil {
+		return nil, err
+	}
+	return describeHistory(entries, options.parsedArgs[1:])
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func historyTestRun() coveragecheck.Report {
+	return coveragecheck.Report{
+		Results: []coveragecheck.CheckResult{
+			{
+				Coverage: coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+				Function: coveragecheck.FunctionInfo{Receiver: "Client"},
+			},
+			{Coverage: coveragecheck.CoverageLine{Filename: "sub/init.go", LineNumber: "3", Function: "init", Coverage: 100}},
+			{Coverage: coveragecheck.CoverageLine{Filename: "sub/init.go", LineNumber: "9", Function: "init", Coverage: 0}},
+		},
+		Blocks: []coveragecheck.ProfileBlock{
+			{Filename: "api.go", NumStatements: 2, Count: 1},
+			{Filename: "api.go", NumStatements: 2, Count: 0},
+			{Filename: "sub/init.go", NumStatements: 4, Count: 1},
+		},
+	}
+}
+
+func TestMakeHistoryEntry(t *testing.T) {
+	timestamp := time.Date(2022, 10, 1, 12, 0, 0, 0, time.FixedZone("UTC+1", 3600))
+	entry := makeHistoryEntry("example.com/mod/", "abc123", timestamp, historyTestRun())
+	assert.Equal(t, historyEntry{
+		SchemaVersion: 1,
+		Commit:        "abc123",
+		Timestamp:     time.Date(2022, 10, 1, 11, 0, 0, 0, time.UTC),
+		Coverage:      75,
+		Packages:      map[string]float64{"example.com/mod": 50, "example.com/mod/sub": 100},
+		Functions:     map[string]float64{"api.go:Client.Get": 50, "sub/init.go:init": 100, "sub/init.go:init#2": 0},
+	}, entry)
+}
+
+func TestRecordHistory(t *testing.T) {
+	options := newTestOptions()
+	// Nothing happens without --history.
+	assert.Nil(t, recordHistory(options, historyTestRun()))
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	options.historyPath = path
+	options.modulePath = "example.com/mod/"
+	options.appendFile = appendFile
+	options.now = func() time.Time {
+		return time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	}
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		assert.Equal(t, "git", command)
+		assert.Equal(t, []string{"rev-parse", "HEAD"}, args)
+		return []string{"abc123\n"}, nil
+	}
+	assert.Nil(t, recordHistory(options, historyTestRun()))
+	assert.Nil(t, recordHistory(options, historyTestRun()))
+	entries, err := readHistory(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "abc123", entries[1].Commit)
+	assert.Equal(t, 75.0, entries[1].Coverage)
+
+	options.appendFile = func(string, []byte) error {
+		return errors.New("appendFile failed")
+	}
+	assert.EqualError(t, recordHistory(options, historyTestRun()), "failed writing history to "+path+": appendFile failed")
+
+	options.captureOutput = func(string, ...string) ([]string, error) {
+		return nil, errors.New("not a git repository")
+	}
+	assert.EqualError(t, recordHistory(options, historyTestRun()), "failed finding the current commit for --history: not a git repository")
+	options.captureOutput = func(string, ...string) ([]string, error) {
+		return nil, nil
+	}
+	assert.EqualError(t, recordHistory(options, historyTestRun()), "failed finding the current commit for --history: <nil>")
+}
+
+func TestReadHistory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(contents string) string {
+		path := filepath.Join(dir, "history.jsonl")
+		writeTestFile(t, path, contents)
+		return path
+	}
+	entries, err := readHistory(write("{\"schema_version\": 1, \"commit\": \"abc\"}\n\n{\"schema_version\": 1, \"commit\": \"def\"}\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "def"}, []string{entries[0].Commit, entries[1].Commit})
+
+	path := write("{\"schema_version\": 1}\nnot json\n")
+	_, err = readHistory(path)
+	assert.ErrorContains(t, err, "failed parsing history "+path+" line 2: ")
+	_, err = readHistory(write("{\"schema_version\": 2}\n"))
+	assert.ErrorContains(t, err, "line 1: unsupported schema_version 2")
+	_, err = readHistory(write("\n"))
+	assert.EqualError(t, err, "no history recorded in "+path)
+	_, err = readHistory(filepath.Join(dir, "missing.jsonl"))
+	assert.ErrorContains(t, err, "failed reading history "+filepath.Join(dir, "missing.jsonl")+": ")
+}
+
+func TestFindHistoryEntry(t *testing.T) {
+	entries := []historyEntry{{Commit: "abc123"}, {Commit: "def456"}, {Commit: "abc123"}}
+	table := []struct {
+		arg   string
+		index int
+		err   string
+	}{
+		{arg: "1", index: 0},
+		{arg: "3", index: 2},
+		{arg: "0", err: "no history entry 0; entries are numbered 1 to 3"},
+		{arg: "4", err: "no history entry 4; entries are numbered 1 to 3"},
+		{arg: "def", index: 1},
+		// The most recent entry for a commit is used.
+		{arg: "abc1", index: 2},
+		{arg: "fff", err: "no history entry for commit \"fff\""},
+	}
+	for _, test := range table {
+		index, err := findHistoryEntry(entries, test.arg)
+		if test.err == "" {
+			assert.Nil(t, err, test.arg)
+			assert.Equal(t, test.index, index, test.arg)
+		} else {
+			assert.EqualError(t, err, test.err, test.arg)
+		}
+	}
+}
+
+func TestShortCommit(t *testing.T) {
+	assert.Equal(t, "abc", shortCommit("abc"))
+	assert.Equal(t, "0123456789ab", shortCommit("0123456789abcdef"))
+}
+
+func TestBiggestChanges(t *testing.T) {
+	changes := []coverageChange{}
+	for i := 0; i < historyTopChanges+2; i++ {
+		changes = append(changes, coverageChange{name: string(rune('a' + i)), from: 50, to: float64(40 - i)})
+	}
+	changes = append(changes, coverageChange{name: "z", from: 50, to: 60})
+	regressions := biggestChanges(changes, -1)
+	assert.Equal(t, historyTopChanges, len(regressions))
+	assert.Equal(t, "  l: 50.0% -> 29.0% (-21.0%)", regressions[0])
+	assert.Equal(t, []string{"  z: 50.0% -> 60.0% (+10.0%)"}, biggestChanges(changes, 1))
+	assert.Equal(t, []string{"  none"}, biggestChanges(nil, 1))
+}
+
+func TestDescribeHistory(t *testing.T) {
+	entries := []historyEntry{
+		{
+			Commit:    "0123456789abcdef",
+			Timestamp: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC),
+			Coverage:  80,
+			Packages:  map[string]float64{"example.com/mod": 80, "example.com/mod/old": 10},
+			Functions: map[string]float64{"api.go:Get": 100, "api.go:Put": 50, "api.go:Delete": 10, "old.go:Old": 0},
+		},
+		{
+			Commit:    "fedcba",
+			Timestamp: time.Date(2022, 10, 2, 12, 0, 0, 0, time.UTC),
+			Coverage:  72.5,
+			Packages:  map[string]float64{"example.com/mod": 72.5},
+			Functions: map[string]float64{"api.go:Get": 60, "api.go:Put": 75, "api.go:Delete": 10, "new.go:New": 0},
+		},
+		{
+			Commit:    "fedcba",
+			Timestamp: time.Date(2022, 10, 3, 12, 0, 0, 0, time.UTC),
+			Coverage:  72.5,
+			Packages:  map[string]float64{"example.com/mod": 72.5},
+			Functions: map[string]float64{"api.go:Get": 60, "api.go:Put": 75, "api.go:Delete": 10, "new.go:New": 0},
+		},
+	}
+	lines, err := describeHistory(entries, []string{"1", "fed"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Coverage history:",
+		"  1  2022-10-01T12:00:00Z  0123456789ab   80.0%",
+		"  2  2022-10-02T12:00:00Z  fedcba         72.5%  -7.5%",
+		"  3  2022-10-03T12:00:00Z  fedcba         72.5%  +0.0%",
+		"",
+		"Comparing 1 (0123456789ab) with 3 (fedcba):",
+		"Total coverage: 80.0% -> 72.5% (-7.5%)",
+		"Packages:",
+		"  example.com/mod: 80.0% -> 72.5% (-7.5%)",
+		"Biggest regressions:",
+		"  api.go:Get: 100.0% -> 60.0% (-40.0%)",
+		"Biggest improvements:",
+		"  api.go:Put: 50.0% -> 75.0% (+25.0%)",
+	}, lines)
+
+	// By default the last two entries are compared.
+	lines, err = describeHistory(entries, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Comparing 2 (fedcba) with 3 (fedcba):",
+		"Total coverage: 72.5% -> 72.5% (+0.0%)",
+		"Packages:",
+		"  none",
+		"Biggest regressions:",
+		"  none",
+		"Biggest improvements:",
+		"  none",
+	}, lines[5:])
+
+	lines, err = describeHistory(entries[:1], nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "At least two entries are needed to compare coverage.", lines[len(lines)-1])
+
+	_, err = describeHistory(entries, []string{"0", "1"})
+	assert.ErrorContains(t, err, "no history entry 0")
+	_, err = describeHistory(entries, []string{"1", "fff"})
+	assert.ErrorContains(t, err, "no history entry for commit \"fff\"")
+}
+
+func TestHistoryCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	lines := []string{}
+	for i, coverage := range []float64{50, 75} {
+		entry := makeHistoryEntry("example.com/mod/", "abc"+string(rune('0'+i)), time.Date(2022, 10, 1+i, 0, 0, 0, 0, time.UTC), historyTestRun())
+		entry.Coverage = coverage
+		bytes, err := json.Marshal(entry)
+		assert.Nil(t, err)
+		lines = append(lines, string(bytes))
+	}
+	writeTestFile(t, path, strings.Join(lines, "\n"))
+
+	options := newTestOptions()
+	options.rawArgs = []string{"--history=" + path, historyCommand}
+	stdout, stderr, err := realMain(options)
+	assert.Nil(t, err)
+	assert.Empty(t, stderr)
+	assert.Contains(t, stdout, "Total coverage: 50.0% -> 75.0% (+25.0%)")
+
+	options.rawArgs = []string{"--history=" + path, historyCommand, "2", "1"}
+	stdout, _, err = realMain(options)
+	assert.Nil(t, err)
+	assert.Contains(t, stdout, "Comparing 2 (abc1) with 1 (abc0):")
+
+	options.rawArgs = []string{"--history=" + filepath.Join(t.TempDir(), "missing"), historyCommand}
+	_, _, err = realMain(options)
+	assert.ErrorContains(t, err, "failed reading history ")
+}
@@ -0,0 +1,391 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// htmlIndexFile is the name of the top-level page of the HTML report.
+const htmlIndexFile = "index.html"
+
+// htmlStyle is shared by every page of the HTML report, so the report doesn't
+// depend on any other files.
+const htmlStyle = `
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.6em; text-align: left; }
+.source td { padding: 0 0.6em; }
+.source .number { color: #888; text-align: right; user-select: none; }
+.source pre { margin: 0; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.function summary { cursor: pointer; }
+.function ul { margin: 0.2em 0 0.5em; font-family: sans-serif; }
+.directory th { padding-top: 1em; }
+.badge { display: inline-block; margin: 0.3em 0; padding: 0.1em 0.5em; border-radius: 3px; color: #fff; font-family: sans-serif; }
+.badge.pass { background: #4c1; }
+.badge.warning { background: #dfb317; }
+.badge.fail { background: #e05d44; }
+`
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage report</title>
+<style>` + htmlStyle + `</style>
+</head>
+<body>
+<h1>Coverage report</h1>
+<p>Total coverage: {{printf "%.1f" .Coverage}}% ({{.Covered}} of {{.Statements}} statements)</p>
+{{- if .Rerun}}
+<form method="post" action="/rerun"><button type="submit">Rerun tests</button></form>
+{{- end}}
+<table>
+<tr><th>File</th><th>Coverage</th><th>Statements</th><th>Failing functions</th></tr>
+{{- range .Directories}}
+<tr class="directory"><th colspan="4">{{.Name}}/</th></tr>
+{{- range .Files}}
+<tr><td><a href="{{.Page}}">{{.Basename}}</a></td><td>{{printf "%.1f" .Coverage}}%</td><td>{{.Covered}}/{{.Statements}}</td><td>{{.Failing}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+var htmlFileTemplate = template.Must(template.New("file").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Filename}}</title>
+<style>` + htmlStyle + `</style>
+</head>
+<body>
+<p><a href="` + htmlIndexFile + `">Coverage report</a></p>
+<h1>{{.Filename}}</h1>
+<p>Coverage: {{printf "%.1f" .Coverage}}% ({{.Covered}} of {{.Statements}} statements)</p>
+<table class="source">
+{{- range .Lines}}
+{{- range .Functions}}
+<tr><td></td><td>{{template "badge" .}}</td></tr>
+{{- end}}
+<tr id="L{{.Number}}" class="{{.Class}}"><td class="number">{{.Number}}</td><td><pre>{{.Text}}</pre></td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+{{define "badge"}}<details class="function"><summary><span class="badge {{.Class}}">{{.Name}}: {{printf "%.1f" .Coverage}}% (required {{printf "%.1f" .Required}}%)</span></summary><ul>
+{{- range .Explanation}}<li>{{.}}</li>{{end -}}
+</ul></details>{{end}}
+`))
+
+// htmlFunction is the badge shown for a function in the HTML report.
+type htmlFunction struct {
+	Name     string
+	Coverage float64
+	Required float64
+	Class    string
+	// Explanation describes how the function was checked, from explainResult.
+	Explanation []string
+}
+
+// htmlLine is a line of source code in the HTML report.
+type htmlLine struct {
+	Number int
+	Text   string
+	// Class is "covered", "uncovered", or empty for lines without statements.
+	Class string
+	// Functions starting on this line.
+	Functions []htmlFunction
+}
+
+// htmlFile is a source file in the HTML report.
+type htmlFile struct {
+	Filename   string
+	Basename   string
+	Page       string
+	Statements int
+	Covered    int
+	Coverage   float64
+	Failing    int
+	Lines      []htmlLine
+}
+
+// htmlDirectory is a directory in the index of the HTML report.
+type htmlDirectory struct {
+	Name  string
+	Files []htmlFile
+}
+
+// explainResult describes how a function was checked: which rule matched, and
+// how its coverage and CRAP score compare to the limits.
+func explainResult(result coveragecheck.CheckResult) []string {
+	explanation := []string{}
+	if result.Rule == nil {
+		explanation = append(explanation,
+			fmt.Sprintf("No rule matched, so the default coverage of %.1f%% is required.", result.RequiredCoverage))
+	} else {
+		if result.RuleIndex == 1 {
+			explanation = append(explanation, "Rule 0 didn't match.")
+		} else if result.RuleIndex > 1 {
+			explanation = append(explanation, fmt.Sprintf("Rules 0-%d didn't match.", result.RuleIndex-1))
+		}
+		explanation = append(explanation, matchingRuleDescription(result)+" matched.")
+	}
+	comparison := ">="
+	if result.Coverage.Coverage < result.RequiredCoverage {
+		comparison = "<"
+	}
+	explanation = append(explanation, fmt.Sprintf("Actual coverage %.1f%% %s required coverage %.1f%%.",
+		result.Coverage.Coverage, comparison, result.RequiredCoverage))
+	if result.MaxCrap > 0 {
+		comparison = "<="
+		if result.CrapScore > result.MaxCrap {
+			comparison = ">"
+		}
+		explanation = append(explanation, fmt.Sprintf("CRAP score %.1f %s maximum CRAP score %.1f.",
+			result.CrapScore, comparison, result.MaxCrap))
+	}
+	if result.UncoveredLines != "" {
+		explanation = append(explanation, "Uncovered lines: "+result.UncoveredLines)
+	}
+	if result.Status() == coveragecheck.StatusWarning {
+		explanation = append(explanation, "The matching rule has warning severity, so this doesn't cause failure.")
+	}
+	return explanation
+}
+
+// htmlLineClasses classifies the lines of a file for highlighting: a line is
+// uncovered if any block spanning it was never executed, and covered if every
+// block spanning it was executed.
+func htmlLineClasses(blocks []coveragecheck.ProfileBlock) map[int]string {
+	classes := map[int]string{}
+	for _, block := range blocks {
+		for line := block.StartLine; line <= block.EndLine; line++ {
+			if block.Count == 0 {
+				classes[line] = "uncovered"
+			} else if classes[line] == "" {
+				classes[line] = "covered"
+			}
+		}
+	}
+	return classes
+}
+
+// htmlBadgeClass returns the CSS class of a function's badge.
+func htmlBadgeClass(result coveragecheck.CheckResult) string {
+	switch result.Status() {
+	case coveragecheck.StatusPassed:
+		return "pass"
+	case coveragecheck.StatusWarning:
+		return "warning"
+	}
+	return "fail"
+}
+
+// makeHTMLFile reads a source file and combines it with the results and
+// blocks for that file.
+func makeHTMLFile(options Options, filename string, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) (htmlFile, error) {
+	source, err := os.ReadFile(filepath.Join(options.dirToParse, filename))
+	if err != nil {
+		return htmlFile{}, fmt.Errorf("failed reading source for HTML report: %w", err)
+	}
+	file := htmlFile{Filename: filename, Basename: path.Base(filename)}
+	file.Statements, file.Covered, file.Coverage = coveragecheck.StatementCoverage(blocks)
+
+	functions := map[int][]htmlFunction{}
+	for _, result := range results {
+		line := result.Coverage.Line()
+		functions[line] = append(functions[line], htmlFunction{
+			Name:        qualifiedFunctionName(result),
+			Coverage:    result.Coverage.Coverage,
+			Required:    result.RequiredCoverage,
+			Class:       htmlBadgeClass(result),
+			Explanation: explainResult(result),
+		})
+		if result.Status() == coveragecheck.StatusFailed {
+			file.Failing++
+		}
+	}
+
+	classes := htmlLineClasses(blocks)
+	for i, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+		number := i + 1
+		file.Lines = append(file.Lines, htmlLine{
+			Number:    number,
+			Text:      text,
+			Class:     classes[number],
+			Functions: functions[number],
+		})
+	}
+	return file, nil
+}
+
+// renderHTMLReport renders an HTML report of results and blocks: an index
+// page listing every file by directory, and a page for each file showing its
+// source with covered and uncovered lines highlighted, and a badge for each
+// function showing its coverage and required coverage that can be expanded to
+// explain how the function was checked.  The index page has a button to rerun
+// the tests if rerun is true.  Returns a map from page name to contents.
+func renderHTMLReport(options Options, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock, rerun bool) (map[string][]byte, error) {
+	fileResults := map[string][]coveragecheck.CheckResult{}
+	for _, result := range results {
+		fileResults[result.Coverage.Filename] = append(fileResults[result.Coverage.Filename], result)
+	}
+	fileBlocks := map[string][]coveragecheck.ProfileBlock{}
+	for _, block := range blocks {
+		fileBlocks[block.Filename] = append(fileBlocks[block.Filename], block)
+	}
+	filenames := []string{}
+	for filename := range fileResults {
+		filenames = append(filenames, filename)
+	}
+	for filename := range fileBlocks {
+		if _, ok := fileResults[filename]; !ok {
+			filenames = append(filenames, filename)
+		}
+	}
+	// Sorting by directory first keeps each directory's files together.
+	sort.Slice(filenames, func(i, j int) bool {
+		dirI, dirJ := path.Dir(filenames[i]), path.Dir(filenames[j])
+		if dirI != dirJ {
+			return dirI < dirJ
+		}
+		return filenames[i] < filenames[j]
+	})
+
+	pages := map[string][]byte{}
+	index := struct {
+		Statements  int
+		Covered     int
+		Coverage    float64
+		Rerun       bool
+		Directories []htmlDirectory
+	}{Rerun: rerun}
+	index.Statements, index.Covered, index.Coverage = coveragecheck.StatementCoverage(blocks)
+	for i, filename := range filenames {
+		file, err := makeHTMLFile(options, filename, fileResults[filename], fileBlocks[filename])
+		if err != nil {
+			return nil, err
+		}
+		// Page names are numbered so that files in different directories can't
+		// collide.
+		file.Page = fmt.Sprintf("file-%d.html", i)
+		var page bytes.Buffer
+		// Executing these templates cannot fail.
+		_ = htmlFileTemplate.Execute(&page, file)
+		pages[file.Page] = page.Bytes()
+		dir := path.Dir(filename)
+		if len(index.Directories) == 0 || index.Directories[len(index.Directories)-1].Name != dir {
+			index.Directories = append(index.Directories, htmlDirectory{Name: dir})
+		}
+		last := &index.Directories[len(index.Directories)-1]
+		last.Files = append(last.Files, file)
+	}
+
+	var page bytes.Buffer
+	_ = htmlIndexTemplate.Execute(&page, index)
+	pages[htmlIndexFile] = page.Bytes()
+	return pages, nil
+}
+
+// writeHTMLReport writes the HTML report from renderHTMLReport to dir,
+// returning the path to the index page.
+func writeHTMLReport(options Options, dir string, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) (string, error) {
+	pages, err := renderHTMLReport(options, results, blocks, false)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed creating HTML report directory: %w", err)
+	}
+	names := []string{}
+	for name := range pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := options.writeFile(filepath.Join(dir, name), pages[name], 0644); err != nil {
+			return "", fmt.Errorf("failed writing HTML report: %w", err)
+		}
+	}
+	return filepath.Join(dir, htmlIndexFile), nil
+}
+
+// defaultBrowser returns the command and arguments that open a file in the
+// default browser on goos, which is normally runtime.GOOS.
+func defaultBrowser(goos string) (string, []string) {
+	switch goos {
+	case "darwin":
+		return "open", nil
+	case "windows":
+		// The empty argument is the window title, so a quoted path isn't
+		// mistaken for it.
+		return "cmd", []string{"/c", "start", ""}
+	}
+	return "xdg-open", nil
+}
+
+// openInBrowser opens path in a browser for --coverage_html=browser, using
+// $BROWSER if it's set, like "go tool cover --html".
+func openInBrowser(options Options, path string) error {
+	command, args := options.getenv("BROWSER"), []string{}
+	if command == "" {
+		command, args = defaultBrowser(runtime.GOOS)
+	}
+	if _, err := options.captureOutput(command, append(args, path)...); err != nil {
+		return fmt.Errorf("failed opening HTML report in a browser: %w", err)
+	}
+	return nil
+}
+
+// htmlReport writes the HTML report requested by --html_report or
+// --coverage_html, opening it in a browser for --coverage_html=browser and
+// returning the path to the index page for --coverage_html=path so it can be
+// output.
+func htmlReport(options Options, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) ([]string, error) {
+	if options.htmlReportDir != "" {
+		if _, err := writeHTMLReport(options, options.htmlReportDir, results, blocks); err != nil {
+			return nil, err
+		}
+	}
+	if options.coverageHTML != htmlShowPath && options.coverageHTML != htmlOpenInBrowser {
+		return nil, nil
+	}
+	dir, err := options.mkdirTemp("", "golang-coverage-check.*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed creating HTML report directory: %w", err)
+	}
+	indexPath, err := writeHTMLReport(options, dir, results, blocks)
+	if err != nil {
+		return nil, err
+	}
+	if options.coverageHTML == htmlOpenInBrowser {
+		return nil, openInBrowser(options, indexPath)
+	}
+	return []string{indexPath}, nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestHTMLLineClasses(t *testing.T) {
+	blocks := []coveragecheck.ProfileBlock{
+		{StartLine: 1, EndLine: 3, Count: 1},
+		{StartLine: 3, EndLine: 4, Count: 0},
+		{StartLine: 6, EndLine: 6, Count: 2},
+	}
+	expected := map[int]string{
+		1: "covered",
+		2: "covered",
+		3: "uncovered",
+		4: "uncovered",
+		6: "covered",
+	}
+	assert.Equal(t, expected, htmlLineClasses(blocks))
+}
+
+func TestHTMLBadgeClass(t *testing.T) {
+	assert.Equal(t, "pass", htmlBadgeClass(coveragecheck.CheckResult{Passed: true, Severity: coveragecheck.SeverityError}))
+	assert.Equal(t, "warning", htmlBadgeClass(coveragecheck.CheckResult{Passed: false, Severity: coveragecheck.SeverityWarning}))
+	assert.Equal(t, "fail", htmlBadgeClass(coveragecheck.CheckResult{Passed: false, Severity: coveragecheck.SeverityError}))
+}
+
+// htmlTestInputs creates a source file in a temporary directory and returns
+// options for parsing that directory, with results and blocks for the file.
+func htmlTestInputs(t *testing.T) (Options, []coveragecheck.CheckResult, []coveragecheck.ProfileBlock) {
+	dir := t.TempDir()
+	source := `package example
+
+func Get() int {
+	if <false> {
+		return 1
+	}
+	return 2
+}
+`
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "api.go"), []byte(source), 0644))
+	rule := coveragecheck.Rule{FunctionRegex: "^Get$", Coverage: 100}
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "3", Function: "Get", Coverage: 66.7},
+			RuleIndex:        0,
+			Rule:             &rule,
+			RequiredCoverage: 100,
+			Severity:         coveragecheck.SeverityError,
+			Violations:       []coveragecheck.Violation{{Message: "api.go:3: Get is not covered"}},
+		},
+	}
+	blocks := []coveragecheck.ProfileBlock{
+		{Filename: "api.go", StartLine: 3, StartColumn: 16, EndLine: 4, EndColumn: 12, NumStatements: 1, Count: 1},
+		{Filename: "api.go", StartLine: 4, StartColumn: 12, EndLine: 6, EndColumn: 3, NumStatements: 1, Count: 0},
+		{Filename: "api.go", StartLine: 7, StartColumn: 2, EndLine: 7, EndColumn: 10, NumStatements: 1, Count: 1},
+	}
+	options := newTestOptions()
+	options.dirToParse = dir
+	options.writeFile = os.WriteFile
+	return options, results, blocks
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	options, results, blocks := htmlTestInputs(t)
+	dir := filepath.Join(t.TempDir(), "report")
+	indexPath, err := writeHTMLReport(options, dir, results, blocks)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, htmlIndexFile), indexPath)
+
+	index, err := os.ReadFile(indexPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(index), "<p>Total coverage: 66.7% (2 of 3 statements)</p>")
+	assert.Contains(t, string(index), `<tr class="directory"><th colspan="4">./</th></tr>
+<tr><td><a href="file-0.html">api.go</a></td><td>66.7%</td><td>2/3</td><td>1</td></tr>`)
+	assert.NotContains(t, string(index), rerunPath)
+
+	page, err := os.ReadFile(filepath.Join(dir, "file-0.html"))
+	assert.Nil(t, err)
+	for _, expected := range []string{
+		`<tr id="L1" class=""><td class="number">1</td><td><pre>package example</pre></td></tr>`,
+		`<tr><td></td><td><details class="function"><summary><span class="badge fail">Get: 66.7% (required 100.0%)</span></summary><ul>` +
+			`<li>rule 0: FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 100 Comment:  matched.</li>` +
+			`<li>Actual coverage 66.7% &lt; required coverage 100.0%.</li></ul></details></td></tr>`,
+		`<tr id="L3" class="covered"><td class="number">3</td><td><pre>func Get() int {</pre></td></tr>`,
+		`<tr id="L4" class="uncovered"><td class="number">4</td><td><pre>	if &lt;false&gt; {</pre></td></tr>`,
+		`<tr id="L8" class=""><td class="number">8</td><td><pre>}</pre></td></tr>`,
+	} {
+		assert.Contains(t, string(page), expected)
+	}
+	assert.NotContains(t, string(page), `id="L9"`)
+}
+
+func TestRenderHTMLReport(t *testing.T) {
+	options, results, blocks := htmlTestInputs(t)
+	for _, filename := range []string{"sub/b.go", "sub/a.go", "z.go"} {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(options.dirToParse, filename)), 0755))
+		assert.Nil(t, os.WriteFile(filepath.Join(options.dirToParse, filename), []byte("package sub\n"), 0644))
+		blocks = append(blocks, coveragecheck.ProfileBlock{Filename: filename, StartLine: 1, EndLine: 1, NumStatements: 1, Count: 1})
+	}
+	pages, err := renderHTMLReport(options, results, blocks, true)
+	assert.Nil(t, err)
+	assert.Len(t, pages, 5)
+	index := string(pages[htmlIndexFile])
+	assert.Contains(t, index, `<form method="post" action="/rerun"><button type="submit">Rerun tests</button></form>`)
+	assert.Contains(t, index, `<tr class="directory"><th colspan="4">./</th></tr>
+<tr><td><a href="file-0.html">api.go</a></td><td>66.7%</td><td>2/3</td><td>1</td></tr>
+<tr><td><a href="file-1.html">z.go</a></td><td>100.0%</td><td>1/1</td><td>0</td></tr>
+<tr class="directory"><th colspan="4">sub/</th></tr>
+<tr><td><a href="file-2.html">a.go</a></td><td>100.0%</td><td>1/1</td><td>0</td></tr>
+<tr><td><a href="file-3.html">b.go</a></td><td>100.0%</td><td>1/1</td><td>0</td></tr>`)
+	assert.Contains(t, string(pages["file-3.html"]), "<h1>sub/b.go</h1>")
+}
+
+func TestExplainResult(t *testing.T) {
+	rule := coveragecheck.Rule{FunctionRegex: "^Get$", Coverage: 80}
+	table := []struct {
+		desc     string
+		result   coveragecheck.CheckResult
+		expected []string
+	}{
+		{
+			desc: "default coverage",
+			result: coveragecheck.CheckResult{
+				Coverage:         coveragecheck.CoverageLine{Coverage: 100},
+				RuleIndex:        -1,
+				RequiredCoverage: 90,
+				Passed:           true,
+			},
+			expected: []string{
+				"No rule matched, so the default coverage of 90.0% is required.",
+				"Actual coverage 100.0% >= required coverage 90.0%.",
+			},
+		},
+		{
+			desc: "first rule",
+			result: coveragecheck.CheckResult{
+				Coverage:         coveragecheck.CoverageLine{Coverage: 80},
+				RuleIndex:        0,
+				Rule:             &rule,
+				RequiredCoverage: 80,
+				CrapScore:        2,
+				MaxCrap:          30,
+				Passed:           true,
+			},
+			expected: []string{
+				"rule 0: FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 80 Comment:  matched.",
+				"Actual coverage 80.0% >= required coverage 80.0%.",
+				"CRAP score 2.0 <= maximum CRAP score 30.0.",
+			},
+		},
+		{
+			desc: "second rule",
+			result: coveragecheck.CheckResult{
+				Coverage:         coveragecheck.CoverageLine{Coverage: 80},
+				RuleIndex:        1,
+				Rule:             &rule,
+				RequiredCoverage: 80,
+				Passed:           true,
+			},
+			expected: []string{
+				"Rule 0 didn't match.",
+				"rule 1: FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 80 Comment:  matched.",
+				"Actual coverage 80.0% >= required coverage 80.0%.",
+			},
+		},
+		{
+			desc: "later rule with warnings",
+			result: coveragecheck.CheckResult{
+				Coverage:         coveragecheck.CoverageLine{Coverage: 50},
+				RuleIndex:        3,
+				Rule:             &rule,
+				RequiredCoverage: 80,
+				CrapScore:        40,
+				MaxCrap:          30,
+				UncoveredLines:   "api.go:4-6",
+				Passed:           false,
+				Severity:         coveragecheck.SeverityWarning,
+			},
+			expected: []string{
+				"Rules 0-2 didn't match.",
+				"rule 3: FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 80 Comment:  matched.",
+				"Actual coverage 50.0% < required coverage 80.0%.",
+				"CRAP score 40.0 > maximum CRAP score 30.0.",
+				"Uncovered lines: api.go:4-6",
+				"The matching rule has warning severity, so this doesn't cause failure.",
+			},
+		},
+	}
+	for _, test := range table {
+		assert.Equal(t, test.expected, explainResult(test.result), test.desc)
+	}
+}
+
+func TestWriteHTMLReportBlocksWithoutResults(t *testing.T) {
+	options, _, blocks := htmlTestInputs(t)
+	dir := t.TempDir()
+	_, err := writeHTMLReport(options, dir, nil, blocks)
+	assert.Nil(t, err)
+	page, err := os.ReadFile(filepath.Join(dir, "file-0.html"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(page), "badge fail")
+}
+
+func TestWriteHTMLReportErrors(t *testing.T) {
+	options, results, blocks := htmlTestInputs(t)
+	file := filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, os.WriteFile(file, nil, 0644))
+	_, err := writeHTMLReport(options, filepath.Join(file, "report"), results, blocks)
+	assert.ErrorContains(t, err, "failed creating HTML report directory")
+
+	options.writeFile = func(path string, data []byte, perm os.FileMode) error {
+		return errors.New("writeFile failed")
+	}
+	_, err = writeHTMLReport(options, t.TempDir(), results, blocks)
+	assert.ErrorContains(t, err, "failed writing HTML report: writeFile failed")
+	// With no files only the index is written.
+	_, err = writeHTMLReport(options, t.TempDir(), nil, nil)
+	assert.ErrorContains(t, err, "failed writing HTML report: writeFile failed")
+
+	options.dirToParse = t.TempDir()
+	_, err = writeHTMLReport(options, t.TempDir(), results, blocks)
+	assert.ErrorContains(t, err, "failed reading source for HTML report")
+}
+
+func TestDefaultBrowser(t *testing.T) {
+	table := []struct {
+		goos    string
+		command string
+		args    []string
+	}{
+		{goos: "darwin", command: "open"},
+		{goos: "windows", command: "cmd", args: []string{"/c", "start", ""}},
+		{goos: "linux", command: "xdg-open"},
+	}
+	for _, test := range table {
+		command, args := defaultBrowser(test.goos)
+		assert.Equal(t, test.command, command, test.goos)
+		assert.Equal(t, test.args, args, test.goos)
+	}
+}
+
+func TestOpenInBrowser(t *testing.T) {
+	options := newTestOptions()
+	var opened []string
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		opened = append([]string{command}, args...)
+		return nil, nil
+	}
+	assert.Nil(t, openInBrowser(options, "index.html"))
+	command, args := defaultBrowser(runtime.GOOS)
+	assert.Equal(t, append(append([]string{command}, args...), "index.html"), opened)
+}
+
+func TestHTMLReport(t *testing.T) {
+	options, results, blocks := htmlTestInputs(t)
+	path, err := htmlReport(options, results, blocks)
+	assert.Nil(t, err)
+	assert.Nil(t, path)
+
+	options.htmlReportDir = t.TempDir()
+	path, err = htmlReport(options, results, blocks)
+	assert.Nil(t, err)
+	assert.Nil(t, path)
+	_, err = os.Stat(filepath.Join(options.htmlReportDir, htmlIndexFile))
+	assert.Nil(t, err)
+
+	tempDir := t.TempDir()
+	options.coverageHTML = htmlShowPath
+	options.mkdirTemp = func(string, string) (string, error) {
+		return tempDir, nil
+	}
+	path, err = htmlReport(options, results, blocks)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(tempDir, htmlIndexFile)}, path)
+
+	options.mkdirTemp = func(string, string) (string, error) {
+		return "", errors.New("mkdirTemp failed")
+	}
+	_, err = htmlReport(options, results, blocks)
+	assert.ErrorContains(t, err, "failed creating HTML report directory: mkdirTemp failed")
+
+	options.coverageHTML = htmlOpenInBrowser
+	options.mkdirTemp = func(string, string) (string, error) {
+		return tempDir, nil
+	}
+	opened := []string{}
+	options.getenv = func(name string) string {
+		assert.Equal(t, "BROWSER", name)
+		return "firefox"
+	}
+	options.captureOutput = func(command string, args ...string) ([]string, error) {
+		opened = append(opened, command+" "+strings.Join(args, " "))
+		return nil, nil
+	}
+	path, err = htmlReport(options, results, blocks)
+	assert.Nil(t, err)
+	assert.Nil(t, path)
+	assert.Equal(t, []string{"firefox " + filepath.Join(tempDir, htmlIndexFile)}, opened)
+	options.captureOutput = func(string, ...string) ([]string, error) {
+		return nil, errors.New("no display")
+	}
+	_, err = htmlReport(options, results, blocks)
+	assert.EqualError(t, err, "failed opening HTML report in a browser: no display")
+	options.coverageHTML = htmlShowPath
+
+	options.htmlReportDir = ""
+	options.dirToParse = t.TempDir()
+	options.mkdirTemp = func(string, string) (string, error) {
+		return tempDir, nil
+	}
+	_, err = htmlReport(options, results, blocks)
+	assert.ErrorContains(t, err, "failed reading source for HTML report")
+	options.htmlReportDir = t.TempDir()
+	_, err = htmlReport(options, results, blocks)
+	assert.ErrorContains(t, err, "failed reading source for HTML report")
+}
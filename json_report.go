@@ -0,0 +1,109 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// jsonSchemaVersion is the version of the JSON report written by
+// --format=json.  It must be incremented when fields are removed or their
+// meaning changes; adding fields doesn't require a new version.
+const jsonSchemaVersion = 1
+
+// jsonReport is the top level of the JSON report; see README.md for the
+// documentation of every field.
+type jsonReport struct {
+	SchemaVersion int            `json:"schema_version"`
+	ConfigFile    string         `json:"config_file"`
+	Passed        bool           `json:"passed"`
+	Totals        jsonTotals     `json:"totals"`
+	Functions     []jsonFunction `json:"functions"`
+}
+
+// jsonTotals contains the aggregate totals for the JSON report.
+type jsonTotals struct {
+	Functions         int     `json:"functions"`
+	PassedFunctions   int     `json:"passed_functions"`
+	FailedFunctions   int     `json:"failed_functions"`
+	Statements        int     `json:"statements"`
+	CoveredStatements int     `json:"covered_statements"`
+	Coverage          float64 `json:"coverage"`
+}
+
+// jsonFunction is the result of checking a single function in the JSON report.
+type jsonFunction struct {
+	Filename         string              `json:"filename"`
+	Line             int                 `json:"line"`
+	Function         string              `json:"function"`
+	Receiver         string              `json:"receiver"`
+	Coverage         float64             `json:"coverage"`
+	RuleIndex        int                 `json:"rule_index"`
+	Rule             *coveragecheck.Rule `json:"rule"`
+	RequiredCoverage float64             `json:"required_coverage"`
+	CrapScore        float64             `json:"crap_score"`
+	MaxCrap          float64             `json:"max_crap"`
+	UncoveredLines   string              `json:"uncovered_lines"`
+	Passed           bool                `json:"passed"`
+	Severity         string              `json:"severity"`
+	Violations       []string            `json:"violations"`
+	CoveringTests    []string            `json:"covering_tests"`
+}
+
+// makeJSONReport creates the JSON report used by --format=json from the
+// results of coveragecheck.CheckCoverage, returning the report as a string.
+func makeJSONReport(configFile string, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) string {
+	report := jsonReport{
+		SchemaVersion: jsonSchemaVersion,
+		ConfigFile:    configFile,
+		Passed:        true,
+		Functions:     []jsonFunction{},
+	}
+	report.Totals.Statements, report.Totals.CoveredStatements, report.Totals.Coverage = coveragecheck.StatementCoverage(blocks)
+	for _, result := range results {
+		report.Functions = append(report.Functions, jsonFunction{
+			Filename:         result.Coverage.Filename,
+			Line:             result.Coverage.Line(),
+			Function:         result.Coverage.Function,
+			Receiver:         result.Function.Receiver,
+			Coverage:         result.Coverage.Coverage,
+			RuleIndex:        result.RuleIndex,
+			Rule:             result.Rule,
+			RequiredCoverage: result.RequiredCoverage,
+			CrapScore:        result.CrapScore,
+			MaxCrap:          result.MaxCrap,
+			UncoveredLines:   result.UncoveredLines,
+			Passed:           result.Passed,
+			Severity:         result.Severity,
+			Violations:       result.Messages(),
+			CoveringTests:    result.CoveringTests,
+		})
+		report.Totals.Functions++
+		if result.Passed {
+			report.Totals.PassedFunctions++
+		} else {
+			report.Totals.FailedFunctions++
+		}
+		// Warnings don't cause failure.
+		if result.Status() == coveragecheck.StatusFailed {
+			report.Passed = false
+		}
+	}
+	// Marshalling these types cannot fail.
+	bytes, _ := json.MarshalIndent(report, "", "  ")
+	return string(bytes) + "\n"
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestMakeJSONReport(t *testing.T) {
+	rule := coveragecheck.Rule{
+		Comment:       "Get is important",
+		FunctionRegex: "^Get$",
+		Exported:      boolPointer(true),
+		Coverage:      100,
+	}
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			Function:         coveragecheck.FunctionInfo{Receiver: "Client"},
+			RuleIndex:        3,
+			Rule:             &rule,
+			RequiredCoverage: 100,
+			CrapScore:        2.5,
+			MaxCrap:          10,
+			UncoveredLines:   "api.go:14-16",
+			Passed:           false,
+			Severity:         coveragecheck.SeverityError,
+			Violations:       []coveragecheck.Violation{{Message: "Get is not covered enough"}},
+			CoveringTests:    []string{"TestClient", "TestGet"},
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "20", Function: "put", Coverage: 100},
+			RuleIndex:        -1,
+			RequiredCoverage: 80,
+			CrapScore:        1,
+			Passed:           true,
+			Severity:         coveragecheck.SeverityError,
+		},
+	}
+	blocks := []coveragecheck.ProfileBlock{
+		{NumStatements: 3, Count: 1},
+		{NumStatements: 1, Count: 0},
+	}
+
+	expected := strings.ReplaceAll(`{
+	"schema_version": 1,
+	"config_file": "config.yaml",
+	"passed": false,
+	"totals": {
+		"functions": 2,
+		"passed_functions": 1,
+		"failed_functions": 1,
+		"statements": 4,
+		"covered_statements": 3,
+		"coverage": 75
+	},
+	"functions": [
+		{
+			"filename": "api.go",
+			"line": 12,
+			"function": "Get",
+			"receiver": "Client",
+			"coverage": 50,
+			"rule_index": 3,
+			"rule": {
+				"comment": "Get is important",
+				"filename_regex": "",
+				"function_regex": "^Get$",
+				"receiver_regex": "",
+				"exported": true,
+				"coverage": 100
+			},
+			"required_coverage": 100,
+			"crap_score": 2.5,
+			"max_crap": 10,
+			"uncovered_lines": "api.go:14-16",
+			"passed": false,
+			"severity": "error",
+			"violations": [
+				"Get is not covered enough"
+			],
+			"covering_tests": [
+				"TestClient",
+				"TestGet"
+			]
+		},
+		{
+			"filename": "api.go",
+			"line": 20,
+			"function": "put",
+			"receiver": "",
+			"coverage": 100,
+			"rule_index": -1,
+			"rule": null,
+			"required_coverage": 80,
+			"crap_score": 1,
+			"max_crap": 0,
+			"uncovered_lines": "",
+			"passed": true,
+			"severity": "error",
+			"violations": [],
+			"covering_tests": null
+		}
+	]
+}
+`, "\t", "  ")
+	assert.Equal(t, expected, makeJSONReport("config.yaml", results, blocks))
+}
+
+func TestMakeJSONReportWarning(t *testing.T) {
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:   coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			RuleIndex:  0,
+			Passed:     false,
+			Severity:   coveragecheck.SeverityWarning,
+			Violations: []coveragecheck.Violation{{Message: "Get is not covered enough"}},
+		},
+	}
+	report := makeJSONReport("config.yaml", results, nil)
+	// Warnings don't cause failure.
+	assert.Contains(t, report, "\"schema_version\": 1,\n  \"config_file\": \"config.yaml\",\n  \"passed\": true,")
+	assert.Contains(t, report, `"failed_functions": 1,`)
+	assert.Contains(t, report, `"severity": "warning",`)
+}
+
+func TestMakeJSONReportEmpty(t *testing.T) {
+	report := makeJSONReport("config.yaml", nil, nil)
+	assert.Contains(t, report, `"passed": true,`)
+	assert.Contains(t, report, `"functions": []`)
+}
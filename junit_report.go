@@ -0,0 +1,142 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// The types in this file are the commonly supported subset of JUnit XML used
+// by --format=junit.
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Line      string        `xml:"line,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// packageName returns the import path of the package containing filename,
+// where filename has had modulePath removed by
+// coveragecheck.ParseCoverageOutput.
+func packageName(modulePath, filename string) string {
+	dir := path.Dir(filename)
+	if dir == "." {
+		return strings.TrimSuffix(modulePath, "/")
+	}
+	return modulePath + dir
+}
+
+// qualifiedFunctionName returns the function name, prefixed with the receiver
+// for methods, e.g. Client.Get.
+func qualifiedFunctionName(result coveragecheck.CheckResult) string {
+	if result.Function.Receiver == "" {
+		return result.Coverage.Function
+	}
+	return result.Function.Receiver + "." + result.Coverage.Function
+}
+
+// matchingRuleDescription describes the rule or default that a function was
+// checked against.
+func matchingRuleDescription(result coveragecheck.CheckResult) string {
+	if result.Rule == nil {
+		return "default coverage"
+	}
+	return fmt.Sprintf("rule %d: %v", result.RuleIndex, *result.Rule)
+}
+
+// makeJUnitReport creates the JUnit XML report used by --format=junit from
+// the results of coveragecheck.CheckCoverage, returning the report as a
+// string.  There is a testsuite for each package and a testcase for each
+// function; violations of rules with coveragecheck.SeverityWarning are
+// included in the output of the testcase rather than being failures.
+func makeJUnitReport(modulePath string, results []coveragecheck.CheckResult) string {
+	suites := map[string]*junitTestSuite{}
+	report := junitTestSuites{Name: "golang-coverage-check"}
+	for _, result := range results {
+		pkg := packageName(modulePath, result.Coverage.Filename)
+		suite, ok := suites[pkg]
+		if !ok {
+			suite = &junitTestSuite{Name: pkg}
+			suites[pkg] = suite
+		}
+		testCase := junitTestCase{
+			Name:      qualifiedFunctionName(result),
+			ClassName: pkg,
+			File:      result.Coverage.Filename,
+			Line:      result.Coverage.LineNumber,
+		}
+		details := strings.Join(result.Messages(), "\n") + "\nmatching rule: " + matchingRuleDescription(result)
+		if result.Status() == coveragecheck.StatusFailed {
+			kinds := []string{}
+			for _, violation := range result.Violations {
+				kinds = append(kinds, violation.Kind)
+			}
+			testCase.Failure = &junitFailure{
+				Message: strings.Join(result.Messages(), "; "),
+				Type:    strings.Join(kinds, ","),
+				Text:    details,
+			}
+			suite.Failures++
+			report.Failures++
+		} else if result.Status() == coveragecheck.StatusWarning {
+			testCase.SystemOut = "warning: " + details
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Tests++
+		report.Tests++
+	}
+
+	names := []string{}
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.TestSuites = append(report.TestSuites, *suites[name])
+	}
+	// Marshalling these types cannot fail.
+	bytes, _ := xml.MarshalIndent(report, "", "  ")
+	return xml.Header + string(bytes) + "\n"
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestPackageName(t *testing.T) {
+	assert.Equal(t, "example.com/mod", packageName("example.com/mod/", "main.go"))
+	assert.Equal(t, "example.com/mod/sub/pkg", packageName("example.com/mod/", "sub/pkg/api.go"))
+}
+
+func TestMakeJUnitReport(t *testing.T) {
+	rule := coveragecheck.Rule{
+		FunctionRegex: "^Get$",
+		Coverage:      100,
+	}
+	warningRule := coveragecheck.Rule{
+		FunctionRegex: "^Put$",
+		Coverage:      100,
+		Severity:      coveragecheck.SeverityWarning,
+	}
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "sub/api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			Function:         coveragecheck.FunctionInfo{Receiver: "Client"},
+			RuleIndex:        0,
+			Rule:             &rule,
+			RequiredCoverage: 100,
+			Passed:           false,
+			Severity:         coveragecheck.SeverityError,
+			Violations: []coveragecheck.Violation{
+				{Kind: coveragecheck.ViolationCoverage, Message: "Get < 100%"},
+				{Kind: coveragecheck.ViolationCrap, Message: "Get CRAP > 10"},
+			},
+		},
+		{
+			// Sufficient coverage but too complex.
+			Coverage:         coveragecheck.CoverageLine{Filename: "sub/api.go", LineNumber: "30", Function: "Delete", Coverage: 100},
+			RuleIndex:        -1,
+			RequiredCoverage: 80,
+			CrapScore:        42,
+			MaxCrap:          30,
+			Passed:           false,
+			Severity:         coveragecheck.SeverityError,
+			Violations:       []coveragecheck.Violation{{Kind: coveragecheck.ViolationCrap, Message: "Delete CRAP 42.0 > 30.0"}},
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "sub/api.go", LineNumber: "20", Function: "Put", Coverage: 50},
+			RuleIndex:        1,
+			Rule:             &warningRule,
+			RequiredCoverage: 100,
+			Passed:           false,
+			Severity:         coveragecheck.SeverityWarning,
+			Violations:       []coveragecheck.Violation{{Message: "Put < 100%"}},
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "main.go", LineNumber: "3", Function: "main", Coverage: 100},
+			RuleIndex:        -1,
+			RequiredCoverage: 80,
+			Passed:           true,
+			Severity:         coveragecheck.SeverityError,
+		},
+	}
+	expected := strings.ReplaceAll(`<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="golang-coverage-check" tests="4" failures="2">
+	<testsuite name="example.com/mod" tests="1" failures="0">
+		<testcase name="main" classname="example.com/mod" file="main.go" line="3"></testcase>
+	</testsuite>
+	<testsuite name="example.com/mod/sub" tests="3" failures="2">
+		<testcase name="Client.Get" classname="example.com/mod/sub" file="sub/api.go" line="12">
+			<failure message="Get &lt; 100%; Get CRAP &gt; 10" type="coverage,crap">Get &lt; 100%&#xA;Get CRAP &gt; 10&#xA;matching rule: rule 0: FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 100 Comment: </failure>
+		</testcase>
+		<testcase name="Delete" classname="example.com/mod/sub" file="sub/api.go" line="30">
+			<failure message="Delete CRAP 42.0 &gt; 30.0" type="crap">Delete CRAP 42.0 &gt; 30.0&#xA;matching rule: default coverage</failure>
+		</testcase>
+		<testcase name="Put" classname="example.com/mod/sub" file="sub/api.go" line="20">
+			<system-out>warning: Put &lt; 100%&#xA;matching rule: rule 1: FilenameRegex:  FunctionRegex: ^Put$ ReceiverRegex:  Severity: warning Coverage: 100 Comment: </system-out>
+		</testcase>
+	</testsuite>
+</testsuites>
+`, "\t", "  ")
+	assert.Equal(t, expected, makeJUnitReport("example.com/mod/", results))
+}
+
+func TestMatchingRuleDescription(t *testing.T) {
+	assert.Equal(t, "default coverage", matchingRuleDescription(coveragecheck.CheckResult{RuleIndex: -1}))
+}
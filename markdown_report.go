@@ -0,0 +1,133 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// markdownRuleComment describes the rule a function was checked against using
+// the rule's comment, falling back to the rule's index when it has no comment.
+func markdownRuleComment(result coveragecheck.CheckResult) string {
+	if result.Rule == nil {
+		return "default"
+	}
+	if result.Rule.Comment == "" {
+		return fmt.Sprintf("rule %d", result.RuleIndex)
+	}
+	return escapeMarkdownCell(result.Rule.Comment)
+}
+
+// markdownResult describes whether a function passed its checks.
+func markdownResult(result coveragecheck.CheckResult) string {
+	switch result.Status() {
+	case coveragecheck.StatusPassed:
+		return "pass"
+	case coveragecheck.StatusWarning:
+		return "warning"
+	}
+	return "**fail**"
+}
+
+// makeMarkdownReport creates the Markdown report used by --format=markdown
+// from the results of coveragecheck.CheckCoverage, returning the report as a
+// string.  The report only depends on its inputs, so a bot can compare it
+// with a previously posted comment and only update the comment when it
+// changes.
+func makeMarkdownReport(modulePath string, results []coveragecheck.CheckResult, blocks []coveragecheck.ProfileBlock) string {
+	failed, warnings := 0, 0
+	for _, result := range results {
+		if result.Status() == coveragecheck.StatusFailed {
+			failed++
+		} else if result.Status() == coveragecheck.StatusWarning {
+			warnings++
+		}
+	}
+	total, covered, percentage := coveragecheck.StatementCoverage(blocks)
+	lines := []string{
+		"## Coverage report",
+		"",
+		fmt.Sprintf("**Total coverage: %.1f%%** (%d of %d statements); %d of %d functions failed, %d with warnings.",
+			percentage, covered, total, failed, len(results), warnings),
+		"",
+		"| Package | Coverage | Statements |",
+		"| --- | ---: | ---: |",
+	}
+
+	byPackage := packageBlocks(modulePath, blocks)
+	names := []string{}
+	for name := range byPackage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		total, covered, percentage := coveragecheck.StatementCoverage(byPackage[name])
+		lines = append(lines, fmt.Sprintf("| `%s` | %.1f%% | %d/%d |", name, percentage, covered, total))
+	}
+
+	lines = append(lines, "", "### Failing functions", "")
+	if failed+warnings == 0 {
+		lines = append(lines, "Every function meets its coverage requirements.")
+	} else {
+		lines = append(lines,
+			"| Function | Location | Coverage | Required | Rule | Result |",
+			"| --- | --- | ---: | ---: | --- | --- |")
+		for _, result := range results {
+			if !result.Passed {
+				lines = append(lines, markdownFunctionRow(result))
+			}
+		}
+	}
+
+	lines = append(lines,
+		"",
+		"<details>",
+		fmt.Sprintf("<summary>All functions (%d)</summary>", len(results)),
+		"",
+		"| Function | Location | Coverage | Required | Rule | Result |",
+		"| --- | --- | ---: | ---: | --- | --- |")
+	for _, result := range results {
+		lines = append(lines, markdownFunctionRow(result))
+	}
+	lines = append(lines, "", "</details>")
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// packageBlocks groups blocks by the package they are in.
+func packageBlocks(modulePath string, blocks []coveragecheck.ProfileBlock) map[string][]coveragecheck.ProfileBlock {
+	byPackage := map[string][]coveragecheck.ProfileBlock{}
+	for _, block := range blocks {
+		name := packageName(modulePath, block.Filename)
+		byPackage[name] = append(byPackage[name], block)
+	}
+	return byPackage
+}
+
+// markdownFunctionRow formats a row of the function tables in the Markdown
+// report.
+func markdownFunctionRow(result coveragecheck.CheckResult) string {
+	return fmt.Sprintf("| `%s` | %s:%s | %.1f%% | %.1f%% | %s | %s |",
+		qualifiedFunctionName(result),
+		escapeMarkdownCell(result.Coverage.Filename),
+		result.Coverage.LineNumber,
+		result.Coverage.Coverage,
+		result.RequiredCoverage,
+		markdownRuleComment(result),
+		markdownResult(result))
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestMakeMarkdownReport(t *testing.T) {
+	commented := coveragecheck.Rule{FunctionRegex: "^Get$", Coverage: 100, Comment: "Clients | servers"}
+	uncommented := coveragecheck.Rule{FunctionRegex: "^Put$", Coverage: 90, Severity: coveragecheck.SeverityWarning}
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "sub/api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			Function:         coveragecheck.FunctionInfo{Receiver: "Client"},
+			RuleIndex:        0,
+			Rule:             &commented,
+			RequiredCoverage: 100,
+			Severity:         coveragecheck.SeverityError,
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "sub/api.go", LineNumber: "20", Function: "Put", Coverage: 75},
+			RuleIndex:        1,
+			Rule:             &uncommented,
+			RequiredCoverage: 90,
+			Severity:         coveragecheck.SeverityWarning,
+		},
+		{
+			Coverage:         coveragecheck.CoverageLine{Filename: "main.go", LineNumber: "3", Function: "main", Coverage: 100},
+			RuleIndex:        -1,
+			RequiredCoverage: 80,
+			Passed:           true,
+			Severity:         coveragecheck.SeverityError,
+		},
+	}
+	blocks := []coveragecheck.ProfileBlock{
+		{Filename: "main.go", NumStatements: 2, Count: 1},
+		{Filename: "sub/api.go", NumStatements: 3, Count: 1},
+		{Filename: "sub/api.go", NumStatements: 3, Count: 0},
+	}
+	expected := "## Coverage report\n" +
+		"\n" +
+		"**Total coverage: 62.5%** (5 of 8 statements); 1 of 3 functions failed, 1 with warnings.\n" +
+		"\n" +
+		"| Package | Coverage | Statements |\n" +
+		"| --- | ---: | ---: |\n" +
+		"| `example.com/mod` | 100.0% | 2/2 |\n" +
+		"| `example.com/mod/sub` | 50.0% | 3/6 |\n" +
+		"\n" +
+		"### Failing functions\n" +
+		"\n" +
+		"| Function | Location | Coverage | Required | Rule | Result |\n" +
+		"| --- | --- | ---: | ---: | --- | --- |\n" +
+		"| `Client.Get` | sub/api.go:12 | 50.0% | 100.0% | Clients \\| servers | **fail** |\n" +
+		"| `Put` | sub/api.go:20 | 75.0% | 90.0% | rule 1 | warning |\n" +
+		"\n" +
+		"<details>\n" +
+		"<summary>All functions (3)</summary>\n" +
+		"\n" +
+		"| Function | Location | Coverage | Required | Rule | Result |\n" +
+		"| --- | --- | ---: | ---: | --- | --- |\n" +
+		"| `Client.Get` | sub/api.go:12 | 50.0% | 100.0% | Clients \\| servers | **fail** |\n" +
+		"| `Put` | sub/api.go:20 | 75.0% | 90.0% | rule 1 | warning |\n" +
+		"| `main` | main.go:3 | 100.0% | 80.0% | default | pass |\n" +
+		"\n" +
+		"</details>\n"
+	assert.Equal(t, expected, makeMarkdownReport("example.com/mod/", results, blocks))
+
+	expected = "## Coverage report\n" +
+		"\n" +
+		"**Total coverage: 0.0%** (0 of 0 statements); 0 of 1 functions failed, 0 with warnings.\n" +
+		"\n" +
+		"| Package | Coverage | Statements |\n" +
+		"| --- | ---: | ---: |\n" +
+		"\n" +
+		"### Failing functions\n" +
+		"\n" +
+		"Every function meets its coverage requirements.\n" +
+		"\n" +
+		"<details>\n" +
+		"<summary>All functions (1)</summary>\n" +
+		"\n" +
+		"| Function | Location | Coverage | Required | Rule | Result |\n" +
+		"| --- | --- | ---: | ---: | --- | --- |\n" +
+		"| `main` | main.go:3 | 100.0% | 80.0% | default | pass |\n" +
+		"\n" +
+		"</details>\n"
+	assert.Equal(t, expected, makeMarkdownReport("example.com/mod/", results[2:], nil))
+}
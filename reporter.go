@@ -0,0 +1,135 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// Reporter renders the results of checking coverage in one of outputFormats.
+type Reporter interface {
+	// Report returns the report for run.
+	Report(run coveragecheck.Report) string
+}
+
+// reporterFunc adapts a function to the Reporter interface.
+type reporterFunc func(run coveragecheck.Report) string
+
+// Report calls f(run).
+func (f reporterFunc) Report(run coveragecheck.Report) string {
+	return f(run)
+}
+
+// joinLines joins lines into a report, with a trailing newline unless there
+// are no lines.
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// makeTextReport creates the report for --report=text, with a line for every
+// violation prefixed by its severity, and a line for every warning.
+func makeTextReport(results []coveragecheck.CheckResult) string {
+	lines := []string{}
+	for _, result := range results {
+		for _, warning := range result.Warnings {
+			lines = append(lines, coveragecheck.SeverityWarning+": "+warning)
+		}
+		if result.Status() == coveragecheck.StatusPassed {
+			continue
+		}
+		for _, message := range result.Messages() {
+			lines = append(lines, result.Severity+": "+message)
+		}
+	}
+	return joinLines(lines)
+}
+
+// newReporter returns the Reporter for format, which must be one of
+// outputFormats.
+func newReporter(options Options, format string) Reporter {
+	switch format {
+	case formatJSON:
+		return reporterFunc(func(run coveragecheck.Report) string {
+			return makeJSONReport(options.configFile, run.Results, run.Blocks)
+		})
+	case formatSARIF:
+		return reporterFunc(func(run coveragecheck.Report) string {
+			return makeSARIFReport(run.Config, run.Results)
+		})
+	case formatJUnit:
+		return reporterFunc(func(run coveragecheck.Report) string {
+			return makeJUnitReport(options.modulePath, run.Results)
+		})
+	case formatGitHub:
+		return reporterFunc(func(run coveragecheck.Report) string {
+			return joinLines(makeGitHubAnnotations(run.Results))
+		})
+	case formatMarkdown:
+		return reporterFunc(func(run coveragecheck.Report) string {
+			return makeMarkdownReport(options.modulePath, run.Results, run.Blocks)
+		})
+	}
+	return reporterFunc(func(run coveragecheck.Report) string {
+		return makeTextReport(run.Results)
+	})
+}
+
+// reportOutput is a report requested by --report.
+type reportOutput struct {
+	format string
+	path   string
+}
+
+// reportOutputs implements flag.Value so that --report can be repeated.
+type reportOutputs []reportOutput
+
+// String returns the reports in the same form as the --report flag.
+func (outputs *reportOutputs) String() string {
+	values := []string{}
+	for _, output := range *outputs {
+		values = append(values, output.format+":"+output.path)
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses value, which must be FORMAT:PATH, and appends it to the reports.
+func (outputs *reportOutputs) Set(value string) error {
+	format, path, found := strings.Cut(value, ":")
+	if !found || path == "" {
+		return fmt.Errorf("expected FORMAT:PATH, got %q", value)
+	}
+	if !isOutputFormat(format) {
+		return fmt.Errorf("unrecognised format %q; valid formats are %q", format, outputFormats)
+	}
+	*outputs = append(*outputs, reportOutput{format: format, path: path})
+	return nil
+}
+
+// writeReports writes every report requested by --report.
+func writeReports(options Options, run coveragecheck.Report) error {
+	for _, output := range options.reports {
+		report := newReporter(options, output.format).Report(run)
+		if err := options.writeFile(output.path, []byte(report), 0644); err != nil {
+			return fmt.Errorf("failed writing %s report to %v: %w", output.format, output.path, err)
+		}
+	}
+	return nil
+}
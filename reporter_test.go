@@ -0,0 +1,125 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestMakeTextReport(t *testing.T) {
+	results := []coveragecheck.CheckResult{
+		{
+			Passed:     false,
+			Severity:   coveragecheck.SeverityError,
+			Violations: []coveragecheck.Violation{{Message: "Get < 100%"}, {Message: "Get CRAP > 10"}},
+		},
+		{
+			Passed:     false,
+			Severity:   coveragecheck.SeverityWarning,
+			Violations: []coveragecheck.Violation{{Message: "Put < 100%"}},
+		},
+		{
+			Passed:   true,
+			Severity: coveragecheck.SeverityError,
+		},
+		{
+			Passed:   true,
+			Severity: coveragecheck.SeverityError,
+			Warnings: []string{"init matches a generated rule"},
+		},
+	}
+	assert.Equal(t, "error: Get < 100%\nerror: Get CRAP > 10\nwarning: Put < 100%\nwarning: init matches a generated rule\n", makeTextReport(results))
+	assert.Equal(t, "", makeTextReport(results[2:3]))
+}
+
+func TestNewReporter(t *testing.T) {
+	run := coveragecheck.Report{
+		Results: []coveragecheck.CheckResult{
+			{
+				Coverage:   coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+				Passed:     false,
+				Severity:   coveragecheck.SeverityError,
+				RuleIndex:  -1,
+				Violations: []coveragecheck.Violation{{Message: "Get < 100%"}},
+			},
+		},
+	}
+	options := newTestOptions()
+	options.modulePath = "example.com/mod/"
+	expected := map[string]string{
+		formatText:     "error: Get < 100%\n",
+		formatJSON:     "\"schema_version\": 1,",
+		formatSARIF:    "\"version\": \"2.1.0\",",
+		formatJUnit:    "<testcase name=\"Get\" classname=\"example.com/mod\" file=\"api.go\" line=\"12\">",
+		formatGitHub:   "::error file=api.go,line=12,title=Coverage check failed for Get::Get < 100%25\n",
+		formatMarkdown: "| `Get` | api.go:12 | 50.0% |",
+	}
+	for _, format := range outputFormats {
+		assert.Contains(t, newReporter(options, format).Report(run), expected[format], format)
+	}
+}
+
+func TestReportOutputs(t *testing.T) {
+	outputs := reportOutputs{}
+	assert.Equal(t, "", outputs.String())
+	assert.Nil(t, outputs.Set("json:coverage.json"))
+	assert.Nil(t, outputs.Set(`junit:C:\coverage.xml`))
+	assert.Equal(t, reportOutputs{
+		{format: formatJSON, path: "coverage.json"},
+		{format: formatJUnit, path: `C:\coverage.xml`},
+	}, outputs)
+	assert.Equal(t, `json:coverage.json,junit:C:\coverage.xml`, outputs.String())
+
+	assert.ErrorContains(t, outputs.Set("json"), `expected FORMAT:PATH, got "json"`)
+	assert.ErrorContains(t, outputs.Set("json:"), `expected FORMAT:PATH, got "json:"`)
+	assert.ErrorContains(t, outputs.Set("xml:coverage.xml"), `unrecognised format "xml"; valid formats are`)
+	assert.Equal(t, 2, len(outputs))
+}
+
+func TestWriteReports(t *testing.T) {
+	options := newTestOptions()
+	options.reports = reportOutputs{
+		{format: formatText, path: "coverage.txt"},
+		{format: formatMarkdown, path: "coverage.md"},
+	}
+	written := map[string]string{}
+	options.writeFile = func(path string, data []byte, mode os.FileMode) error {
+		assert.Equal(t, os.FileMode(0644), mode)
+		written[path] = string(data)
+		return nil
+	}
+	run := coveragecheck.Report{
+		Results: []coveragecheck.CheckResult{
+			{
+				Coverage: coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 100},
+				Passed:   true,
+				Severity: coveragecheck.SeverityError,
+			},
+		},
+	}
+	assert.Nil(t, writeReports(options, run))
+	assert.Equal(t, "", written["coverage.txt"])
+	assert.Contains(t, written["coverage.md"], "| `Get` | api.go:12 | 100.0% |")
+
+	options.writeFile = func(string, []byte, os.FileMode) error {
+		return errors.New("writeFile failed")
+	}
+	assert.EqualError(t, writeReports(options, run), "failed writing text report to coverage.txt: writeFile failed")
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// The types in this file are the subset of SARIF 2.1.0 used by --format=sarif;
+// see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifDefaultRuleID is the SARIF rule ID for the default coverage.
+const sarifDefaultRuleID = "default"
+
+// sarifRuleIDs returns the SARIF rule ID for each rule in config.Rules.  Code
+// scanning tracks alerts by rule ID, so the ID is a hash of the fields that
+// select functions rather than the rule's position, which changes when rules
+// are added or reordered, or its limits, which change when coverage improves.
+// Rules that select the same functions are numbered in order, e.g.
+// rule-0123456789ab and rule-0123456789ab-2.
+func sarifRuleIDs(config coveragecheck.Config) []string {
+	ids := []string{}
+	seen := map[string]int{}
+	for _, rule := range config.Rules {
+		selector := fmt.Sprintf("%q %q %q", rule.FilenameRegex, rule.FunctionRegex, rule.ReceiverRegex)
+		// Optional fields are only included when they are set.
+		if rule.Exported != nil {
+			selector += fmt.Sprintf(" exported:%v", *rule.Exported)
+		}
+		if rule.ReturnsError != nil {
+			selector += fmt.Sprintf(" returns_error:%v", *rule.ReturnsError)
+		}
+		if rule.ParamCount != nil {
+			selector += fmt.Sprintf(" param_count:%v", *rule.ParamCount)
+		}
+		if rule.Ordinal != 0 {
+			selector += fmt.Sprintf(" ordinal:%v", rule.Ordinal)
+		}
+		id := fmt.Sprintf("rule-%x", sha256.Sum256([]byte(selector)))[:len("rule-")+12]
+		seen[id]++
+		if seen[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, seen[id])
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// makeSARIFReport creates the SARIF log used by --format=sarif from the
+// results of coveragecheck.CheckCoverage, returning the log as a string.
+// Every rule in config is described in the log, preceded by a rule for the
+// default coverage, so a result's ruleIndex is one more than
+// CheckResult.RuleIndex.
+func makeSARIFReport(config coveragecheck.Config, results []coveragecheck.CheckResult) string {
+	ids := sarifRuleIDs(config)
+	driver := sarifDriver{
+		Name:           "golang-coverage-check",
+		InformationURI: "https://github.com/tobinjt/golang-coverage-check",
+		Rules: []sarifRule{
+			{
+				ID:               sarifDefaultRuleID,
+				ShortDescription: sarifMessage{Text: "Default coverage"},
+				FullDescription: sarifMessage{Text: fmt.Sprintf("DefaultCoverage: %v DefaultMaxCrap: %v",
+					config.DefaultCoverage, config.DefaultMaxCrap)},
+				DefaultConfiguration: sarifConfiguration{Level: coveragecheck.SeverityError},
+			},
+		},
+	}
+	for i, rule := range config.Rules {
+		description := rule.Comment
+		if description == "" {
+			description = rule.String()
+		}
+		level := rule.Severity
+		if level == "" {
+			level = coveragecheck.SeverityError
+		}
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   ids[i],
+			ShortDescription:     sarifMessage{Text: description},
+			FullDescription:      sarifMessage{Text: rule.String()},
+			DefaultConfiguration: sarifConfiguration{Level: level},
+		})
+	}
+
+	run := sarifRun{
+		Tool:    sarifTool{Driver: driver},
+		Results: []sarifResult{},
+	}
+	for _, result := range results {
+		id := sarifDefaultRuleID
+		if result.RuleIndex >= 0 {
+			id = ids[result.RuleIndex]
+		}
+		for _, message := range result.Messages() {
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    id,
+				RuleIndex: result.RuleIndex + 1,
+				Level:     result.Severity,
+				Message:   sarifMessage{Text: message},
+				Locations: []sarifLocation{
+					{
+						PhysicalLocation: sarifPhysicalLocation{
+							ArtifactLocation: sarifArtifactLocation{URI: result.Coverage.Filename},
+							Region:           sarifRegion{StartLine: result.Coverage.Line()},
+						},
+					},
+				},
+			})
+		}
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	// Marshalling these types cannot fail.
+	bytes, _ := json.MarshalIndent(log, "", "  ")
+	return string(bytes) + "\n"
+}
@@ -0,0 +1,232 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func TestSARIFRuleIDs(t *testing.T) {
+	exported := true
+	get := coveragecheck.Rule{FunctionRegex: "^Get$", Coverage: 100}
+	put := coveragecheck.Rule{FunctionRegex: "^Put$", Coverage: 90}
+	exportedPut := coveragecheck.Rule{FunctionRegex: "^Put$", Exported: &exported, Coverage: 90}
+	paramCount := 2
+	allFields := coveragecheck.Rule{FunctionRegex: "^Put$", Exported: &exported, ReturnsError: &exported, ParamCount: &paramCount, Ordinal: 1}
+	ids := sarifRuleIDs(coveragecheck.Config{Rules: []coveragecheck.Rule{get, put, exportedPut, allFields}})
+	assert.Equal(t, []string{"rule-5644841d4885", "rule-81e7046c80dc", "rule-a7e1287d4662", "rule-c83a99a97324"}, ids)
+
+	// Reordering rules, adding rules, and changing limits don't change IDs.
+	put.Coverage = 95
+	assert.Equal(t, []string{"rule-81e7046c80dc", "rule-5644841d4885"},
+		sarifRuleIDs(coveragecheck.Config{Rules: []coveragecheck.Rule{put, get}}))
+	// Rules selecting the same functions are numbered.
+	assert.Equal(t, []string{"rule-5644841d4885", "rule-5644841d4885-2"},
+		sarifRuleIDs(coveragecheck.Config{Rules: []coveragecheck.Rule{get, get}}))
+}
+
+func TestMakeSARIFReport(t *testing.T) {
+	config := coveragecheck.Config{
+		DefaultCoverage: 80,
+		Rules: []coveragecheck.Rule{
+			{
+				Comment:       "Get is important",
+				FunctionRegex: "^Get$",
+				Coverage:      100,
+			},
+			{
+				FunctionRegex: "^Put$",
+				Coverage:      90,
+				Severity:      coveragecheck.SeverityWarning,
+			},
+		},
+	}
+	results := []coveragecheck.CheckResult{
+		{
+			Coverage:   coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "12", Function: "Get", Coverage: 50},
+			RuleIndex:  0,
+			Passed:     false,
+			Severity:   coveragecheck.SeverityError,
+			Violations: []coveragecheck.Violation{{Message: "Get coverage"}, {Message: "Get CRAP"}},
+		},
+		{
+			Coverage:   coveragecheck.CoverageLine{Filename: "api.go", LineNumber: "20", Function: "Put", Coverage: 50},
+			RuleIndex:  1,
+			Passed:     false,
+			Severity:   coveragecheck.SeverityWarning,
+			Violations: []coveragecheck.Violation{{Message: "Put coverage"}},
+		},
+		{
+			Coverage:   coveragecheck.CoverageLine{Filename: "main.go", LineNumber: "3", Function: "main", Coverage: 0},
+			RuleIndex:  -1,
+			Passed:     false,
+			Severity:   coveragecheck.SeverityError,
+			Violations: []coveragecheck.Violation{{Message: "main coverage"}},
+		},
+		{
+			Coverage:  coveragecheck.CoverageLine{Filename: "main.go", LineNumber: "9", Function: "helper", Coverage: 100},
+			RuleIndex: -1,
+			Passed:    true,
+			Severity:  coveragecheck.SeverityError,
+		},
+	}
+
+	report := makeSARIFReport(config, results)
+	expected := strings.ReplaceAll(`{
+	"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+	"version": "2.1.0",
+	"runs": [
+		{
+			"tool": {
+				"driver": {
+					"name": "golang-coverage-check",
+					"informationUri": "https://github.com/tobinjt/golang-coverage-check",
+					"rules": [
+						{
+							"id": "default",
+							"shortDescription": {
+								"text": "Default coverage"
+							},
+							"fullDescription": {
+								"text": "DefaultCoverage: 80 DefaultMaxCrap: 0"
+							},
+							"defaultConfiguration": {
+								"level": "error"
+							}
+						},
+						{
+							"id": "rule-5644841d4885",
+							"shortDescription": {
+								"text": "Get is important"
+							},
+							"fullDescription": {
+								"text": "FilenameRegex:  FunctionRegex: ^Get$ ReceiverRegex:  Coverage: 100 Comment: Get is important"
+							},
+							"defaultConfiguration": {
+								"level": "error"
+							}
+						},
+						{
+							"id": "rule-81e7046c80dc",
+							"shortDescription": {
+								"text": "FilenameRegex:  FunctionRegex: ^Put$ ReceiverRegex:  Severity: warning Coverage: 90 Comment: "
+							},
+							"fullDescription": {
+								"text": "FilenameRegex:  FunctionRegex: ^Put$ ReceiverRegex:  Severity: warning Coverage: 90 Comment: "
+							},
+							"defaultConfiguration": {
+								"level": "warning"
+							}
+						}
+					]
+				}
+			},
+			"results": [
+				{
+					"ruleId": "rule-5644841d4885",
+					"ruleIndex": 1,
+					"level": "error",
+					"message": {
+						"text": "Get coverage"
+					},
+					"locations": [
+						{
+							"physicalLocation": {
+								"artifactLocation": {
+									"uri": "api.go"
+								},
+								"region": {
+									"startLine": 12
+								}
+							}
+						}
+					]
+				},
+				{
+					"ruleId": "rule-5644841d4885",
+					"ruleIndex": 1,
+					"level": "error",
+					"message": {
+						"text": "Get CRAP"
+					},
+					"locations": [
+						{
+							"physicalLocation": {
+								"artifactLocation": {
+									"uri": "api.go"
+								},
+								"region": {
+									"startLine": 12
+								}
+							}
+						}
+					]
+				},
+				{
+					"ruleId": "rule-81e7046c80dc",
+					"ruleIndex": 2,
+					"level": "warning",
+					"message": {
+						"text": "Put coverage"
+					},
+					"locations": [
+						{
+							"physicalLocation": {
+								"artifactLocation": {
+									"uri": "api.go"
+								},
+								"region": {
+									"startLine": 20
+								}
+							}
+						}
+					]
+				},
+				{
+					"ruleId": "default",
+					"ruleIndex": 0,
+					"level": "error",
+					"message": {
+						"text": "main coverage"
+					},
+					"locations": [
+						{
+							"physicalLocation": {
+								"artifactLocation": {
+									"uri": "main.go"
+								},
+								"region": {
+									"startLine": 3
+								}
+							}
+						}
+					]
+				}
+			]
+		}
+	]
+}
+`, "\t", "  ")
+	assert.Equal(t, expected, report)
+}
+
+func TestMakeSARIFReportEmpty(t *testing.T) {
+	report := makeSARIFReport(coveragecheck.Config{}, nil)
+	assert.Contains(t, report, `"results": []`)
+}
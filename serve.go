@@ -0,0 +1,147 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// rerunPath is the URL path that reruns the tests when POSTed to.
+const rerunPath = "/rerun"
+
+// localServeAddr checks that addr, the argument to --serve, is a loopback
+// address, because the report includes source code and the rerun endpoint
+// runs the tests.  An empty host is replaced with localhost.
+func localServeAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("bad address for --serve: %w", err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("--serve only listens on localhost, and %q is not a loopback address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// reportServer serves the HTML report from renderHTMLReport for --serve.
+type reportServer struct {
+	options Options
+	// mutex protects pages, which is replaced by every rerun.
+	mutex sync.Mutex
+	pages map[string][]byte
+}
+
+// update renders the HTML report for run and replaces the pages being served.
+func (server *reportServer) update(run coveragecheck.Report) error {
+	pages, err := renderHTMLReport(server.options, run.Results, run.Blocks, true)
+	if err != nil {
+		return err
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.pages = pages
+	return nil
+}
+
+// addressedToServer reports whether request is addressed to
+// options.serveAddr, or to localhost on the same port, rejecting DNS
+// rebinding, where another site's hostname resolves to a loopback address so
+// that its pages can read the report and run the tests.  A Host without a port
+// uses port 80, like browsers do.
+func (server *reportServer) addressedToServer(request *http.Request) bool {
+	host, port, err := net.SplitHostPort(request.Host)
+	if err != nil {
+		host, port = request.Host, "80"
+	}
+	// options.serveAddr was checked by localServeAddr.
+	serveHost, servePort, _ := net.SplitHostPort(server.options.serveAddr)
+	return port == servePort && (host == serveHost || host == "localhost")
+}
+
+// sameOrigin reports whether request was sent by the report itself: its
+// Origin, or Referer for browsers that don't send Origin, must be the report,
+// rejecting other sites that POST to rerunPath to run the tests.
+func (server *reportServer) sameOrigin(request *http.Request) bool {
+	origin := "http://" + request.Host
+	if request.Header.Get("Origin") != "" {
+		return request.Header.Get("Origin") == origin
+	}
+	return strings.HasPrefix(request.Header.Get("Referer"), origin+"/")
+}
+
+// ServeHTTP serves the pages of the HTML report, and reruns the tests when
+// rerunPath is POSTed to by the report before redirecting back to the index
+// page.  Every request must be addressed to the server.
+func (server *reportServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !server.addressedToServer(request) {
+		http.Error(writer, fmt.Sprintf("the report is only served on %v", server.options.serveAddr), http.StatusForbidden)
+		return
+	}
+	if request.URL.Path == rerunPath {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "rerunning the tests requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !server.sameOrigin(request) {
+			http.Error(writer, "rerunning the tests is only allowed from the report", http.StatusForbidden)
+			return
+		}
+		run, err := runCheck(server.options)
+		if err == nil {
+			err = server.update(run)
+		}
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("rerunning the tests failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimPrefix(request.URL.Path, "/")
+	if name == "" {
+		name = htmlIndexFile
+	}
+	server.mutex.Lock()
+	page, ok := server.pages[name]
+	server.mutex.Unlock()
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// There's nothing useful to do if the client has gone away.
+	_, _ = writer.Write(page)
+}
+
+// serveReport serves the HTML report for run on options.serveAddr until the
+// server fails.
+func serveReport(options Options, run coveragecheck.Report) error {
+	server := &reportServer{options: options}
+	if err := server.update(run); err != nil {
+		return err
+	}
+	fmt.Fprintf(options.stdout, "Serving the coverage report on http://%s/\n", options.serveAddr)
+	return options.listenAndServe(options.serveAddr, server)
+}
@@ -0,0 +1,290 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalServeAddr(t *testing.T) {
+	table := []struct {
+		addr     string
+		expected string
+		err      string
+	}{
+		{addr: ":8080", expected: "localhost:8080"},
+		{addr: "localhost:8080", expected: "localhost:8080"},
+		{addr: "127.0.0.1:0", expected: "127.0.0.1:0"},
+		{addr: "[::1]:8080", expected: "[::1]:8080"},
+		{addr: "8080", err: "bad address for --serve: address 8080: missing port in address"},
+		{addr: "0.0.0.0:8080", err: "--serve only listens on localhost, and \"0.0.0.0\" is not a loopback address"},
+		{addr: "example.com:8080", err: "--serve only listens on localhost, and \"example.com\" is not a loopback address"},
+	}
+	for _, test := range table {
+		addr, err := localServeAddr(test.addr)
+		if test.err == "" {
+			assert.Nil(t, err, test.addr)
+			assert.Equal(t, test.expected, addr, test.addr)
+		} else {
+			assert.EqualError(t, err, test.err, test.addr)
+		}
+	}
+}
+
+// serveTestOptions returns options for running runCheck against
+// validCoverageOutput.
+func serveTestOptions() Options {
+	options := newTestOptions()
+	options.modulePath = "github.com/tobinjt/golang-coverage-check/"
+	options.serveAddr = "localhost:8080"
+	options.captureOutput = func(string, ...string) ([]string, error) {
+		return validCoverageOutput(), nil
+	}
+	return options
+}
+
+// serve sends a request to server as if it came from the report served on
+// localhost:8080.
+func serve(server http.Handler, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, nil)
+	request.Host = "localhost:8080"
+	request.Header.Set("Origin", "http://localhost:8080")
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestReportServer(t *testing.T) {
+	options := serveTestOptions()
+	run, err := runCheck(options)
+	assert.Nil(t, err)
+	server := &reportServer{options: options}
+	assert.Nil(t, server.update(run))
+
+	response := serve(server, http.MethodGet, "/")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Contains(t, response.Body.String(), "Rerun tests")
+	response = serve(server, http.MethodGet, "/file-0.html")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "<h1>golang-coverage-check.go</h1>")
+	response = serve(server, http.MethodGet, "/missing.html")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = serve(server, http.MethodGet, rerunPath)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+	server.pages = nil
+	response = serve(server, http.MethodPost, rerunPath)
+	assert.Equal(t, http.StatusSeeOther, response.Code)
+	assert.Equal(t, "/", response.Header().Get("Location"))
+	assert.Contains(t, server.pages, htmlIndexFile)
+
+	server.options.captureOutput = func(string, ...string) ([]string, error) {
+		return nil, errors.New("go test failed")
+	}
+	response = serve(server, http.MethodPost, rerunPath)
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "rerunning the tests failed: go test failed")
+
+	// Parsing an empty directory succeeds, but the source for the HTML report
+	// is missing.
+	server.options = serveTestOptions()
+	server.options.dirToParse = t.TempDir()
+	response = serve(server, http.MethodPost, rerunPath)
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "failed reading source for HTML report")
+}
+
+func TestReportServerCrossOrigin(t *testing.T) {
+	table := []struct {
+		desc    string
+		host    string
+		origin  string
+		referer string
+		code    int
+	}{
+		{
+			desc:   "same origin",
+			host:   "localhost:8080",
+			origin: "http://localhost:8080",
+			code:   http.StatusSeeOther,
+		},
+		{
+			desc:    "same origin from the referer",
+			host:    "localhost:8080",
+			referer: "http://localhost:8080/file-0.html",
+			code:    http.StatusSeeOther,
+		},
+		{
+			desc:   "another site",
+			host:   "localhost:8080",
+			origin: "https://example.com",
+			code:   http.StatusForbidden,
+		},
+		{
+			desc:    "another site with a matching referer",
+			host:    "localhost:8080",
+			origin:  "https://example.com",
+			referer: "http://localhost:8080/",
+			code:    http.StatusForbidden,
+		},
+		{
+			desc:    "another site from the referer",
+			host:    "localhost:8080",
+			referer: "http://localhost:8080.example.com/",
+			code:    http.StatusForbidden,
+		},
+		{
+			desc: "neither origin nor referer",
+			host: "localhost:8080",
+			code: http.StatusForbidden,
+		},
+	}
+
+	options := serveTestOptions()
+	run, err := runCheck(options)
+	assert.Nil(t, err)
+	server := &reportServer{options: options}
+	assert.Nil(t, server.update(run))
+	for _, test := range table {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, rerunPath, nil)
+		request.Host = test.host
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		if test.referer != "" {
+			request.Header.Set("Referer", test.referer)
+		}
+		server.ServeHTTP(recorder, request)
+		assert.Equal(t, test.code, recorder.Code, test.desc)
+		if test.code == http.StatusForbidden {
+			assert.Contains(t, recorder.Body.String(), "rerunning the tests is only allowed from the report", test.desc)
+		}
+	}
+}
+
+func TestReportServerHost(t *testing.T) {
+	table := []struct {
+		desc      string
+		serveAddr string
+		method    string
+		path      string
+		host      string
+		code      int
+	}{
+		{
+			desc:      "the bound address",
+			serveAddr: "127.0.0.1:8080",
+			method:    http.MethodGet,
+			path:      "/",
+			host:      "127.0.0.1:8080",
+			code:      http.StatusOK,
+		},
+		{
+			desc:      "localhost when bound to an IP address",
+			serveAddr: "[::1]:8080",
+			method:    http.MethodPost,
+			path:      rerunPath,
+			host:      "localhost:8080",
+			code:      http.StatusSeeOther,
+		},
+		{
+			desc:      "DNS rebinding",
+			serveAddr: "localhost:8080",
+			method:    http.MethodGet,
+			path:      "/file-0.html",
+			host:      "attacker.example.com:8080",
+			code:      http.StatusForbidden,
+		},
+		{
+			desc:      "DNS rebinding to rerun the tests",
+			serveAddr: "localhost:8080",
+			method:    http.MethodPost,
+			path:      rerunPath,
+			host:      "attacker.example.com:8080",
+			code:      http.StatusForbidden,
+		},
+		{
+			desc:      "another port",
+			serveAddr: "localhost:8080",
+			method:    http.MethodGet,
+			path:      "/",
+			host:      "localhost:9090",
+			code:      http.StatusForbidden,
+		},
+		{
+			desc:      "no port means port 80",
+			serveAddr: "localhost:8080",
+			method:    http.MethodGet,
+			path:      "/",
+			host:      "localhost",
+			code:      http.StatusForbidden,
+		},
+		{
+			desc:      "no port on port 80",
+			serveAddr: "localhost:80",
+			method:    http.MethodGet,
+			path:      "/",
+			host:      "localhost",
+			code:      http.StatusOK,
+		},
+	}
+
+	options := serveTestOptions()
+	run, err := runCheck(options)
+	assert.Nil(t, err)
+	for _, test := range table {
+		server := &reportServer{options: options}
+		server.options.serveAddr = test.serveAddr
+		assert.Nil(t, server.update(run), test.desc)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.Host = test.host
+		request.Header.Set("Origin", "http://"+test.host)
+		server.ServeHTTP(recorder, request)
+		assert.Equal(t, test.code, recorder.Code, test.desc)
+		if test.code == http.StatusForbidden {
+			assert.Contains(t, recorder.Body.String(), "the report is only served on "+test.serveAddr, test.desc)
+		}
+	}
+}
+
+func TestServeReport(t *testing.T) {
+	options := serveTestOptions()
+	run, err := runCheck(options)
+	assert.Nil(t, err)
+	stdout := new(bytes.Buffer)
+	options.stdout = stdout
+	options.serveAddr = "localhost:8080"
+	var served http.Handler
+	options.listenAndServe = func(addr string, handler http.Handler) error {
+		assert.Equal(t, "localhost:8080", addr)
+		served = handler
+		return errors.New("server stopped")
+	}
+	assert.EqualError(t, serveReport(options, run), "server stopped")
+	assert.Equal(t, "Serving the coverage report on http://localhost:8080/\n", stdout.String())
+	assert.Equal(t, http.StatusOK, serve(served, http.MethodGet, "/").Code)
+
+	options.dirToParse = t.TempDir()
+	assert.ErrorContains(t, serveReport(options, run), "failed reading source for HTML report")
+}
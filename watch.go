@@ -0,0 +1,177 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+// watchInterval is how often --watch checks for changes.
+const watchInterval = time.Second
+
+// watchSnapshot records the size and modification time of the config and of
+// every .go file in options.dirToParse and its subdirectories, skipping
+// hidden directories.  Returns a map from path to size and modification time.
+func watchSnapshot(options Options) (map[string]string, error) {
+	snapshot := map[string]string{}
+	record := func(path string, info fs.FileInfo) {
+		snapshot[path] = fmt.Sprintf("%d %v", info.Size(), info.ModTime().UnixNano())
+	}
+	err := filepath.Walk(options.dirToParse, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != options.dirToParse && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			record(path, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed checking for changes: %w", err)
+	}
+	// A missing config is reported by runCheck.
+	if info, err := os.Stat(options.configFile); err == nil {
+		record(options.configFile, info)
+	}
+	return snapshot, nil
+}
+
+// changedFiles returns the number of files added, removed, or changed between
+// two snapshots.
+func changedFiles(previous, current map[string]string) int {
+	changed := 0
+	for path, state := range current {
+		if previous[path] != state {
+			changed++
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed++
+		}
+	}
+	return changed
+}
+
+// watchStatus summarises whether a function passed its checks.
+func watchStatus(result coveragecheck.CheckResult) string {
+	switch result.Status() {
+	case coveragecheck.StatusPassed:
+		return "passing"
+	case coveragecheck.StatusWarning:
+		return "warning"
+	}
+	return "failing"
+}
+
+// describeResult briefly describes a function's coverage for --watch.
+func describeResult(result coveragecheck.CheckResult) string {
+	return fmt.Sprintf("%s:%s %s (%.1f%%, required %.1f%%)",
+		result.Coverage.Filename, result.Coverage.LineNumber, qualifiedFunctionName(result),
+		result.Coverage.Coverage, result.RequiredCoverage)
+}
+
+// diffResults describes the functions whose status changed between two runs;
+// functions that are new in current are described if they aren't passing.
+func diffResults(previous, current []coveragecheck.CheckResult) []string {
+	previousStatus := map[string]string{}
+	for i, key := range coveragecheck.FunctionKeys(previous) {
+		previousStatus[key] = watchStatus(previous[i])
+	}
+	lines := []string{}
+	for i, key := range coveragecheck.FunctionKeys(current) {
+		result := current[i]
+		status := watchStatus(result)
+		before, ok := previousStatus[key]
+		if (ok && before != status) || (!ok && status != "passing") {
+			lines = append(lines, fmt.Sprintf("newly %s: %s", status, describeResult(result)))
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no functions changed status")
+	}
+	return lines
+}
+
+// summariseResults counts the failing functions for --watch.
+func summariseResults(results []coveragecheck.CheckResult) string {
+	failing := 0
+	for _, result := range results {
+		if watchStatus(result) == "failing" {
+			failing++
+		}
+	}
+	return fmt.Sprintf("%d of %d functions failing", failing, len(results))
+}
+
+// watch implements --watch: it checks coverage, then polls for changes to the
+// code or config, checking coverage again after each change and outputting
+// which functions newly pass or fail.  It only returns if polling fails, or
+// after options.maxWatchIterations polls when testing.
+func watch(options Options) error {
+	var snapshot map[string]string
+	var previous []coveragecheck.CheckResult
+	for i := 0; options.maxWatchIterations == 0 || i < options.maxWatchIterations; i++ {
+		if i > 0 {
+			options.sleep(watchInterval)
+		}
+		current, err := watchSnapshot(options)
+		if err != nil {
+			return err
+		}
+		lines := []string{}
+		if snapshot != nil {
+			changed := changedFiles(snapshot, current)
+			if changed == 0 {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("rerunning after changes to %d files", changed))
+		}
+		snapshot = current
+
+		run, err := runCheck(options)
+		if err != nil {
+			// Errors like failing to compile are expected while editing code.
+			lines = append(lines, err.Error())
+		} else {
+			if previous == nil {
+				for _, result := range run.Results {
+					if watchStatus(result) != "passing" {
+						lines = append(lines, fmt.Sprintf("%s: %s", watchStatus(result), describeResult(result)))
+					}
+				}
+			} else {
+				lines = append(lines, diffResults(previous, run.Results)...)
+			}
+			lines = append(lines, summariseResults(run.Results))
+			previous = run.Results
+		}
+		fmt.Fprintln(options.stdout, strings.Join(lines, "\n"))
+	}
+	return nil
+}
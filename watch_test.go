@@ -0,0 +1,152 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tobinjt/golang-coverage-check/coveragecheck"
+)
+
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, []byte(contents), 0644))
+}
+
+func TestWatchSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "main.go"), "package main")
+	writeTestFile(t, filepath.Join(dir, "README.md"), "readme")
+	writeTestFile(t, filepath.Join(dir, "sub", "sub.go"), "package sub")
+	writeTestFile(t, filepath.Join(dir, ".git", "hidden.go"), "package hidden")
+	config := filepath.Join(dir, "config.yaml")
+	writeTestFile(t, config, "default_coverage: 100")
+
+	options := newTestOptions()
+	options.dirToParse = dir
+	options.configFile = config
+	snapshot, err := watchSnapshot(options)
+	assert.Nil(t, err)
+	paths := []string{}
+	for path := range snapshot {
+		paths = append(paths, path)
+	}
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "main.go"),
+		filepath.Join(dir, "sub", "sub.go"),
+		config,
+	}, paths)
+	assert.True(t, strings.HasPrefix(snapshot[filepath.Join(dir, "main.go")], "12 "))
+
+	// A missing config isn't an error.
+	options.configFile = filepath.Join(dir, "missing.yaml")
+	snapshot, err = watchSnapshot(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(snapshot))
+
+	options.dirToParse = filepath.Join(dir, "missing")
+	_, err = watchSnapshot(options)
+	assert.ErrorContains(t, err, "failed checking for changes: ")
+}
+
+func TestChangedFiles(t *testing.T) {
+	previous := map[string]string{"a.go": "1 1", "b.go": "2 2", "c.go": "3 3"}
+	current := map[string]string{"a.go": "1 1", "b.go": "2 3", "d.go": "4 4"}
+	assert.Equal(t, 0, changedFiles(previous, previous))
+	assert.Equal(t, 3, changedFiles(previous, current))
+}
+
+func TestDiffResults(t *testing.T) {
+	result := func(function string, coverage float64, passed bool, severity string) coveragecheck.CheckResult {
+		return coveragecheck.CheckResult{
+			Coverage: coveragecheck.CoverageLine{
+				Filename:   "foo.go",
+				LineNumber: "10",
+				Function:   function,
+				Coverage:   coverage,
+			},
+			RequiredCoverage: 80,
+			Passed:           passed,
+			Severity:         severity,
+		}
+	}
+	previous := []coveragecheck.CheckResult{
+		result("fixed", 50, false, coveragecheck.SeverityError),
+		result("broken", 90, true, coveragecheck.SeverityError),
+		result("unchanged", 50, false, coveragecheck.SeverityError),
+		result("removed", 50, false, coveragecheck.SeverityError),
+	}
+	current := []coveragecheck.CheckResult{
+		result("fixed", 90, true, coveragecheck.SeverityError),
+		result("broken", 50, false, coveragecheck.SeverityError),
+		result("unchanged", 60, false, coveragecheck.SeverityError),
+		result("addedPassing", 90, true, coveragecheck.SeverityError),
+		result("addedWarning", 50, false, coveragecheck.SeverityWarning),
+	}
+	// Functions with the same name are matched by their order.
+	previous = append(previous, result("String", 50, false, coveragecheck.SeverityError), result("String", 90, true, coveragecheck.SeverityError))
+	current = append(current, result("String", 50, false, coveragecheck.SeverityError), result("String", 90, true, coveragecheck.SeverityError))
+	assert.Equal(t, []string{
+		"newly passing: foo.go:10 fixed (90.0%, required 80.0%)",
+		"newly failing: foo.go:10 broken (50.0%, required 80.0%)",
+		"newly warning: foo.go:10 addedWarning (50.0%, required 80.0%)",
+	}, diffResults(previous, current))
+	assert.Equal(t, []string{"no functions changed status"}, diffResults(previous, previous))
+	assert.Equal(t, "3 of 7 functions failing", summariseResults(current))
+}
+
+func TestWatch(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, config, "default_coverage: 100\nrules:\n  - function_regex: ^main$\n    coverage: 0\n")
+	stdout := new(bytes.Buffer)
+	options := serveTestOptions()
+	options.configFile = config
+	options.stdout = stdout
+	options.maxWatchIterations = 4
+	sleeps := 0
+	options.sleep = func(duration time.Duration) {
+		assert.Equal(t, watchInterval, duration)
+		sleeps++
+		switch sleeps {
+		case 2:
+			writeTestFile(t, config, "default_coverage: 40\nrules:\n  - function_regex: ^main$\n    coverage: 0\n")
+		case 3:
+			writeTestFile(t, config, "this is not a valid config")
+		}
+	}
+	assert.Nil(t, watch(options))
+	assert.Equal(t, 3, sleeps)
+	output := stdout.String()
+	assert.Contains(t, output, "failing: golang-coverage-check.go:30 makeExampleConfig (50.0%, required 100.0%)\n")
+	assert.Contains(t, output, "rerunning after changes to 1 files\n"+
+		"newly passing: golang-coverage-check.go:30 makeExampleConfig (50.0%, required 40.0%)\n")
+	assert.Contains(t, output, "rerunning after changes to 1 files\nfailed parsing config "+config)
+	// The first poll after the initial run found no changes, so there are only
+	// three runs.
+	assert.Equal(t, 2, strings.Count(output, "rerunning after changes"))
+}
+
+func TestWatchSnapshotFails(t *testing.T) {
+	options := serveTestOptions()
+	options.dirToParse = filepath.Join(t.TempDir(), "missing")
+	assert.ErrorContains(t, watch(options), "failed checking for changes: ")
+}